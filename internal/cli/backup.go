@@ -1,12 +1,21 @@
 package cli
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"migr8/pkg/backup"
 	"migr8/pkg/config"
+	"migr8/pkg/database"
 )
 
 var backupCmd = &cobra.Command{
@@ -16,17 +25,41 @@ var backupCmd = &cobra.Command{
 Supports automated backups, listing, restoration, and cleanup operations.`,
 }
 
+var (
+	createTables        []string
+	createExcludeTables []string
+	createSchemaOnly    bool
+	createDataOnly      bool
+	createWhere         []string
+	createAnonymize     bool
+)
+
 var backupCreateCmd = &cobra.Command{
 	Use:   "create",
 	Short: "Create a database backup",
 	Long: `Create a new backup of the configured database.
-Supports optional compression and automatic timestamping.`,
+Supports optional compression and automatic timestamping. The backup is
+streamed straight to the configured storage backend.
+
+A backup can be limited to some tables (--table, --exclude-table), to the
+schema or the data (--schema-only, --data-only), and to the rows of a table
+matching a condition (--where 'orders:created_at > now() - interval 7 day').
+Partial backups are restored in place and leave other tables alone.
+
+--anonymize rewrites the columns listed in the anonymize section of the
+configuration as rows are dumped, so the backup never holds their original
+values. It always uses the native engine.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cfg, err := config.Load()
 		if err != nil {
 			return fmt.Errorf("failed to load config: %w", err)
 		}
 
+		scope, err := createScope()
+		if err != nil {
+			return err
+		}
+
 		backupManager, err := backup.NewBackupManager(cfg)
 		if err != nil {
 			return fmt.Errorf("failed to create backup manager: %w", err)
@@ -35,14 +68,24 @@ Supports optional compression and automatic timestamping.`,
 
 		fmt.Println("Creating database backup...")
 		
-		backupInfo, err := backupManager.Create()
+		backupInfo, err := backupManager.Create(backup.BackupOptions{
+			Scope:     scope,
+			Anonymize: createAnonymize,
+			Progress: func(p backup.BackupProgress) {
+				fmt.Fprintf(os.Stderr, "\r  %s dumped, %s/s   ", formatBytes(p.Bytes), formatBytes(int64(p.Throughput())))
+			},
+		})
+		fmt.Fprintln(os.Stderr)
 		if err != nil {
 			return fmt.Errorf("failed to create backup: %w", err)
 		}
 
 		compressionStatus := "uncompressed"
 		if backupInfo.Compressed {
-			compressionStatus = "compressed"
+			compressionStatus = backupInfo.Compression + " compressed"
+		}
+		if backupInfo.Encrypted {
+			compressionStatus += ", encrypted"
 		}
 
 		fmt.Printf("Backup created successfully!\n")
@@ -50,11 +93,62 @@ Supports optional compression and automatic timestamping.`,
 		fmt.Printf("Path: %s\n", backupInfo.Path)
 		fmt.Printf("Size: %.2f MB (%s)\n", float64(backupInfo.Size)/(1024*1024), compressionStatus)
 		fmt.Printf("Database: %s\n", backupInfo.DatabaseName)
+		if backupInfo.Scope != nil {
+			fmt.Printf("Scope: %s\n", backup.DescribeScope(backupInfo.Scope))
+		}
+		if backupInfo.Anonymized {
+			fmt.Printf("Anonymized: yes\n")
+		}
+		fmt.Printf("SHA-256: %s\n", backupInfo.SHA256)
+		if backupInfo.MigrationVersion != "" {
+			fmt.Printf("Migration: %s\n", backupInfo.MigrationVersion)
+		}
+		rate := backup.BackupProgress{Bytes: backupInfo.DumpSize, Elapsed: backupInfo.Duration}.Throughput()
+		fmt.Printf("Duration: %s (%s dumped, %s/s)\n", backupInfo.Duration.Round(time.Millisecond),
+			formatBytes(backupInfo.DumpSize), formatBytes(int64(rate)))
 
 		return nil
 	},
 }
 
+// formatBytes renders a byte count with a binary unit.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// createScope builds the backup scope from the create flags. Filters are
+// given as table:condition.
+func createScope() (database.DumpScope, error) {
+	scope := database.DumpScope{
+		Tables:        createTables,
+		ExcludeTables: createExcludeTables,
+		SchemaOnly:    createSchemaOnly,
+		DataOnly:      createDataOnly,
+	}
+
+	for _, filter := range createWhere {
+		table, condition, ok := strings.Cut(filter, ":")
+		if !ok || strings.TrimSpace(table) == "" || strings.TrimSpace(condition) == "" {
+			return scope, fmt.Errorf("invalid --where %q, expected table:condition", filter)
+		}
+		if scope.Where == nil {
+			scope.Where = make(map[string]string)
+		}
+		scope.Where[strings.TrimSpace(table)] = strings.TrimSpace(condition)
+	}
+
+	return scope, nil
+}
+
 var backupListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List available backups",
@@ -83,23 +177,38 @@ var backupListCmd = &cobra.Command{
 
 		fmt.Printf("Available Backups:\n")
 		fmt.Printf("==================\n\n")
-		fmt.Printf("%-30s %-15s %-10s %-12s %s\n", "Filename", "Database", "Size", "Compression", "Created")
-		fmt.Printf("%s\n", strings.Repeat("-", 80))
+		fmt.Printf("%-30s %-15s %-10s %-12s %-10s %-17s %-10s %s\n", "Filename", "Database", "Size", "Compression", "Encrypted", "Created", "Duration", "Migration")
+		fmt.Printf("%s\n", strings.Repeat("-", 132))
 
 		for _, backup := range backups {
-			compressionStatus := "No"
+			compressionStatus := "none"
 			if backup.Compressed {
-				compressionStatus = "Yes"
+				compressionStatus = backup.Compression
+			}
+
+			encryptionStatus := "No"
+			if backup.Encrypted {
+				encryptionStatus = "Yes"
 			}
 
 			sizeStr := fmt.Sprintf("%.1f MB", float64(backup.Size)/(1024*1024))
+
+			migrationVersion := backup.MigrationVersion
+			duration := backup.Duration.Round(100 * time.Millisecond).String()
+			if !backup.HasManifest {
+				migrationVersion = "(no manifest)"
+				duration = "-"
+			}
 			
-			fmt.Printf("%-30s %-15s %-10s %-12s %s\n",
+			fmt.Printf("%-30s %-15s %-10s %-12s %-10s %-17s %-10s %s\n",
 				backup.Filename,
 				backup.DatabaseName,
 				sizeStr,
 				compressionStatus,
+				encryptionStatus,
 				backup.CreatedAt.Format("2006-01-02 15:04"),
+				duration,
+				migrationVersion,
 			)
 		}
 
@@ -107,11 +216,34 @@ var backupListCmd = &cobra.Command{
 	},
 }
 
+var (
+	restoreYes            bool
+	restorePreBackup      bool
+	restoreInPlace        bool
+	restoreTargetDatabase string
+	restoreTargetHost     string
+	restoreTargetEnv      string
+)
+
 var backupRestoreCmd = &cobra.Command{
-	Use:   "restore [backup_file]",
+	Use:   "restore [backup]",
 	Short: "Restore from a backup",
-	Long: `Restore the database from the specified backup file.
-The backup file should be located in the configured backup directory.`,
+	Long: `Restore the database from the specified backup. The argument is either
+a local backup file or the name of a backup in the configured storage
+(local directory, S3 bucket or SFTP server), as shown by 'backup list'.
+
+The backup's manifest is shown next to the target database and the restore
+only proceeds once the target database name is typed back (or --yes is
+given). Where the driver supports it (PostgreSQL, CockroachDB, SQLite) the
+backup is restored into a scratch database first and swapped in on success,
+keeping the replaced database under a _before_restore_<timestamp> name;
+otherwise it is applied in place and stops at the first SQL error.
+
+To restore somewhere other than the configured database, for example to
+clone production into staging, use --target-env to pick an environment from
+the config file and/or --target-host and --target-database. A missing
+target database is created first. Backups are looked up in the configured
+backup storage, so a unique prefix of the backup name is enough.`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cfg, err := config.Load()
@@ -119,29 +251,204 @@ The backup file should be located in the configured backup directory.`,
 			return fmt.Errorf("failed to load config: %w", err)
 		}
 
-		backupManager, err := backup.NewBackupManager(cfg)
+		target, err := restoreTarget(cfg)
+		if err != nil {
+			return err
+		}
+
+		if target != cfg {
+			created, err := database.EnsureDatabase(target.Database)
+			if err != nil {
+				return err
+			}
+			if created {
+				fmt.Printf("Created database %s on %s\n", target.Database.Database, target.Database.Host)
+			}
+		}
+
+		backupManager, err := backup.NewBackupManager(target)
 		if err != nil {
 			return fmt.Errorf("failed to create backup manager: %w", err)
 		}
 		defer backupManager.Close()
 
+		return restoreBackup(backupManager, args[0], restoreYes, restorePreBackup, restoreInPlace)
+	},
+}
+
+// restoreBackup shows the restore plan for ref, asks for the target
+// database name to be typed back unless yes is set, and restores.
+func restoreBackup(backupManager *backup.BackupManager, ref string, yes, preBackup, inPlace bool) error {
+	plan, err := backupManager.PlanRestore(ref)
+	if err != nil {
+		return err
+	}
+
+	printRestorePlan(plan)
+
+	if !yes {
+		fmt.Printf("\nThis will replace the contents of %s.\n", plan.TargetName())
+		fmt.Printf("Type the database name to confirm: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(answer) != plan.TargetName() {
+			return fmt.Errorf("restore aborted: confirmation did not match %q", plan.TargetName())
+		}
+	}
+
+	opts := backup.RestoreOptions{
+		PreRestoreBackup: preBackup,
+		Swap:             plan.CanSwap && !inPlace,
+	}
+
+	if opts.PreRestoreBackup {
+		fmt.Println("Taking pre-restore backup...")
+	}
+	if opts.Swap {
+		fmt.Println("Restoring into a scratch database...")
+	} else {
+		fmt.Println("Restoring in place...")
+	}
+
+	result, err := backupManager.Restore(ref, opts)
+	if result != nil && result.PreRestoreBackup != nil {
+		fmt.Printf("Pre-restore backup: %s\n", result.PreRestoreBackup.Filename)
+	}
+	if err != nil {
+		if result != nil && result.Swapped {
+			fmt.Printf("Previous database kept as: %s\n", result.PreviousDatabase)
+		}
+		return fmt.Errorf("failed to restore backup: %w", err)
+	}
+
+	fmt.Println("Database restored successfully!")
+	if result.Swapped {
+		fmt.Printf("Previous database kept as: %s\n", result.PreviousDatabase)
+	}
+	return nil
+}
+
+// restoreTarget applies the --target-* flags to cfg. It returns cfg itself
+// when none were given.
+func restoreTarget(cfg *config.Config) (*config.Config, error) {
+	if restoreTargetEnv == "" && restoreTargetHost == "" && restoreTargetDatabase == "" {
+		return cfg, nil
+	}
+
+	target := *cfg
+	if restoreTargetEnv != "" {
+		envCfg, err := cfg.ForEnvironment(restoreTargetEnv)
+		if err != nil {
+			return nil, err
+		}
+		target = *envCfg
+	}
+
+	target.Database = target.Database.Merge(config.DatabaseConfig{
+		Host:     restoreTargetHost,
+		Database: restoreTargetDatabase,
+	})
+	return &target, nil
+}
+
+func printRestorePlan(plan *backup.RestorePlan) {
+	fmt.Printf("%-12s %-40s %s\n", "", "Backup", "Target")
+	fmt.Printf("%s\n", strings.Repeat("-", 90))
+
+	source := plan.Manifest
+	if source == nil {
+		source = &backup.Manifest{}
+	}
+	row := func(label, backupValue, targetValue string) {
+		if backupValue == "" {
+			backupValue = "-"
+		}
+		fmt.Printf("%-12s %-40s %s\n", label, backupValue, targetValue)
+	}
+
+	created := ""
+	if !source.CreatedAt.IsZero() {
+		created = source.CreatedAt.Local().Format("2006-01-02 15:04:05")
+	}
+
+	row("Name", plan.Backup.Filename, "")
+	row("Driver", source.Driver, plan.Target.Driver)
+	row("Host", source.Host, plan.Target.Host)
+	row("Database", source.Database, plan.Target.Database)
+	row("Migration", source.MigrationVersion, plan.TargetMigration)
+	row("Created", created, "")
+	if plan.Manifest != nil {
+		row("Scope", backup.DescribeScope(plan.Manifest.Scope), "")
+	}
+
+	for _, warning := range plan.Warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+}
+
+var backupVerifyCmd = &cobra.Command{
+	Use:   "verify [backup]",
+	Short: "Verify a backup against its manifest",
+	Long: `Re-compute the SHA-256 checksum and size of a backup file and compare
+them with its manifest. Compressed backups are also fully decompressed to
+check the integrity of the gzip stream, and encrypted backups are decrypted
+when the configured identity file or passphrase is available.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.Load()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
 		backupFile := args[0]
-		fmt.Printf("Restoring database from backup: %s\n", backupFile)
-		
-		if err := backupManager.Restore(backupFile); err != nil {
-			return fmt.Errorf("failed to restore backup: %w", err)
+		fmt.Printf("Verifying backup: %s\n", backupFile)
+
+		var manifest *backup.Manifest
+		var contentChecked bool
+		if _, statErr := os.Stat(backupFile); statErr == nil {
+			manifest, contentChecked, err = backup.Verify(backupFile, cfg.Backup.Encryption)
+		} else {
+			storage, storageErr := backup.NewStorage(cfg.Backup)
+			if storageErr != nil {
+				return fmt.Errorf("failed to open backup storage: %w", storageErr)
+			}
+			defer storage.Close()
+			manifest, contentChecked, err = backup.VerifyStored(storage, path.Base(backupFile), cfg.Backup.Encryption)
+		}
+		if err != nil {
+			return fmt.Errorf("backup verification failed: %w", err)
 		}
 
-		fmt.Println("Database restored successfully!")
+		fmt.Printf("✓ Backup is intact\n")
+		if !contentChecked {
+			fmt.Printf("  (checksum only: no key configured to decrypt %s contents)\n", manifest.Encryption)
+		}
+		fmt.Printf("SHA-256:   %s\n", manifest.SHA256)
+		fmt.Printf("Database:  %s (%s on %s)\n", manifest.Database, manifest.Driver, manifest.Host)
+		fmt.Printf("Created:   %s\n", manifest.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+		if manifest.MigrationVersion != "" {
+			fmt.Printf("Migration: %s\n", manifest.MigrationVersion)
+		}
 		return nil
 	},
 }
 
+var (
+	cleanDryRun   bool
+	cleanDatabase string
+)
+
 var backupCleanCmd = &cobra.Command{
 	Use:   "clean",
 	Short: "Clean old backups",
-	Long: `Remove old backups based on the configured retention policy.
-Backups older than the retention period will be permanently deleted.`,
+	Long: `Remove backups that are not selected by the configured retention policy.
+Backups newer than retention_days are kept, as are those selected by the
+keep_last, keep_daily, keep_weekly, keep_monthly and keep_yearly rules. The
+newest min_keep backups of every database are never removed. Rules are
+applied per database, told apart by driver, host, port and name, and to
+whichever storage backend is configured. --database limits cleaning to the
+databases of that name.
+
+Use --dry-run to see what would be removed and why.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cfg, err := config.Load()
 		if err != nil {
@@ -154,16 +461,158 @@ Backups older than the retention period will be permanently deleted.`,
 		}
 		defer backupManager.Close()
 
-		fmt.Printf("Cleaning backups older than %d days...\n", cfg.Backup.RetentionDays)
-		
-		return backupManager.CleanOld()
+		decisions, err := backupManager.Clean(backup.BackupSource{Database: cleanDatabase}, cleanDryRun)
+		if err != nil {
+			return fmt.Errorf("failed to clean backups: %w", err)
+		}
+
+		if len(decisions) == 0 {
+			fmt.Println("No backups found.")
+			return nil
+		}
+
+		var removed, failed int
+		for _, decision := range decisions {
+			action := "keep"
+			switch {
+			case decision.Keep:
+			case decision.Err != nil:
+				action = "FAILED"
+				failed++
+			case cleanDryRun:
+				action = "would delete"
+				removed++
+			default:
+				action = "deleted"
+				removed++
+			}
+
+			fmt.Printf("%-12s %-45s %s\n", action, decision.Backup.Filename, strings.Join(decision.Reasons, ", "))
+			if decision.Err != nil {
+				fmt.Printf("%-12s %v\n", "", decision.Err)
+			}
+		}
+
+		fmt.Println()
+		if cleanDryRun {
+			fmt.Printf("Dry run: %d of %d backups would be deleted\n", removed, len(decisions))
+		} else {
+			fmt.Printf("Deleted %d of %d backups\n", removed, len(decisions))
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("failed to delete %d backups", failed)
+		}
+		return nil
+	},
+}
+
+var scheduleStatus bool
+
+var backupScheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Run scheduled backups",
+	Long: `Run a long-lived process that backs up databases on cron schedules and
+applies the retention policy after every backup.
+
+backup.schedule.cron schedules the configured database; every environment
+with a backup_schedule is backed up on its own schedule. Failed runs are
+retried backup.schedule.retries times with a growing delay. A run that is
+still in progress when the next one is due causes that one to be skipped.
+
+The state of every schedule (next run, last success and failure, last error)
+is kept in backup.schedule.state_file; --status prints it. SIGTERM or
+Ctrl-C stops the scheduler once running backups have finished.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.Load()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		if scheduleStatus {
+			state, err := backup.ReadScheduleState(cfg.Backup.Schedule.StateFile)
+			if err != nil {
+				return err
+			}
+			printScheduleState(state)
+			return nil
+		}
+
+		scheduler, err := backup.NewScheduler(cfg, os.Stdout)
+		if err != nil {
+			return err
+		}
+
+		for _, job := range scheduler.Jobs() {
+			fmt.Printf("Scheduled %s (%s) at %q\n", job.Name, job.Config.Database.Database, job.Schedule)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		err = scheduler.Run(ctx)
+		fmt.Println("Scheduler stopped.")
+		return err
 	},
 }
 
+func printScheduleState(state map[string]backup.JobState) {
+	names := make([]string, 0, len(state))
+	for name := range state {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	format := func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Local().Format("2006-01-02 15:04:05")
+	}
+
+	fmt.Printf("%-12s %-15s %-8s %-20s %-20s %-20s %s\n", "Schedule", "Cron", "Running", "Next run", "Last success", "Last failure", "Failures")
+	fmt.Printf("%s\n", strings.Repeat("-", 115))
+	for _, name := range names {
+		st := state[name]
+		running := "no"
+		if st.Running {
+			running = "yes"
+		}
+		fmt.Printf("%-12s %-15s %-8s %-20s %-20s %-20s %d\n", name, st.Schedule, running,
+			format(st.NextRun), format(st.LastSuccess), format(st.LastFailure), st.ConsecutiveFailures)
+		if st.LastError != "" {
+			fmt.Printf("%-12s last error: %s\n", "", st.LastError)
+		}
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(backupCmd)
 	backupCmd.AddCommand(backupCreateCmd)
 	backupCmd.AddCommand(backupListCmd)
 	backupCmd.AddCommand(backupRestoreCmd)
+	backupCmd.AddCommand(backupVerifyCmd)
 	backupCmd.AddCommand(backupCleanCmd)
+	backupCmd.AddCommand(backupScheduleCmd)
+
+	backupCreateCmd.Flags().StringSliceVar(&createTables, "table", nil, "only back up these tables (repeatable or comma-separated)")
+	backupCreateCmd.Flags().StringSliceVar(&createExcludeTables, "exclude-table", nil, "leave these tables out (repeatable or comma-separated)")
+	backupCreateCmd.Flags().BoolVar(&createSchemaOnly, "schema-only", false, "back up table definitions without data")
+	backupCreateCmd.Flags().BoolVar(&createDataOnly, "data-only", false, "back up data without table definitions")
+	backupCreateCmd.Flags().StringArrayVar(&createWhere, "where", nil, "only back up rows of a table matching a condition, as table:condition (repeatable)")
+	backupCreateCmd.Flags().BoolVar(&createAnonymize, "anonymize", false, "apply the anonymize rules of the configuration to the rows")
+
+	backupRestoreCmd.Flags().BoolVarP(&restoreYes, "yes", "y", false, "skip the typed confirmation")
+	backupRestoreCmd.Flags().BoolVar(&restorePreBackup, "pre-backup", false, "back up the target before restoring")
+	backupRestoreCmd.Flags().BoolVar(&restoreInPlace, "in-place", false, "restore directly into the target instead of a scratch database")
+	backupRestoreCmd.Flags().StringVar(&restoreTargetEnv, "target-env", "", "restore into the database of this environment")
+	backupRestoreCmd.Flags().StringVar(&restoreTargetHost, "target-host", "", "restore into a database on this host")
+	backupRestoreCmd.Flags().StringVar(&restoreTargetDatabase, "target-database", "", "restore into this database, creating it if missing")
+
+	backupCleanCmd.Flags().BoolVar(&cleanDryRun, "dry-run", false, "show what would be deleted without deleting anything")
+	backupCleanCmd.Flags().StringVar(&cleanDatabase, "database", "", "only clean backups of this database")
+
+	backupScheduleCmd.Flags().BoolVar(&scheduleStatus, "status", false, "print the state of a running scheduler and exit")
+
+	backup.ToolVersion = Version
 }
\ No newline at end of file
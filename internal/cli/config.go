@@ -3,6 +3,8 @@ package cli
 import (
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
@@ -43,12 +45,26 @@ This generates a .migr8.yaml file with default settings that you can customize.`
 				Table:     "schema_migrations",
 			},
 			Backup: config.BackupConfig{
-				Directory:     "./backups",
-				Compression:   true,
-				RetentionDays: 30,
+				Directory:            "./backups",
+				Compression:          true,
+				CompressionAlgorithm: "gzip",
+				RetentionDays:        30,
+				Engine:               "external",
+				BatchSize:            500,
+				Encryption: config.EncryptionConfig{
+					PassphraseEnv: "MIGR8_BACKUP_PASSPHRASE",
+				},
+				Retention: config.RetentionConfig{
+					MinKeep: 1,
+				},
+				Storage: config.StorageConfig{
+					Type: "local",
+				},
 			},
 			Seed: config.SeedConfig{
 				Directory: "./seeds",
+				Table:     "seed_history",
+				BatchSize: 1000,
 			},
 			Verbose: false,
 		}
@@ -63,7 +79,7 @@ This generates a .migr8.yaml file with default settings that you can customize.`
 # This file contains the configuration for Migr8 database operations.
 # Customize the settings below to match your environment.
 #
-# Supported database drivers: postgres, mysql, sqlite3
+# Supported database drivers: postgres, cockroach, mysql, sqlserver, sqlite3, sqlite (pure Go)
 # 
 # You can also use environment variables:
 # - MIGR8_DB_HOST, MIGR8_DB_PORT, MIGR8_DB_USER, etc.
@@ -108,14 +124,58 @@ var configShowCmd = &cobra.Command{
 		fmt.Printf("\nMigration:\n")
 		fmt.Printf("  Directory: %s\n", cfg.Migration.Directory)
 		fmt.Printf("  Table:     %s\n", cfg.Migration.Table)
+		fmt.Printf("  Backup before up: %t\n", cfg.Migration.BackupBeforeUp)
 
 		fmt.Printf("\nBackup:\n")
 		fmt.Printf("  Directory:     %s\n", cfg.Backup.Directory)
-		fmt.Printf("  Compression:   %t\n", cfg.Backup.Compression)
+		if cfg.Backup.Compression {
+			fmt.Printf("  Compression:   %s (level %d)\n", cfg.Backup.CompressionAlgorithm, cfg.Backup.CompressionLevel)
+		} else {
+			fmt.Printf("  Compression:   none\n")
+		}
 		fmt.Printf("  Retention:     %d days\n", cfg.Backup.RetentionDays)
+		r := cfg.Backup.Retention
+		fmt.Printf("  Keep:          last %d, daily %d, weekly %d, monthly %d, yearly %d (min %d)\n",
+			r.KeepLast, r.KeepDaily, r.KeepWeekly, r.KeepMonthly, r.KeepYearly, r.MinKeep)
+		fmt.Printf("  Engine:        %s\n", cfg.Backup.Engine)
+		fmt.Printf("  Encryption:    %t\n", cfg.Backup.Encryption.Enabled)
+		switch cfg.Backup.Storage.Type {
+		case "s3":
+			fmt.Printf("  Storage:       s3://%s/%s (%s)\n", cfg.Backup.Storage.S3.Bucket, cfg.Backup.Storage.S3.Prefix, cfg.Backup.Storage.S3.Endpoint)
+		case "sftp":
+			fmt.Printf("  Storage:       sftp://%s@%s:%d/%s\n", cfg.Backup.Storage.SFTP.Username, cfg.Backup.Storage.SFTP.Host, cfg.Backup.Storage.SFTP.Port, cfg.Backup.Storage.SFTP.Directory)
+		default:
+			fmt.Printf("  Storage:       %s\n", cfg.Backup.Storage.Type)
+		}
 
 		fmt.Printf("\nSeed:\n")
-		fmt.Printf("  Directory: %s\n", cfg.Seed.Directory)
+		fmt.Printf("  Directory:   %s\n", cfg.Seed.Directory)
+		fmt.Printf("  Table:       %s\n", cfg.Seed.Table)
+		fmt.Printf("  Batch size:  %d\n", cfg.Seed.BatchSize)
+		if cfg.Seed.Environment != "" {
+			fmt.Printf("  Environment: %s\n", cfg.Seed.Environment)
+		}
+		if len(cfg.Seed.ProtectedEnvironments) > 0 {
+			fmt.Printf("  Protected:   %s\n", strings.Join(cfg.Seed.ProtectedEnvironments, ", "))
+		}
+
+		if len(cfg.Anonymize.Tables) > 0 {
+			fmt.Printf("\nAnonymize:\n")
+			fmt.Printf("  Salt: $%s\n", cfg.Anonymize.SaltEnv)
+			tables := make([]string, 0, len(cfg.Anonymize.Tables))
+			for table := range cfg.Anonymize.Tables {
+				tables = append(tables, table)
+			}
+			sort.Strings(tables)
+			for _, table := range tables {
+				var rules []string
+				for column, rule := range cfg.Anonymize.Tables[table] {
+					rules = append(rules, fmt.Sprintf("%s (%s)", column, rule))
+				}
+				sort.Strings(rules)
+				fmt.Printf("  %s: %s\n", table, strings.Join(rules, ", "))
+			}
+		}
 
 		fmt.Printf("\nOther:\n")
 		fmt.Printf("  Verbose: %t\n", cfg.Verbose)
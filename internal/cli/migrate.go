@@ -3,8 +3,10 @@ package cli
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"migr8/pkg/backup"
 	"migr8/pkg/config"
 	"migr8/pkg/migration"
 )
@@ -13,7 +15,7 @@ var migrateCmd = &cobra.Command{
 	Use:   "migrate",
 	Short: "Database migration operations",
 	Long: `Manage database schema migrations with full up/down support.
-Supports PostgreSQL, MySQL, and SQLite databases.`,
+Supports PostgreSQL, MySQL, SQL Server, and SQLite databases.`,
 }
 
 var migrateUpCmd = &cobra.Command{
@@ -123,10 +125,101 @@ This generates both up and down migration files with timestamp prefixes.`,
 	},
 }
 
+var (
+	restorePointList      bool
+	restorePointYes       bool
+	restorePointPreBackup bool
+	restorePointInPlace   bool
+)
+
+var migrateRestorePointCmd = &cobra.Command{
+	Use:   "restore-point [backup|migration]",
+	Short: "Restore the database to its state before a migrate up",
+	Long: `Restore one of the backups 'migrate up' takes before applying migrations
+when migration.backup_before_up is set. The argument is the backup's name
+(a unique prefix is enough), or a migration, by file name or timestamp, to
+return to the state before the run that applied it. Without an argument the
+latest restore point is used. Use --list to show the restore points.
+
+The restore is confirmed and carried out as by 'backup restore'.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.Load()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		migrator, err := migration.NewMigrator(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create migrator: %w", err)
+		}
+		points, err := migrator.RestorePoints()
+		// The restore may swap databases, which needs this connection gone.
+		migrator.Close()
+		if err != nil {
+			return fmt.Errorf("failed to list restore points: %w", err)
+		}
+
+		if restorePointList {
+			printRestorePoints(points)
+			return nil
+		}
+
+		ref := ""
+		if len(args) > 0 {
+			ref = args[0]
+		}
+		point, err := migration.FindRestorePoint(points, ref)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Restore point for migrate up %s\n\n", describeMigrationRange(point))
+
+		backupManager, err := backup.NewBackupManager(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create backup manager: %w", err)
+		}
+		defer backupManager.Close()
+
+		return restoreBackup(backupManager, point.Backup.Filename, restorePointYes, restorePointPreBackup, restorePointInPlace)
+	},
+}
+
+func printRestorePoints(points []migration.RestorePoint) {
+	if len(points) == 0 {
+		fmt.Println("No restore points found.")
+		return
+	}
+
+	fmt.Printf("%-45s %-20s %s\n", "Backup", "Created", "Migrations")
+	fmt.Printf("%s\n", strings.Repeat("-", 110))
+	for _, point := range points {
+		fmt.Printf("%-45s %-20s %s\n",
+			point.Backup.Filename,
+			point.Backup.CreatedAt.Format("2006-01-02 15:04:05"),
+			describeMigrationRange(&point))
+	}
+}
+
+func describeMigrationRange(point *migration.RestorePoint) string {
+	from := point.FromVersion
+	if from == "" {
+		from = "(none)"
+	}
+	return fmt.Sprintf("%s -> %s", from, point.ToVersion)
+}
+
 func init() {
 	rootCmd.AddCommand(migrateCmd)
 	migrateCmd.AddCommand(migrateUpCmd)
 	migrateCmd.AddCommand(migrateDownCmd)
 	migrateCmd.AddCommand(migrateStatusCmd)
 	migrateCmd.AddCommand(migrateCreateCmd)
+	migrateCmd.AddCommand(migrateRestorePointCmd)
+
+	migrateRestorePointCmd.Flags().BoolVar(&restorePointList, "list", false, "list restore points instead of restoring")
+	migrateRestorePointCmd.Flags().BoolVarP(&restorePointYes, "yes", "y", false, "skip the typed confirmation")
+	migrateRestorePointCmd.Flags().BoolVar(&restorePointPreBackup, "pre-backup", false, "back up the database before restoring")
+	migrateRestorePointCmd.Flags().BoolVar(&restorePointInPlace, "in-place", false, "restore directly into the database instead of a scratch database")
 }
\ No newline at end of file
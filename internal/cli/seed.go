@@ -15,15 +15,63 @@ var seedCmd = &cobra.Command{
 Populate your database with test or initial data for development and testing.`,
 }
 
+var (
+	seedRunForce bool
+	seedEnv      string
+	seedTags     []string
+	seedExport   seed.ExportOptions
+)
+
+// seedConfig loads the configuration and applies --env: the environment's
+// seed set is selected and, when it is one of the configured environments,
+// its database becomes the target.
+func seedConfig() (*config.Config, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	if seedEnv == "" {
+		return cfg, nil
+	}
+
+	if _, ok := cfg.Environments[seedEnv]; ok {
+		if cfg, err = cfg.ForEnvironment(seedEnv); err != nil {
+			return nil, err
+		}
+	}
+	cfg.Seed.Environment = seedEnv
+	return cfg, nil
+}
+
 var seedRunCmd = &cobra.Command{
 	Use:   "run",
-	Short: "Run all seed files",
-	Long: `Execute all seed files found in the configured seed directory.
-Processes both YAML and CSV files in alphabetical order.`,
+	Short: "Run new and changed seed files",
+	Long: `Execute the seed files found in the configured seed directory that are
+due. YAML, JSON, NDJSON, CSV and SQL seeds are processed together, in file
+name order except that seeds for a table run after the seeds of the tables
+it references through foreign keys or depends_on. Tables are truncated up
+front, in the reverse order, and the whole run is one transaction.
+
+Every seed that runs is recorded with its checksum in the seed history
+table (seed.table, default seed_history). A YAML seed's run: setting decides
+when it runs again: on-change (the default) reruns it when the file changes,
+once never reruns it, always reruns it every time. Other formats set it
+in their sidecar file (users.csv.yml).
+Use --force to run every seed regardless.
+
+Seeds directly in the seed directory and in its common/ subdirectory run in
+every environment; --env dev adds those in dev/ (and targets the dev
+database when environments.dev is configured). --tag runs only the seeds
+with one of the given tags. Environments listed in
+seed.protected_environments only ever run seeds tagged reference.
+
+CSV and generated rows are loaded in bulk, with COPY on PostgreSQL, LOAD
+DATA LOCAL INFILE on MySQL when local_infile is enabled, and multi-row
+INSERTs of seed.batch_size rows otherwise.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.Load()
+		cfg, err := seedConfig()
 		if err != nil {
-			return fmt.Errorf("failed to load config: %w", err)
+			return err
 		}
 
 		seeder, err := seed.NewSeeder(cfg)
@@ -34,7 +82,28 @@ Processes both YAML and CSV files in alphabetical order.`,
 
 		fmt.Println("Running database seeds...")
 		
-		return seeder.Run()
+		return seeder.Run(seed.RunOptions{Force: seedRunForce, Tags: seedTags})
+	},
+}
+
+var seedStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show seed status",
+	Long: `List the seed files with their run mode and when they last ran, marking
+new seeds with [ ] and changed seeds that will rerun with [~].`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := seedConfig()
+		if err != nil {
+			return err
+		}
+
+		seeder, err := seed.NewSeeder(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create seeder: %w", err)
+		}
+		defer seeder.Close()
+
+		return seeder.Status(seed.RunOptions{Tags: seedTags})
 	},
 }
 
@@ -42,7 +111,9 @@ var seedGenerateCmd = &cobra.Command{
 	Use:   "generate [table_name]",
 	Short: "Generate a seed template",
 	Long: `Generate a YAML seed template file for the specified table.
-This creates a sample seed file that you can modify with your data.`,
+The template has two sample rows with a value of the right type for each of
+the table's columns, which you replace with your data. When the table does
+not exist yet, sample id, name, email and created_at columns are used.`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cfg, err := config.Load()
@@ -68,8 +139,61 @@ This creates a sample seed file that you can modify with your data.`,
 	},
 }
 
+var seedExportCmd = &cobra.Command{
+	Use:   "export [table_name]",
+	Short: "Export table rows as a seed file",
+	Long: `Write the rows of a table to a seed file that loads them back, in the
+seed directory and named after the table unless --output is given.
+
+YAML and JSON seeds list the rows under data:. CSV seeds declare the
+column types and a \N null marker in comment lines above the header.
+--where and --limit select the rows to export, and --mask replaces the
+values of a text column with asterisks. --anonymize applies the rules of
+the anonymize section of the configuration instead, before rows reach the
+file. Rows are streamed to the file, so large tables can be exported.
+
+  migr8 seed export users --where "active = true" --limit 100 --mask email
+  migr8 seed export countries --format csv
+  migr8 seed export users --env production --anonymize`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := seedConfig()
+		if err != nil {
+			return err
+		}
+
+		seeder, err := seed.NewSeeder(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create seeder: %w", err)
+		}
+		defer seeder.Close()
+
+		if err := seeder.Export(args[0], seedExport); err != nil {
+			return fmt.Errorf("failed to export %s: %w", args[0], err)
+		}
+		return nil
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(seedCmd)
 	seedCmd.AddCommand(seedRunCmd)
+	seedCmd.AddCommand(seedStatusCmd)
 	seedCmd.AddCommand(seedGenerateCmd)
+	seedCmd.AddCommand(seedExportCmd)
+
+	seedRunCmd.Flags().BoolVar(&seedRunForce, "force", false, "run every seed, even if it is up to date")
+	for _, cmd := range []*cobra.Command{seedRunCmd, seedStatusCmd} {
+		cmd.Flags().StringVar(&seedEnv, "env", "", "environment whose seed set to add to the common seeds (default seed.environment)")
+		cmd.Flags().StringSliceVar(&seedTags, "tag", nil, "only seeds with one of these tags (repeatable)")
+	}
+
+	seedExportCmd.Flags().StringVar(&seedExport.Format, "format", seed.FormatYAML, "seed file format: yaml, json or csv")
+	seedExportCmd.Flags().StringVar(&seedExport.Where, "where", "", "SQL condition selecting the rows to export")
+	seedExportCmd.Flags().IntVar(&seedExport.Limit, "limit", 0, "export at most this many rows (0 for all)")
+	seedExportCmd.Flags().StringSliceVar(&seedExport.Mask, "mask", nil, "column whose values are replaced with asterisks (repeatable)")
+	seedExportCmd.Flags().StringVar(&seedExport.Output, "output", "", "file to write (default the table name in the seed directory)")
+	seedExportCmd.Flags().BoolVar(&seedExport.Force, "force", false, "overwrite an existing file")
+	seedExportCmd.Flags().BoolVar(&seedExport.Anonymize, "anonymize", false, "apply the anonymize rules of the configuration to the rows")
+	seedExportCmd.Flags().StringVar(&seedEnv, "env", "", "environment whose database to export from")
 }
\ No newline at end of file
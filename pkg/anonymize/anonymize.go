@@ -0,0 +1,277 @@
+// Package anonymize rewrites personal data in rows as they are read, so
+// exports and backups of production data can be shared without it.
+package anonymize
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"migr8/pkg/config"
+)
+
+// Rules a column can be given in anonymize.tables. Nullify is not spelled
+// null, which YAML reads as no rule at all.
+const (
+	// Hash replaces a value with 32 hex digits of its keyed hash.
+	Hash = "hash"
+	// FakeName replaces a value with a made-up first and last name.
+	FakeName = "fake_name"
+	// FakeEmail replaces a value with a made-up address at example.com.
+	FakeEmail = "fake_email"
+	// Nullify replaces a value with NULL.
+	Nullify = "nullify"
+	// PreserveFormat replaces every digit with a digit and every letter
+	// with a letter of the same case, keeping punctuation and length, so
+	// phone numbers and postcodes still look like phone numbers and
+	// postcodes. Numbers stay numbers.
+	PreserveFormat = "preserve_format"
+)
+
+// Anonymizer applies the configured rules. Every rule is a function of the
+// value and the salt only, not of the table or column, so a key given the
+// same rule in two tables still joins, and exports taken on different days
+// agree with each other.
+type Anonymizer struct {
+	key []byte
+	// tables maps lower-cased table names to lower-cased column names to
+	// rules; the configuration loader lower-cases keys anyway.
+	tables map[string]map[string]string
+}
+
+// New checks the rules of cfg and reads the salt from the environment
+// variable named by cfg.SaltEnv.
+func New(cfg config.AnonymizeConfig) (*Anonymizer, error) {
+	if len(cfg.Tables) == 0 {
+		return nil, fmt.Errorf("no anonymization rules are configured in anonymize.tables")
+	}
+
+	tables := make(map[string]map[string]string, len(cfg.Tables))
+	for table, columns := range cfg.Tables {
+		rules := make(map[string]string, len(columns))
+		for column, rule := range columns {
+			switch rule {
+			case Hash, FakeName, FakeEmail, Nullify, PreserveFormat:
+			default:
+				return nil, fmt.Errorf("anonymize.tables.%s.%s: unknown rule %q: expected %s, %s, %s, %s or %s",
+					table, column, rule, Hash, FakeName, FakeEmail, Nullify, PreserveFormat)
+			}
+			rules[strings.ToLower(column)] = rule
+		}
+		tables[strings.ToLower(table)] = rules
+	}
+
+	salt := os.Getenv(cfg.SaltEnv)
+	if salt == "" {
+		return nil, fmt.Errorf("anonymization needs a secret salt in $%s; without one, hashed values can be recovered by hashing guesses", cfg.SaltEnv)
+	}
+
+	return &Anonymizer{key: []byte(salt), tables: tables}, nil
+}
+
+// Tables lists the tables that have rules.
+func (a *Anonymizer) Tables() []string {
+	tables := make([]string, 0, len(a.tables))
+	for table := range a.tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// Table returns the rules for rows of table with the given columns, or nil
+// when the table has none. It fails when a rule names a column that is not
+// among columns, since a misspelt column would otherwise leak its data.
+// A nil Anonymizer has no rules.
+func (a *Anonymizer) Table(table string, columns []string) (*Table, error) {
+	if a == nil {
+		return nil, nil
+	}
+	rules, ok := a.tables[strings.ToLower(table)]
+	if !ok {
+		return nil, nil
+	}
+
+	t := &Table{anonymizer: a, columns: columns, rules: make([]string, len(columns))}
+	found := make(map[string]bool, len(rules))
+	for i, column := range columns {
+		if rule, ok := rules[strings.ToLower(column)]; ok {
+			t.rules[i] = rule
+			found[strings.ToLower(column)] = true
+		}
+	}
+
+	var missing []string
+	for column := range rules {
+		if !found[column] {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("anonymization rules name columns that %s does not have: %s", table, strings.Join(missing, ", "))
+	}
+	return t, nil
+}
+
+// Table anonymizes the rows of one table. A nil Table leaves rows alone.
+type Table struct {
+	anonymizer *Anonymizer
+	columns    []string
+	// rules holds the rule of each column, empty for columns without one.
+	rules []string
+}
+
+// Anonymizes reports whether column i has a rule.
+func (t *Table) Anonymizes(i int) bool {
+	return t != nil && t.rules[i] != ""
+}
+
+// Row replaces the values of the columns that have a rule, in place.
+func (t *Table) Row(values []interface{}) error {
+	if t == nil {
+		return nil
+	}
+	for i, rule := range t.rules {
+		if rule == "" {
+			continue
+		}
+		value, err := t.anonymizer.Value(rule, values[i])
+		if err != nil {
+			return fmt.Errorf("column %s: %w", t.columns[i], err)
+		}
+		values[i] = value
+	}
+	return nil
+}
+
+// Value applies rule to a value scanned from the database. NULL stays
+// NULL. Text comes back as a string, and numbers as the same type when the
+// rule preserves their format.
+func (a *Anonymizer) Value(rule string, value interface{}) (interface{}, error) {
+	if value == nil || rule == Nullify {
+		return nil, nil
+	}
+
+	var text string
+	number := false
+	switch v := value.(type) {
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	case int64:
+		text, number = strconv.FormatInt(v, 10), true
+	case float64:
+		text, number = strconv.FormatFloat(v, 'f', -1, 64), true
+	default:
+		return nil, fmt.Errorf("%s does not apply to %T values; use %s", rule, value, Nullify)
+	}
+
+	sum := a.mac(text, 0)
+	switch rule {
+	case Hash:
+		return hex.EncodeToString(sum[:16]), nil
+	case FakeName:
+		first, last := names(sum)
+		return first + " " + last, nil
+	case FakeEmail:
+		first, last := names(sum)
+		return fmt.Sprintf("%s.%s.%s@example.com", strings.ToLower(first), strings.ToLower(last), hex.EncodeToString(sum[4:9])), nil
+	case PreserveFormat:
+		scrambled := a.preserveFormat(text, number)
+		switch value.(type) {
+		case int64:
+			n, err := strconv.ParseInt(scrambled, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %d has no replacement that fits in 64 bits", rule, value)
+			}
+			return n, nil
+		case float64:
+			return strconv.ParseFloat(scrambled, 64)
+		}
+		return scrambled, nil
+	}
+	return nil, fmt.Errorf("unknown rule %q", rule)
+}
+
+// mac returns the keyed hash of text, and with a positive block, further
+// independent hashes for rules that need more bytes.
+func (a *Anonymizer) mac(text string, block int) []byte {
+	h := hmac.New(sha256.New, a.key)
+	h.Write([]byte(text))
+	if block > 0 {
+		var counter [4]byte
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		h.Write(counter[:])
+	}
+	return h.Sum(nil)
+}
+
+// preserveFormat replaces the letters and digits of text. Numbers keep a
+// non-zero leading digit, so they keep their number of digits.
+func (a *Anonymizer) preserveFormat(text string, number bool) string {
+	var (
+		b       strings.Builder
+		stream  []byte
+		block   int
+		leading = number
+	)
+	next := func() byte {
+		if len(stream) == 0 {
+			block++
+			stream = a.mac(text, block)
+		}
+		c := stream[0]
+		stream = stream[1:]
+		return c
+	}
+
+	for _, r := range text {
+		switch {
+		case unicode.IsDigit(r):
+			if leading && r != '0' {
+				b.WriteByte('1' + next()%9)
+			} else {
+				b.WriteByte('0' + next()%10)
+			}
+			leading = false
+		case unicode.IsUpper(r):
+			b.WriteByte('A' + next()%26)
+		case unicode.IsLetter(r):
+			b.WriteByte('a' + next()%26)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func names(sum []byte) (string, string) {
+	first := FirstNames[int(binary.BigEndian.Uint16(sum[0:2]))%len(FirstNames)]
+	last := LastNames[int(binary.BigEndian.Uint16(sum[2:4]))%len(LastNames)]
+	return first, last
+}
+
+// FirstNames and LastNames are the names fake names are made of, also used
+// by seed factories.
+var FirstNames = []string{
+	"Ada", "Alan", "Amara", "Ana", "Ben", "Carla", "Chen", "Dara", "David", "Elena",
+	"Emil", "Fatima", "Grace", "Hugo", "Ines", "Ivan", "Jonas", "Julia", "Kenji", "Lara",
+	"Leo", "Maya", "Mateo", "Nia", "Noah", "Olga", "Omar", "Priya", "Rosa", "Sam",
+	"Sofia", "Tariq", "Uma", "Victor", "Wei", "Yara", "Zoe",
+}
+
+var LastNames = []string{
+	"Adams", "Bakker", "Banda", "Costa", "Dubois", "Eriksen", "Fischer", "Garcia", "Haddad", "Ivanova",
+	"Jansen", "Kim", "Kowalski", "Lopez", "Mensah", "Meyer", "Nakamura", "Novak", "Okafor", "Olsen",
+	"Patel", "Quinn", "Rossi", "Santos", "Schmidt", "Singh", "Tanaka", "Tran", "Usman", "Visser",
+	"Wang", "Weber", "Young", "Zhang",
+}
@@ -0,0 +1,135 @@
+package anonymize
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"migr8/pkg/config"
+)
+
+func newTestAnonymizer(t *testing.T, salt string, tables map[string]map[string]string) *Anonymizer {
+	t.Helper()
+
+	t.Setenv("TEST_ANONYMIZE_SALT", salt)
+	a, err := New(config.AnonymizeConfig{SaltEnv: "TEST_ANONYMIZE_SALT", Tables: tables})
+	if err != nil {
+		t.Fatalf("Failed to create anonymizer: %v", err)
+	}
+	return a
+}
+
+func TestRules(t *testing.T) {
+	a := newTestAnonymizer(t, "pepper", map[string]map[string]string{"users": {"email": FakeEmail}})
+
+	tests := []struct {
+		rule    string
+		value   interface{}
+		pattern string
+	}{
+		{Hash, "ann@example.org", `^[0-9a-f]{32}$`},
+		{FakeName, "Ann Smith", `^[A-Z][a-z]+ [A-Z][a-z]+$`},
+		{FakeEmail, []byte("ann@example.org"), `^[a-z]+\.[a-z]+\.[0-9a-f]{10}@example\.com$`},
+		{PreserveFormat, "+1 (555) 010-9999 ext. A7", `^\+\d \(\d{3}\) \d{3}-\d{4} [a-z]{3}\. [A-Z]\d$`},
+		{PreserveFormat, int64(40213), `^[1-9]\d{4}$`},
+	}
+
+	for _, tt := range tests {
+		got, err := a.Value(tt.rule, tt.value)
+		if err != nil {
+			t.Errorf("%s(%s) failed: %v", tt.rule, tt.value, err)
+			continue
+		}
+		text := fmt.Sprint(got)
+		if !regexp.MustCompile(tt.pattern).MatchString(text) {
+			t.Errorf("%s(%s) = %s, want a match for %s", tt.rule, tt.value, text, tt.pattern)
+		}
+		if text == fmt.Sprintf("%s", tt.value) || text == fmt.Sprint(tt.value) {
+			t.Errorf("%s(%s) returned the value unchanged", tt.rule, tt.value)
+		}
+	}
+
+	number, _ := a.Value(PreserveFormat, int64(40213))
+	if _, ok := number.(int64); !ok {
+		t.Errorf("Expected preserve_format to keep numbers numeric, got %T", number)
+	}
+	if got, err := a.Value(Nullify, "secret"); got != nil || err != nil {
+		t.Errorf("Expected nullify to give NULL, got %v (%v)", got, err)
+	}
+	if got, err := a.Value(FakeName, nil); got != nil || err != nil {
+		t.Errorf("Expected NULL to stay NULL, got %v (%v)", got, err)
+	}
+	if _, err := a.Value(Hash, true); err == nil {
+		t.Error("Expected an error for hashing a boolean")
+	}
+}
+
+func TestConsistentMapping(t *testing.T) {
+	tables := map[string]map[string]string{
+		"users":  {"email": FakeEmail, "phone": PreserveFormat},
+		"orders": {"customer_email": FakeEmail},
+	}
+	a := newTestAnonymizer(t, "pepper", tables)
+
+	users, err := a.Table("users", []string{"id", "email", "phone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders, err := a.Table("Orders", []string{"id", "Customer_Email"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := []interface{}{int64(1), "ann@example.org", "555-0100"}
+	order := []interface{}{int64(7), []byte("ann@example.org")}
+	if err := users.Row(user); err != nil {
+		t.Fatal(err)
+	}
+	if err := orders.Row(order); err != nil {
+		t.Fatal(err)
+	}
+	if user[0] != int64(1) || order[0] != int64(7) {
+		t.Errorf("Expected columns without rules to be left alone, got %v and %v", user[0], order[0])
+	}
+	if user[1] != order[1] {
+		t.Errorf("Expected the same email to map to the same address in both tables, got %v and %v", user[1], order[1])
+	}
+
+	other, _ := a.Value(FakeEmail, "bob@example.org")
+	if other == user[1] {
+		t.Errorf("Expected different emails to map to different addresses, got %v twice", other)
+	}
+
+	salted := newTestAnonymizer(t, "salt", tables)
+	if got, _ := salted.Value(FakeEmail, "ann@example.org"); got == user[1] {
+		t.Errorf("Expected another salt to give another address, got %v", got)
+	}
+
+	if table, err := a.Table("posts", []string{"id"}); table != nil || err != nil {
+		t.Errorf("Expected no rules for a table without any, got %v (%v)", table, err)
+	}
+	if _, err := a.Table("users", []string{"id", "email"}); err == nil || !strings.Contains(err.Error(), "phone") {
+		t.Errorf("Expected an error naming the missing column, got %v", err)
+	}
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	t.Setenv("TEST_ANONYMIZE_SALT", "pepper")
+
+	_, err := New(config.AnonymizeConfig{SaltEnv: "TEST_ANONYMIZE_SALT", Tables: map[string]map[string]string{"users": {"email": "scramble"}}})
+	if err == nil || !strings.Contains(err.Error(), "unknown rule") {
+		t.Errorf("Expected an unknown rule error, got %v", err)
+	}
+
+	_, err = New(config.AnonymizeConfig{SaltEnv: "TEST_ANONYMIZE_SALT"})
+	if err == nil {
+		t.Error("Expected an error without rules")
+	}
+
+	t.Setenv("TEST_ANONYMIZE_SALT", "")
+	_, err = New(config.AnonymizeConfig{SaltEnv: "TEST_ANONYMIZE_SALT", Tables: map[string]map[string]string{"users": {"email": Hash}}})
+	if err == nil || !strings.Contains(err.Error(), "$TEST_ANONYMIZE_SALT") {
+		t.Errorf("Expected an error about the missing salt, got %v", err)
+	}
+}
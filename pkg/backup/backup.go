@@ -1,358 +1,523 @@
 package backup
 
 import (
-	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
+	"migr8/pkg/anonymize"
 	"migr8/pkg/config"
 	"migr8/pkg/database"
 )
 
+// legacyBackupName matches <database>_<YYYYMMDD>_<HHMMSS>[_<label>].sql[.gz|.zst];
+// the database part may itself contain underscores.
+var legacyBackupName = regexp.MustCompile(`^(.+?)_\d{8}_\d{6}(_[a-z0-9-]+)?\.sql(\.gz|\.zst)?(\.age)?$`)
+
 type BackupManager struct {
-	db     *database.DB
-	config *config.Config
+	db      *database.DB
+	config  *config.Config
+	storage Storage
 }
 
 type BackupInfo struct {
-	Filename    string
-	Path        string
-	Size        int64
-	CreatedAt   time.Time
-	Compressed  bool
-	DatabaseName string
+	Filename         string
+	Path             string
+	Size             int64
+	CreatedAt        time.Time
+	Compressed       bool
+	// Compression is the algorithm, empty for uncompressed backups.
+	Compression      string
+	Encrypted        bool
+	DatabaseName     string
+	Driver           string
+	// Host and Port are empty for backups without a manifest, and Port
+	// also for manifests written before it was recorded.
+	Host             string
+	Port             int
+	SHA256           string
+	MigrationVersion string
+	HasManifest      bool
+	// Scope is nil for backups of the whole database.
+	Scope *database.DumpScope
+	// DumpSize is the size of the dump before compression and encryption;
+	// Duration is how long taking and storing it took. Both are zero for
+	// backups without a manifest.
+	DumpSize int64
+	Duration time.Duration
+	// Tags are the BackupOptions.Tags the backup was taken with.
+	Tags map[string]string
+	// Anonymized is set for backups taken with BackupOptions.Anonymize.
+	Anonymized bool
+}
+
+// BackupOptions selects what Create backs up. The zero value takes a full
+// backup.
+type BackupOptions struct {
+	Scope database.DumpScope
+	// Progress, when set, is called about once a second while dumping and
+	// once more when the dump is complete.
+	Progress func(BackupProgress)
+	// Label is appended to the backup name after the timestamp, e.g.
+	// "pre-migrate". Lower-case letters, digits and dashes only.
+	Label string
+	// Tags are recorded in the manifest for tools to find the backup by.
+	Tags map[string]string
+	// Anonymize applies the rules in the anonymize section of the
+	// configuration while rows are dumped, so no raw row is ever stored.
+	// It takes the backup with the native engine, whatever is configured,
+	// since external dump tools write rows out themselves.
+	Anonymize bool
 }
 
+var backupLabel = regexp.MustCompile(`^[a-z0-9-]*$`)
+
 func NewBackupManager(cfg *config.Config) (*BackupManager, error) {
 	db, err := database.NewConnection(cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	storage, err := NewStorage(cfg.Backup)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	return &BackupManager{
-		db:     db,
-		config: cfg,
+		db:      db,
+		config:  cfg,
+		storage: storage,
 	}, nil
 }
 
 func (bm *BackupManager) Close() error {
+	bm.storage.Close()
 	return bm.db.Close()
 }
 
-func (bm *BackupManager) Create() (*BackupInfo, error) {
-	if err := os.MkdirAll(bm.config.Backup.Directory, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+func (bm *BackupManager) Create(opts BackupOptions) (*BackupInfo, error) {
+	if !backupLabel.MatchString(opts.Label) {
+		return nil, fmt.Errorf("invalid backup label %q: use lower-case letters, digits and dashes", opts.Label)
 	}
+	return bm.create(opts.Label, opts)
+}
 
-	timestamp := time.Now().Format("20060102_150405")
-	filename := fmt.Sprintf("%s_%s.sql", bm.config.Database.Database, timestamp)
-	
-	if bm.config.Backup.Compression {
-		filename += ".gz"
-	}
-	
-	backupPath := filepath.Join(bm.config.Backup.Directory, filename)
-
-	switch bm.db.Driver {
-	case "postgres":
-		return bm.createPostgresBackup(backupPath, timestamp)
-	case "mysql":
-		return bm.createMySQLBackup(backupPath, timestamp)
-	case "sqlite3":
-		return bm.createSQLiteBackup(backupPath, timestamp)
-	default:
-		return nil, fmt.Errorf("backup not supported for driver: %s", bm.db.Driver)
+// create takes a backup whose name carries label after the timestamp, so
+// backups taken automatically are recognisable and never collide with a
+// regular backup taken in the same second.
+func (bm *BackupManager) create(label string, opts BackupOptions) (*BackupInfo, error) {
+	scope, err := bm.resolveScope(opts.Scope)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup scope: %w", err)
 	}
-}
+	opts.Scope = scope
 
-func (bm *BackupManager) createPostgresBackup(backupPath, timestamp string) (*BackupInfo, error) {
-	args := []string{
-		"-h", bm.config.Database.Host,
-		"-p", fmt.Sprintf("%d", bm.config.Database.Port),
-		"-U", bm.config.Database.Username,
-		"-d", bm.config.Database.Database,
-		"--no-password",
-		"--verbose",
-		"--clean",
-		"--no-acl",
-		"--no-owner",
+	algorithm, err := compressionAlgorithm(bm.config.Backup)
+	if err != nil {
+		return nil, err
 	}
 
-	cmd := exec.Command("pg_dump", args...)
+	timestamp := time.Now().Format("20060102_150405")
+	// SQLite databases are file paths; only the file name belongs in the
+	// backup name.
+	base := fmt.Sprintf("%s_%s", filepath.Base(bm.config.Database.Database), timestamp)
+	extension := ".sql"
 	
-	env := os.Environ()
-	env = append(env, fmt.Sprintf("PGPASSWORD=%s", bm.config.Database.Password))
-	cmd.Env = env
+	if algorithm != "" {
+		extension += compressors[algorithm].suffix
+	}
 
-	return bm.executeBackupCommand(cmd, backupPath, timestamp)
-}
+	if bm.config.Backup.Encryption.Enabled {
+		extension += encryptedSuffix
+	}
 
-func (bm *BackupManager) createMySQLBackup(backupPath, timestamp string) (*BackupInfo, error) {
-	args := []string{
-		"-h", bm.config.Database.Host,
-		"-P", fmt.Sprintf("%d", bm.config.Database.Port),
-		"-u", bm.config.Database.Username,
-		fmt.Sprintf("-p%s", bm.config.Database.Password),
-		"--single-transaction",
-		"--routines",
-		"--triggers",
-		bm.config.Database.Database,
+	// Backups taken within the same second get a counter rather than
+	// overwriting each other.
+	filename := ""
+	for n := 1; ; n++ {
+		suffix := label
+		if n > 1 {
+			suffix = strings.TrimPrefix(fmt.Sprintf("%s-%d", label, n), "-")
+		}
+		filename = base
+		if suffix != "" {
+			filename += "_" + suffix
+		}
+		filename += extension
+		if !bm.exists(filename) {
+			break
+		}
 	}
 
-	cmd := exec.Command("mysqldump", args...)
-	
-	return bm.executeBackupCommand(cmd, backupPath, timestamp)
+	switch bm.engine(opts) {
+	case "native":
+		return bm.createNativeBackup(filename, algorithm, opts)
+	case "external", "":
+		cmds, err := bm.dumpCommands(scope)
+		if err != nil {
+			return nil, fmt.Errorf("backup not supported for driver %s: %w", bm.db.Driver, err)
+		}
+		return bm.executeBackupCommands(cmds, filename, algorithm, opts)
+	default:
+		return nil, fmt.Errorf("unknown backup engine: %s", bm.config.Backup.Engine)
+	}
 }
 
-func (bm *BackupManager) createSQLiteBackup(backupPath, timestamp string) (*BackupInfo, error) {
-	args := []string{
-		bm.config.Database.Database,
-		".dump",
+// engine returns the engine that takes the backup opts describes.
+func (bm *BackupManager) engine(opts BackupOptions) string {
+	if opts.Anonymize {
+		return "native"
 	}
-
-	cmd := exec.Command("sqlite3", args...)
-	
-	return bm.executeBackupCommand(cmd, backupPath, timestamp)
+	return bm.config.Backup.Engine
 }
 
-func (bm *BackupManager) executeBackupCommand(cmd *exec.Cmd, backupPath, timestamp string) (*BackupInfo, error) {
-	var file *os.File
-	var writer io.Writer
-	var err error
-
-	file, err = os.Create(backupPath)
+func (bm *BackupManager) createNativeBackup(name, algorithm string, opts BackupOptions) (*BackupInfo, error) {
+	dumper, err := newNativeDumper(bm.db, bm.config.Backup.BatchSize)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create backup file: %w", err)
+		return nil, err
+	}
+	if opts.Anonymize {
+		if dumper.anonymizer, err = anonymize.New(bm.config.Anonymize); err != nil {
+			return nil, err
+		}
 	}
-	defer file.Close()
 
-	writer = file
-	compressed := false
+	return bm.writeBackup(name, algorithm, opts, func(w io.Writer) error {
+		return dumper.Dump(w, bm.config.Database.Database, opts.Scope)
+	})
+}
 
-	if bm.config.Backup.Compression {
-		gzWriter := gzip.NewWriter(file)
-		defer gzWriter.Close()
-		writer = gzWriter
-		compressed = true
-	}
+// executeBackupCommands runs the dump commands one after another into the
+// same backup. The end of each tool's stderr is kept for the error message,
+// since that is where dump tools explain what went wrong.
+func (bm *BackupManager) executeBackupCommands(cmds []*exec.Cmd, name, algorithm string, opts BackupOptions) (*BackupInfo, error) {
+	return bm.writeBackup(name, algorithm, opts, func(w io.Writer) error {
+		for _, cmd := range cmds {
+			stderr := &tailBuffer{limit: 4096}
+			cmd.Stdout = w
+			cmd.Stderr = stderr
+			if err := cmd.Run(); err != nil {
+				if output := stderr.String(); output != "" {
+					return fmt.Errorf("backup command %s failed: %w: %s", filepath.Base(cmd.Path), err, output)
+				}
+				return fmt.Errorf("backup command %s failed: %w", filepath.Base(cmd.Path), err)
+			}
+		}
+		return nil
+	})
+}
 
-	cmd.Stdout = writer
-	
-	if err := cmd.Run(); err != nil {
-		os.Remove(backupPath)
-		return nil, fmt.Errorf("backup command failed: %w", err)
-	}
+// tailBuffer keeps the last limit bytes written to it, so chatty tools such
+// as pg_dump --verbose cannot grow it without bound.
+type tailBuffer struct {
+	limit     int
+	data      []byte
+	truncated bool
+}
 
-	fileInfo, err := file.Stat()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get backup file info: %w", err)
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.data = append(b.data, p...)
+	if over := len(b.data) - b.limit; over > 0 {
+		b.data = append(b.data[:0], b.data[over:]...)
+		b.truncated = true
 	}
-
-	return &BackupInfo{
-		Filename:     filepath.Base(backupPath),
-		Path:         backupPath,
-		Size:         fileInfo.Size(),
-		CreatedAt:    fileInfo.ModTime(),
-		Compressed:   compressed,
-		DatabaseName: bm.config.Database.Database,
-	}, nil
+	return len(p), nil
 }
 
-func (bm *BackupManager) List() ([]BackupInfo, error) {
-	if _, err := os.Stat(bm.config.Backup.Directory); os.IsNotExist(err) {
-		return []BackupInfo{}, nil
+func (b *tailBuffer) String() string {
+	output := strings.TrimSpace(string(b.data))
+	if b.truncated && output != "" {
+		return "..." + output
 	}
+	return output
+}
 
-	files, err := filepath.Glob(filepath.Join(bm.config.Backup.Directory, "*.sql*"))
-	if err != nil {
-		return nil, fmt.Errorf("failed to list backup files: %w", err)
+// writeBackup streams the output of dump into storage as name, compressing
+// and encrypting when configured, and records a manifest next to it.
+// Nothing is left behind in storage if dump fails.
+func (bm *BackupManager) writeBackup(name, algorithm string, opts BackupOptions, dump func(w io.Writer) error) (*BackupInfo, error) {
+	started := time.Now()
+
+	pr, pw := io.Pipe()
+	uploaded := make(chan error, 1)
+	go func() {
+		err := bm.storage.Put(name, pr)
+		// Unblock the writer if the upload gave up early.
+		pr.CloseWithError(err)
+		uploaded <- err
+	}()
+
+	fail := func(err error) (*BackupInfo, error) {
+		pw.CloseWithError(err)
+		<-uploaded
+		return nil, err
 	}
 
-	var backups []BackupInfo
-	for _, file := range files {
-		info, err := os.Stat(file)
+	// Data flows dump -> progress -> compression -> age -> hash -> file,
+	// so encryption is applied to the compressed stream and the checksum
+	// covers the bytes actually stored.
+	hashed := newHashingWriter(pw)
+	var writer io.Writer = hashed
+	var encWriter io.WriteCloser
+	var compWriter io.WriteCloser
+	encryption := ""
+
+	if bm.config.Backup.Encryption.Enabled {
+		var err error
+		encWriter, encryption, err = encryptWriter(hashed, bm.config.Backup.Encryption)
 		if err != nil {
-			continue
+			return fail(err)
 		}
+		writer = encWriter
+	}
 
-		filename := filepath.Base(file)
-		compressed := strings.HasSuffix(filename, ".gz")
-		
-		parts := strings.Split(filename, "_")
-		dbName := "unknown"
-		if len(parts) > 0 {
-			dbName = parts[0]
+	if algorithm != "" {
+		var err error
+		compWriter, err = compressors[algorithm].newWriter(writer, bm.config.Backup.CompressionLevel)
+		if err != nil {
+			return fail(fmt.Errorf("failed to start %s compression: %w", algorithm, err))
 		}
+		writer = compWriter
+	}
 
-		backup := BackupInfo{
-			Filename:     filename,
-			Path:         file,
-			Size:         info.Size(),
-			CreatedAt:    info.ModTime(),
-			Compressed:   compressed,
-			DatabaseName: dbName,
+	progress := newProgressWriter(writer, started, opts.Progress)
+
+	if err := dump(progress); err != nil {
+		return fail(err)
+	}
+	progress.report()
+
+	if compWriter != nil {
+		if err := compWriter.Close(); err != nil {
+			return fail(fmt.Errorf("failed to finish compressed backup: %w", err))
 		}
-		
-		backups = append(backups, backup)
 	}
 
-	return backups, nil
-}
+	if encWriter != nil {
+		if err := encWriter.Close(); err != nil {
+			return fail(fmt.Errorf("failed to finish encrypted backup: %w", err))
+		}
+	}
 
-func (bm *BackupManager) Restore(backupPath string) error {
-	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
-		return fmt.Errorf("backup file not found: %s", backupPath)
+	pw.Close()
+	if err := <-uploaded; err != nil {
+		return nil, fmt.Errorf("failed to store backup: %w", err)
 	}
 
-	switch bm.db.Driver {
-	case "postgres":
-		return bm.restorePostgresBackup(backupPath)
-	case "mysql":
-		return bm.restoreMySQLBackup(backupPath)
-	case "sqlite3":
-		return bm.restoreSQLiteBackup(backupPath)
-	default:
-		return fmt.Errorf("restore not supported for driver: %s", bm.db.Driver)
+	manifest := &Manifest{
+		Filename:         name,
+		SHA256:           hashed.Sum(),
+		Size:             hashed.size,
+		DumpSize:         progress.bytes,
+		Compressed:       algorithm != "",
+		Compression:      algorithm,
+		Encrypted:        encryption != "",
+		Encryption:       encryption,
+		Engine:           bm.engine(opts),
+		Driver:           bm.db.Driver,
+		Host:             bm.config.Database.Host,
+		Port:             bm.config.Database.Port,
+		Database:         bm.config.Database.Database,
+		CreatedAt:        time.Now().UTC(),
+		DurationMS:       time.Since(started).Milliseconds(),
+		Migr8Version:     ToolVersion,
+		MigrationVersion: bm.latestMigration(),
+		Tags:             opts.Tags,
+		Anonymized:       opts.Anonymize,
+	}
+	if !opts.Scope.IsFull() {
+		manifest.Scope = &opts.Scope
 	}
-}
 
-func (bm *BackupManager) restorePostgresBackup(backupPath string) error {
-	args := []string{
-		"-h", bm.config.Database.Host,
-		"-p", fmt.Sprintf("%d", bm.config.Database.Port),
-		"-U", bm.config.Database.Username,
-		"-d", bm.config.Database.Database,
-		"--no-password",
-		"--verbose",
+	if err := writeManifest(bm.storage, name, manifest); err != nil {
+		return nil, err
 	}
 
-	cmd := exec.Command("psql", args...)
-	
-	env := os.Environ()
-	env = append(env, fmt.Sprintf("PGPASSWORD=%s", bm.config.Database.Password))
-	cmd.Env = env
+	return manifest.info(name, bm.storage.Location(name)), nil
+}
 
-	return bm.executeRestoreCommand(cmd, backupPath)
+func (bm *BackupManager) exists(name string) bool {
+	object, err := bm.storage.Get(name)
+	if err != nil {
+		return false
+	}
+	object.Close()
+	return true
 }
 
-func (bm *BackupManager) restoreMySQLBackup(backupPath string) error {
-	args := []string{
-		"-h", bm.config.Database.Host,
-		"-P", fmt.Sprintf("%d", bm.config.Database.Port),
-		"-u", bm.config.Database.Username,
-		fmt.Sprintf("-p%s", bm.config.Database.Password),
-		bm.config.Database.Database,
+// latestMigration returns the most recently applied migration, or an empty
+// string when the history table does not exist yet.
+func (bm *BackupManager) latestMigration() string {
+	applied, err := bm.db.GetAppliedMigrations(bm.config.Migration.Table)
+	if err != nil || len(applied) == 0 {
+		return ""
 	}
+	return applied[len(applied)-1]
+}
 
-	cmd := exec.Command("mysql", args...)
-	
-	return bm.executeRestoreCommand(cmd, backupPath)
+func (m *Manifest) info(name, location string) *BackupInfo {
+	return &BackupInfo{
+		Filename:         name,
+		Path:             location,
+		Size:             m.Size,
+		CreatedAt:        m.CreatedAt.Local(),
+		Compressed:       m.Compressed,
+		Compression:      m.compression(),
+		Encrypted:        m.Encrypted,
+		DatabaseName:     m.Database,
+		Driver:           m.Driver,
+		Host:             m.Host,
+		Port:             m.Port,
+		SHA256:           m.SHA256,
+		MigrationVersion: m.MigrationVersion,
+		HasManifest:      true,
+		Scope:            m.Scope,
+		DumpSize:         m.DumpSize,
+		Duration:         time.Duration(m.DurationMS) * time.Millisecond,
+		Tags:             m.Tags,
+		Anonymized:       m.Anonymized,
+	}
 }
 
-func (bm *BackupManager) restoreSQLiteBackup(backupPath string) error {
-	tempFile := backupPath + ".tmp"
-	
-	if strings.HasSuffix(backupPath, ".gz") {
-		if err := bm.decompressFile(backupPath, tempFile); err != nil {
-			return err
-		}
-		defer os.Remove(tempFile)
-		backupPath = tempFile
+// List describes every backup in storage, newest last by name.
+func (bm *BackupManager) List() ([]BackupInfo, error) {
+	objects, err := bm.storage.List()
+	if err != nil {
+		return nil, err
 	}
 
-	args := []string{
-		bm.config.Database.Database,
-		fmt.Sprintf(".read %s", backupPath),
+	stored := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		stored[object.Name] = true
 	}
 
-	cmd := exec.Command("sqlite3", args...)
-	return cmd.Run()
-}
+	backups := []BackupInfo{}
+	for _, object := range objects {
+		if isManifest(object.Name) || !strings.Contains(object.Name, ".sql") {
+			continue
+		}
 
-func (bm *BackupManager) executeRestoreCommand(cmd *exec.Cmd, backupPath string) error {
-	var reader io.Reader
-	
-	if strings.HasSuffix(backupPath, ".gz") {
-		file, err := os.Open(backupPath)
-		if err != nil {
-			return fmt.Errorf("failed to open backup file: %w", err)
+		if stored[manifestName(object.Name)] {
+			if manifest, err := readManifest(bm.storage, object.Name); err == nil {
+				backups = append(backups, *manifest.info(object.Name, bm.storage.Location(object.Name)))
+				continue
+			}
 		}
-		defer file.Close()
 
-		gzReader, err := gzip.NewReader(file)
-		if err != nil {
-			return fmt.Errorf("failed to create gzip reader: %w", err)
+		// Backups taken before manifests existed are described from the
+		// storage listing alone.
+		filename := object.Name
+		encrypted := strings.HasSuffix(filename, encryptedSuffix)
+		compression := compressionOf(filename)
+		
+		dbName := "unknown"
+		if matches := legacyBackupName.FindStringSubmatch(filename); matches != nil {
+			dbName = matches[1]
 		}
-		defer gzReader.Close()
 
-		reader = gzReader
-	} else {
-		file, err := os.Open(backupPath)
-		if err != nil {
-			return fmt.Errorf("failed to open backup file: %w", err)
+		backup := BackupInfo{
+			Filename:     filename,
+			Path:         bm.storage.Location(filename),
+			Size:         object.Size,
+			CreatedAt:    object.ModTime,
+			Compressed:   compression != "",
+			Compression:  compression,
+			Encrypted:    encrypted,
+			DatabaseName: dbName,
 		}
-		defer file.Close()
 		
-		reader = file
+		backups = append(backups, backup)
 	}
 
-	cmd.Stdin = reader
-	return cmd.Run()
+	return backups, nil
 }
 
-func (bm *BackupManager) decompressFile(src, dst string) error {
-	srcFile, err := os.Open(src)
-	if err != nil {
-		return err
+// resolve maps what a user passed on the command line to a storage and an
+// object name. An existing local file is used as is; anything else is looked
+// up by file name in the configured storage, where a unique prefix such as
+// "mydb_20231201" is enough.
+func (bm *BackupManager) resolve(ref string) (Storage, string, error) {
+	if info, err := os.Stat(ref); err == nil && info.Mode().IsRegular() {
+		return newLocalStorage(filepath.Dir(ref)), filepath.Base(ref), nil
 	}
-	defer srcFile.Close()
 
-	gzReader, err := gzip.NewReader(srcFile)
+	name := path.Base(ref)
+	objects, err := bm.storage.List()
 	if err != nil {
-		return err
+		return nil, "", err
 	}
-	defer gzReader.Close()
 
-	dstFile, err := os.Create(dst)
-	if err != nil {
-		return err
+	var matches []string
+	for _, object := range objects {
+		if isManifest(object.Name) {
+			continue
+		}
+		if object.Name == name {
+			return bm.storage, name, nil
+		}
+		if strings.HasPrefix(object.Name, name) {
+			matches = append(matches, object.Name)
+		}
 	}
-	defer dstFile.Close()
 
-	_, err = io.Copy(dstFile, gzReader)
-	return err
+	switch len(matches) {
+	case 0:
+		return bm.storage, name, nil
+	case 1:
+		return bm.storage, matches[0], nil
+	default:
+		return nil, "", fmt.Errorf("backup name %q is ambiguous, matches: %s", name, strings.Join(matches, ", "))
+	}
 }
 
-func (bm *BackupManager) CleanOld() error {
-	backups, err := bm.List()
+// openBackup opens a stored backup for reading, transparently decrypting
+// .age files and decompressing gzip and zstd files.
+func openBackup(storage Storage, name string, encryption config.EncryptionConfig) (io.ReadCloser, error) {
+	object, err := storage.Get(name)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	cutoffTime := time.Now().AddDate(0, 0, -bm.config.Backup.RetentionDays)
-	var deletedCount int
+	var reader io.Reader = object
 
-	for _, backup := range backups {
-		if backup.CreatedAt.Before(cutoffTime) {
-			if err := os.Remove(backup.Path); err != nil {
-				fmt.Printf("Warning: failed to delete old backup %s: %v\n", backup.Filename, err)
-			} else {
-				deletedCount++
-				fmt.Printf("Deleted old backup: %s\n", backup.Filename)
-			}
+	if strings.HasSuffix(name, encryptedSuffix) {
+		reader, err = decryptReader(reader, encryption)
+		if err != nil {
+			object.Close()
+			return nil, err
 		}
 	}
 
-	if deletedCount > 0 {
-		fmt.Printf("Cleaned up %d old backups\n", deletedCount)
-	} else {
-		fmt.Println("No old backups to clean up")
+	algorithm := compressionOf(name)
+	if algorithm == "" {
+		return &backupReader{Reader: reader, object: object}, nil
 	}
 
-	return nil
-}
\ No newline at end of file
+	decompressed, err := compressors[algorithm].newReader(reader)
+	if err != nil {
+		object.Close()
+		return nil, fmt.Errorf("failed to create %s reader: %w", algorithm, err)
+	}
+
+	return &backupReader{Reader: decompressed, decompressor: decompressed, object: object}, nil
+}
+
+type backupReader struct {
+	io.Reader
+	decompressor io.Closer
+	object       io.Closer
+}
+
+func (b *backupReader) Close() error {
+	if b.decompressor != nil {
+		b.decompressor.Close()
+	}
+	return b.object.Close()
+}
@@ -0,0 +1,97 @@
+package backup
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"migr8/pkg/config"
+)
+
+// compressor is a streaming compression format backups can be written in.
+// The file name suffix is how a backup's format is recognised when it is
+// read back.
+type compressor struct {
+	suffix    string
+	minLevel  int
+	maxLevel  int
+	newWriter func(w io.Writer, level int) (io.WriteCloser, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var compressors = map[string]compressor{
+	"gzip": {
+		suffix:   ".gz",
+		minLevel: gzip.BestSpeed,
+		maxLevel: gzip.BestCompression,
+		newWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = gzip.DefaultCompression
+			}
+			return gzip.NewWriterLevel(w, level)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	"zstd": {
+		suffix:   ".zst",
+		minLevel: 1,
+		maxLevel: 22,
+		newWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			encoderLevel := zstd.SpeedDefault
+			if level != 0 {
+				encoderLevel = zstd.EncoderLevelFromZstd(level)
+			}
+			return zstd.NewWriter(w, zstd.WithEncoderLevel(encoderLevel))
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			decoder, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return decoder.IOReadCloser(), nil
+		},
+	},
+}
+
+// compressionAlgorithm returns the configured algorithm, or "" when
+// backups are not compressed. Level 0 selects the algorithm's default.
+func compressionAlgorithm(cfg config.BackupConfig) (string, error) {
+	if !cfg.Compression {
+		return "", nil
+	}
+
+	algorithm := cfg.CompressionAlgorithm
+	switch algorithm {
+	case "":
+		algorithm = "gzip"
+	case "none":
+		return "", nil
+	}
+
+	c, ok := compressors[algorithm]
+	if !ok {
+		return "", fmt.Errorf("unknown compression algorithm: %s", algorithm)
+	}
+	if cfg.CompressionLevel != 0 && (cfg.CompressionLevel < c.minLevel || cfg.CompressionLevel > c.maxLevel) {
+		return "", fmt.Errorf("%s compression level must be between %d and %d, got %d",
+			algorithm, c.minLevel, c.maxLevel, cfg.CompressionLevel)
+	}
+	return algorithm, nil
+}
+
+// compressionOf recognises the algorithm a backup was written with from its
+// name, ignoring any encryption suffix.
+func compressionOf(name string) string {
+	name = strings.TrimSuffix(name, encryptedSuffix)
+	for algorithm, c := range compressors {
+		if strings.HasSuffix(name, c.suffix) {
+			return algorithm
+		}
+	}
+	return ""
+}
@@ -0,0 +1,101 @@
+package backup
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompressionAlgorithms(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		level     int
+		suffix    string
+	}{
+		{"gzip", 9, ".sql.gz"},
+		{"zstd", 0, ".sql.zst"},
+		{"zstd", 19, ".sql.zst"},
+		{"none", 0, ".sql"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			bm := newTestManager(t, "sqlite3")
+			bm.config.Backup.CompressionAlgorithm = tt.algorithm
+			bm.config.Backup.CompressionLevel = tt.level
+
+			for _, stmt := range []string{
+				`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`,
+				`INSERT INTO items (id, name) VALUES (1, 'a'), (2, 'b')`,
+			} {
+				if _, err := bm.db.Exec(stmt); err != nil {
+					t.Fatalf("Failed to prepare schema: %v", err)
+				}
+			}
+
+			var reports []BackupProgress
+			info, err := bm.Create(BackupOptions{Progress: func(p BackupProgress) {
+				reports = append(reports, p)
+			}})
+			if err != nil {
+				t.Fatalf("Failed to create backup: %v", err)
+			}
+			if !strings.HasSuffix(info.Filename, tt.suffix) {
+				t.Errorf("Expected %s backup, got %s", tt.suffix, info.Filename)
+			}
+			if info.Compressed != (tt.algorithm != "none") {
+				t.Errorf("Unexpected compression flag for %s: %+v", tt.algorithm, info)
+			}
+
+			if len(reports) == 0 || reports[len(reports)-1].Bytes != info.DumpSize || info.DumpSize == 0 {
+				t.Errorf("Expected a final progress report of %d bytes, got %+v", info.DumpSize, reports)
+			}
+
+			backups, err := bm.List()
+			if err != nil || len(backups) != 1 {
+				t.Fatalf("Failed to list backups: %v", err)
+			}
+			if backups[0].DumpSize != info.DumpSize || backups[0].Compression != info.Compression {
+				t.Errorf("Expected listed backup to match created one, got %+v", backups[0])
+			}
+
+			if _, checked, err := Verify(info.Path, bm.config.Backup.Encryption); err != nil || !checked {
+				t.Errorf("Expected backup to verify, checked=%t err=%v", checked, err)
+			}
+
+			if _, err := bm.db.Exec(`DELETE FROM items`); err != nil {
+				t.Fatalf("Failed to modify data: %v", err)
+			}
+			if _, err := bm.Restore(info.Path, RestoreOptions{}); err != nil {
+				t.Fatalf("Failed to restore: %v", err)
+			}
+			if n := countRows(t, bm, "items"); n != 2 {
+				t.Errorf("Expected 2 restored items, got %d", n)
+			}
+		})
+	}
+}
+
+func TestCompressionConfigErrors(t *testing.T) {
+	bm := newTestManager(t, "sqlite3")
+
+	bm.config.Backup.CompressionAlgorithm = "brotli"
+	if _, err := bm.Create(BackupOptions{}); err == nil {
+		t.Error("Expected error for unknown compression algorithm")
+	}
+
+	bm.config.Backup.CompressionAlgorithm = "gzip"
+	bm.config.Backup.CompressionLevel = 12
+	if _, err := bm.Create(BackupOptions{}); err == nil {
+		t.Error("Expected error for out of range gzip level")
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{limit: 8}
+	b.Write([]byte("first line\n"))
+	b.Write([]byte("error!"))
+
+	if got := b.String(); got != "...e\nerror!" {
+		t.Errorf("Expected only the tail to be kept, got %q", got)
+	}
+}
@@ -0,0 +1,55 @@
+package backup
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	from := time.Date(2024, 3, 15, 10, 17, 30, 0, time.UTC) // a Friday
+
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2024, 3, 15, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2024, 3, 16, 2, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC)},
+		{"30 1 * * mon-wed", time.Date(2024, 3, 18, 1, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 3, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 3 1 */3 *", time.Date(2024, 4, 1, 3, 0, 0, 0, time.UTC)},
+		{"0 12 29 feb *", time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC).AddDate(4, 0, 0)},
+		// Both day fields restricted: either one matching is enough.
+		{"0 0 20 * fri", time.Date(2024, 3, 20, 0, 0, 0, 0, time.UTC)},
+		{"5,10-12 10 * * *", time.Date(2024, 3, 16, 10, 5, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := ParseSchedule(tt.expr)
+			if err != nil {
+				t.Fatalf("Failed to parse: %v", err)
+			}
+			if got := s.Next(from); !got.Equal(tt.expected) {
+				t.Errorf("Expected next run %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	impossible, err := ParseSchedule("0 0 30 2 *")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if next := impossible.Next(from); !next.IsZero() {
+		t.Errorf("Expected February 30th never to match, got %s", next)
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "*/0 * * * *", "5-1 * * * *", "* * * * funday"} {
+		if _, err := ParseSchedule(expr); err == nil {
+			t.Errorf("Expected %q to be rejected", expr)
+		}
+	}
+}
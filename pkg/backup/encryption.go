@@ -0,0 +1,121 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"filippo.io/age"
+
+	"migr8/pkg/config"
+)
+
+const encryptedSuffix = ".age"
+
+// encryptionRecipients resolves who a backup is encrypted to and a short
+// description of the scheme for the manifest.
+func encryptionRecipients(cfg config.EncryptionConfig) ([]age.Recipient, string, error) {
+	var recipients []age.Recipient
+
+	for _, r := range cfg.Recipients {
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid backup recipient %q: %w", r, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	if cfg.IdentityFile != "" {
+		identities, err := readIdentityFile(cfg.IdentityFile)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, identity := range identities {
+			if x, ok := identity.(*age.X25519Identity); ok {
+				recipients = append(recipients, x.Recipient())
+			}
+		}
+	}
+
+	if len(recipients) > 0 {
+		return recipients, "age-x25519", nil
+	}
+
+	passphrase := os.Getenv(cfg.PassphraseEnv)
+	if passphrase == "" {
+		return nil, "", fmt.Errorf("backup encryption is enabled but no recipients, identity file or $%s passphrase is configured", cfg.PassphraseEnv)
+	}
+
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid backup passphrase: %w", err)
+	}
+	return []age.Recipient{recipient}, "age-scrypt", nil
+}
+
+// decryptionIdentities returns every identity that could open a backup:
+// the configured identity file and the passphrase, when set.
+func decryptionIdentities(cfg config.EncryptionConfig) ([]age.Identity, error) {
+	var identities []age.Identity
+
+	if cfg.IdentityFile != "" {
+		fromFile, err := readIdentityFile(cfg.IdentityFile)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, fromFile...)
+	}
+
+	if passphrase := os.Getenv(cfg.PassphraseEnv); passphrase != "" {
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup passphrase: %w", err)
+		}
+		identities = append(identities, identity)
+	}
+
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("backup is encrypted but no identity file or $%s passphrase is configured", cfg.PassphraseEnv)
+	}
+	return identities, nil
+}
+
+func readIdentityFile(path string) ([]age.Identity, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open identity file: %w", err)
+	}
+	defer file.Close()
+
+	identities, err := age.ParseIdentities(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse identity file %s: %w", path, err)
+	}
+	return identities, nil
+}
+
+func encryptWriter(w io.Writer, cfg config.EncryptionConfig) (io.WriteCloser, string, error) {
+	recipients, scheme, err := encryptionRecipients(cfg)
+	if err != nil {
+		return nil, "", err
+	}
+
+	encrypted, err := age.Encrypt(w, recipients...)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to start encryption: %w", err)
+	}
+	return encrypted, scheme, nil
+}
+
+func decryptReader(r io.Reader, cfg config.EncryptionConfig) (io.Reader, error) {
+	identities, err := decryptionIdentities(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt backup: %w", err)
+	}
+	return decrypted, nil
+}
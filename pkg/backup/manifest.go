@@ -0,0 +1,182 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"migr8/pkg/config"
+	"migr8/pkg/database"
+)
+
+// ToolVersion is recorded in every manifest. The CLI sets it to the build
+// version at startup.
+var ToolVersion = "unknown"
+
+const manifestSuffix = ".json"
+
+// Manifest is written next to every backup as <backup>.json so listing and
+// verification do not depend on parsing file names or trusting mtimes.
+type Manifest struct {
+	Filename         string    `json:"filename"`
+	SHA256           string    `json:"sha256"`
+	Size             int64     `json:"size"`
+	DumpSize         int64     `json:"dump_size,omitempty"`
+	Compressed       bool      `json:"compressed"`
+	Compression      string    `json:"compression,omitempty"`
+	Encrypted        bool      `json:"encrypted"`
+	Encryption       string    `json:"encryption,omitempty"`
+	Engine           string    `json:"engine"`
+	Driver           string    `json:"driver"`
+	Host             string    `json:"host"`
+	Port             int       `json:"port,omitempty"`
+	Database         string    `json:"database"`
+	CreatedAt        time.Time `json:"created_at"`
+	DurationMS       int64     `json:"duration_ms,omitempty"`
+	Migr8Version     string    `json:"migr8_version"`
+	MigrationVersion string    `json:"migration_version,omitempty"`
+	// Scope is omitted for full backups.
+	Scope *database.DumpScope `json:"scope,omitempty"`
+	Tags  map[string]string   `json:"tags,omitempty"`
+	// Anonymized backups had the anonymization rules applied to their rows.
+	Anonymized bool `json:"anonymized,omitempty"`
+}
+
+// compression returns the algorithm the backup was compressed with.
+// Manifests written before the algorithm was recorded are always gzip.
+func (m *Manifest) compression() string {
+	if m.Compression == "" && m.Compressed {
+		return "gzip"
+	}
+	return m.Compression
+}
+
+func manifestName(name string) string {
+	return name + manifestSuffix
+}
+
+func isManifest(name string) bool {
+	return strings.HasSuffix(name, manifestSuffix)
+}
+
+func writeManifest(storage Storage, name string, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	if err := storage.Put(manifestName(name), bytes.NewReader(append(data, '\n'))); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// ReadManifest loads the sidecar manifest of a local backup file. It
+// returns os.ErrNotExist (wrapped) for backups taken before manifests
+// existed.
+func ReadManifest(backupPath string) (*Manifest, error) {
+	return readManifest(newLocalStorage(filepath.Dir(backupPath)), filepath.Base(backupPath))
+}
+
+func readManifest(storage Storage, name string) (*Manifest, error) {
+	object, err := storage.Get(manifestName(name))
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	var manifest Manifest
+	if err := json.NewDecoder(object).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest for %s: %w", name, err)
+	}
+	return &manifest, nil
+}
+
+// hashingWriter counts and hashes everything written through it.
+type hashingWriter struct {
+	w    io.Writer
+	h    hash.Hash
+	size int64
+}
+
+func newHashingWriter(w io.Writer) *hashingWriter {
+	return &hashingWriter{w: w, h: sha256.New()}
+}
+
+func (hw *hashingWriter) Write(p []byte) (int, error) {
+	n, err := hw.w.Write(p)
+	hw.h.Write(p[:n])
+	hw.size += int64(n)
+	return n, err
+}
+
+func (hw *hashingWriter) Sum() string {
+	return hex.EncodeToString(hw.h.Sum(nil))
+}
+
+// Verify checks a local backup file against its manifest; see
+// VerifyStored.
+func Verify(backupPath string, encryption config.EncryptionConfig) (*Manifest, bool, error) {
+	return VerifyStored(newLocalStorage(filepath.Dir(backupPath)), filepath.Base(backupPath), encryption)
+}
+
+// VerifyStored re-hashes a stored backup and compares it with its
+// manifest. When the backup can be opened it is also read to the end, which
+// checks the gzip CRC and age's per-chunk authentication. Encrypted backups
+// for which no key is configured are only checked against the manifest
+// hash, which is reported through contentChecked.
+func VerifyStored(storage Storage, name string, encryption config.EncryptionConfig) (manifest *Manifest, contentChecked bool, err error) {
+	manifest, err = readManifest(storage, name)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	object, err := storage.Get(name)
+	if err != nil {
+		return manifest, false, fmt.Errorf("failed to open backup file: %w", err)
+	}
+	defer object.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, object)
+	if err != nil {
+		return manifest, false, fmt.Errorf("failed to read backup file: %w", err)
+	}
+
+	if size != manifest.Size {
+		return manifest, false, fmt.Errorf("size mismatch: manifest has %d bytes, file has %d", manifest.Size, size)
+	}
+
+	if sum := hex.EncodeToString(hasher.Sum(nil)); sum != manifest.SHA256 {
+		return manifest, false, fmt.Errorf("checksum mismatch: manifest has %s, file has %s", manifest.SHA256, sum)
+	}
+
+	if !manifest.Compressed && !manifest.Encrypted {
+		return manifest, true, nil
+	}
+
+	if manifest.Encrypted {
+		if _, err := decryptionIdentities(encryption); err != nil {
+			return manifest, false, nil
+		}
+	}
+
+	reader, err := openBackup(storage, name, encryption)
+	if err != nil {
+		return manifest, false, err
+	}
+	defer reader.Close()
+
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return manifest, false, fmt.Errorf("corrupt backup contents: %w", err)
+	}
+
+	return manifest, true, nil
+}
@@ -0,0 +1,122 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	bm := newTestManager(t, "sqlite3")
+	bm.config.Database.Database = filepath.Join(filepath.Dir(bm.config.Database.Database), "my_app_db")
+	bm.config.Migration.Table = "schema_migrations"
+
+	if err := bm.db.CreateMigrationsTable("schema_migrations"); err != nil {
+		t.Fatalf("Failed to create migrations table: %v", err)
+	}
+	if err := bm.db.RecordMigration("schema_migrations", "20230101120000_init", "abc"); err != nil {
+		t.Fatalf("Failed to record migration: %v", err)
+	}
+
+	info, err := bm.Create(BackupOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create backup: %v", err)
+	}
+
+	manifest, err := ReadManifest(info.Path)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+
+	if manifest.MigrationVersion != "20230101120000_init" {
+		t.Errorf("Expected migration version 20230101120000_init, got %s", manifest.MigrationVersion)
+	}
+	if manifest.Driver != "sqlite3" || manifest.Engine != "native" || !manifest.Compressed {
+		t.Errorf("Unexpected manifest metadata: %+v", manifest)
+	}
+	if len(manifest.SHA256) != 64 {
+		t.Errorf("Expected SHA-256 hex digest, got %s", manifest.SHA256)
+	}
+
+	backups, err := bm.List()
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("Expected 1 backup (manifest excluded), got %d", len(backups))
+	}
+	if backups[0].DatabaseName != bm.config.Database.Database {
+		t.Errorf("Expected database name from manifest, got %s", backups[0].DatabaseName)
+	}
+
+	if _, _, err := Verify(info.Path, bm.config.Backup.Encryption); err != nil {
+		t.Fatalf("Expected backup to verify: %v", err)
+	}
+
+	data, err := os.ReadFile(info.Path)
+	if err != nil {
+		t.Fatalf("Failed to read backup: %v", err)
+	}
+	data[len(data)-5] ^= 0xff
+	if err := os.WriteFile(info.Path, data, 0644); err != nil {
+		t.Fatalf("Failed to corrupt backup: %v", err)
+	}
+
+	if _, _, err := Verify(info.Path, bm.config.Backup.Encryption); err == nil {
+		t.Error("Expected verification of a corrupted backup to fail")
+	}
+}
+
+func TestListLegacyBackupName(t *testing.T) {
+	bm := newTestManager(t, "sqlite3")
+
+	if err := os.MkdirAll(bm.config.Backup.Directory, 0755); err != nil {
+		t.Fatalf("Failed to create backup directory: %v", err)
+	}
+	legacy := filepath.Join(bm.config.Backup.Directory, "my_app_db_20230101_120000.sql.gz")
+	if err := os.WriteFile(legacy, []byte("x"), 0644); err != nil {
+		t.Fatalf("Failed to write legacy backup: %v", err)
+	}
+
+	backups, err := bm.List()
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(backups) != 1 || backups[0].DatabaseName != "my_app_db" {
+		t.Errorf("Expected legacy backup for my_app_db, got %+v", backups)
+	}
+}
+
+func TestLabelsTagsAndUniqueNames(t *testing.T) {
+	bm := newTestManager(t, "sqlite3")
+
+	opts := BackupOptions{Label: "pre-migrate", Tags: map[string]string{"reason": "pre-migrate"}}
+	first, err := bm.Create(opts)
+	if err != nil {
+		t.Fatalf("Failed to create backup: %v", err)
+	}
+	second, err := bm.Create(opts)
+	if err != nil {
+		t.Fatalf("Failed to create backup: %v", err)
+	}
+	if first.Filename == second.Filename {
+		t.Fatalf("Expected backups taken in quick succession to get distinct names, got %s twice", first.Filename)
+	}
+
+	backups, err := bm.List()
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups, got %d", len(backups))
+	}
+	for _, b := range backups {
+		if b.Tags["reason"] != "pre-migrate" {
+			t.Errorf("Expected tags to be read back from the manifest of %s, got %v", b.Filename, b.Tags)
+		}
+	}
+
+	if _, err := bm.Create(BackupOptions{Label: "Not Valid"}); err == nil {
+		t.Error("Expected an invalid label to be rejected")
+	}
+}
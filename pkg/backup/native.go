@@ -0,0 +1,299 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"migr8/pkg/anonymize"
+	"migr8/pkg/database"
+)
+
+// nativeDumper writes a logical SQL dump using only database/sql, for hosts
+// that do not have pg_dump, mysqldump or sqlite3 installed.
+type nativeDumper struct {
+	db           *database.DB
+	introspector database.Introspector
+	batchSize    int
+	// anonymizer, when set, rewrites rows before they are written.
+	anonymizer *anonymize.Anonymizer
+}
+
+func newNativeDumper(db *database.DB, batchSize int) (*nativeDumper, error) {
+	introspector, ok := db.Dialect.(database.Introspector)
+	if !ok {
+		return nil, fmt.Errorf("native backup not supported for driver: %s", db.Driver)
+	}
+
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+
+	return &nativeDumper{
+		db:           db,
+		introspector: introspector,
+		batchSize:    batchSize,
+	}, nil
+}
+
+// Dump writes drop, create, data and post-data statements for the tables
+// in scope, in that order. Tables are created and loaded parents first,
+// dropped children first, so the script replays cleanly over an existing
+// schema. A data-only dump has only the data section, so it loads into
+// tables that already exist. Everything is read in one snapshot
+// transaction, so rows of related tables agree even while the database is
+// being written to.
+func (nd *nativeDumper) Dump(w io.Writer, databaseName string, scope database.DumpScope) error {
+	tx, err := nd.db.BeginSnapshot()
+	if err != nil {
+		return fmt.Errorf("failed to begin snapshot transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if nd.anonymizer != nil {
+		if err := nd.checkAnonymizedTables(tx); err != nil {
+			return err
+		}
+	}
+
+	ordered := scope.Tables
+	if len(ordered) == 0 {
+		tables, err := nd.introspector.Tables(tx)
+		if err != nil {
+			return fmt.Errorf("failed to list tables: %w", err)
+		}
+
+		foreignKeys, err := nd.introspector.ForeignKeys(tx)
+		if err != nil {
+			return fmt.Errorf("failed to list foreign keys: %w", err)
+		}
+
+		var cyclic []string
+		ordered, cyclic = database.SortTablesByDependency(tables, foreignKeys)
+		ordered = append(ordered, cyclic...)
+	}
+
+	definitions := make(map[string]*database.TableDefinition, len(ordered))
+	if !scope.DataOnly {
+		for _, table := range ordered {
+			def, err := nd.introspector.TableDefinition(tx, table)
+			if err != nil {
+				return fmt.Errorf("failed to describe table %s: %w", table, err)
+			}
+			definitions[table] = def
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "-- Migr8 native dump\n")
+	fmt.Fprintf(bw, "-- Driver: %s\n", nd.db.Driver)
+	fmt.Fprintf(bw, "-- Database: %s\n", databaseName)
+	if !scope.IsFull() {
+		fmt.Fprintf(bw, "-- Scope: %s\n", DescribeScope(&scope))
+	}
+	fmt.Fprintf(bw, "-- Created: %s\n\n", time.Now().Format(time.RFC3339))
+
+	for _, stmt := range nd.introspector.DumpPreamble() {
+		nd.write(bw, stmt)
+	}
+
+	if !scope.DataOnly {
+		for i := len(ordered) - 1; i >= 0; i-- {
+			nd.write(bw, definitions[ordered[i]].Drop)
+		}
+
+		for _, table := range ordered {
+			fmt.Fprintf(bw, "\n-- Table: %s\n", table)
+			for _, stmt := range definitions[table].Create {
+				nd.write(bw, stmt)
+			}
+		}
+	}
+
+	if !scope.SchemaOnly {
+		for _, table := range ordered {
+			fmt.Fprintf(bw, "\n-- Data: %s\n", table)
+			if err := nd.dumpRows(tx, bw, table, scope.Where[table]); err != nil {
+				return fmt.Errorf("failed to dump table %s: %w", table, err)
+			}
+		}
+	}
+
+	if !scope.DataOnly {
+		fmt.Fprintf(bw, "\n")
+		for _, table := range ordered {
+			for _, stmt := range definitions[table].After {
+				nd.write(bw, stmt)
+			}
+		}
+	}
+
+	for _, stmt := range nd.introspector.DumpPostamble() {
+		nd.write(bw, stmt)
+	}
+
+	return bw.Flush()
+}
+
+// checkAnonymizedTables fails when an anonymization rule names a table the
+// database does not have, which is most likely a misspelt table whose data
+// would be dumped as it is.
+func (nd *nativeDumper) checkAnonymizedTables(q database.Queryer) error {
+	tables, err := nd.introspector.Tables(q)
+	if err != nil {
+		return fmt.Errorf("failed to list tables: %w", err)
+	}
+	known := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		known[strings.ToLower(table)] = true
+	}
+	for _, table := range nd.anonymizer.Tables() {
+		if !known[table] {
+			return fmt.Errorf("anonymization rules name table %s, which does not exist", table)
+		}
+	}
+	return nil
+}
+
+func (nd *nativeDumper) write(w io.Writer, stmt string) {
+	io.WriteString(w, database.FormatStatement(nd.db.Dialect, stmt))
+}
+
+// dumpRows writes the rows of table matching where, or all of them when
+// where is empty.
+func (nd *nativeDumper) dumpRows(q database.Queryer, w io.Writer, table, where string) error {
+	rows, err := q.Query(database.SelectRowsSQL(nd.db.Dialect, table, where, 0))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+
+	anonymized, err := nd.anonymizer.Table(table, columns)
+	if err != nil {
+		return err
+	}
+
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = nd.db.Dialect.QuoteIdentifier(column)
+	}
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES\n",
+		nd.db.Dialect.QuoteIdentifier(table), strings.Join(quoted, ", "))
+
+	inserter, hasIdentity := nd.db.Dialect.(database.IdentityInserter)
+	if hasIdentity {
+		nd.write(w, inserter.IdentityInsertSQL(table, columns, true))
+	}
+
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	// The dump is replayed as literals, but SQL Server still caps the rows
+	// of a VALUES list.
+	batchSize := database.BatchRows(nd.db.Dialect, len(columns), nd.batchSize)
+
+	var batch []string
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		nd.write(w, insert+strings.Join(batch, ",\n"))
+		batch = batch[:0]
+	}
+
+	literals := make([]string, len(columns))
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+		if err := anonymized.Row(values); err != nil {
+			return err
+		}
+
+		for i, value := range values {
+			literals[i] = nd.introspector.Literal(value, columnTypes[i].DatabaseTypeName())
+		}
+		batch = append(batch, "("+strings.Join(literals, ", ")+")")
+
+		if len(batch) >= batchSize {
+			flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	flush()
+
+	if hasIdentity {
+		nd.write(w, inserter.IdentityInsertSQL(table, columns, false))
+	}
+
+	return nil
+}
+
+// restoreNative replays a native dump statement by statement inside a
+// single transaction, stopping at the first failure. The transaction makes
+// the restore all or nothing except on MySQL, which commits every CREATE
+// and DROP TABLE implicitly, so a failed restore leaves the tables replayed
+// up to that point behind.
+func restoreNative(db *database.DB, r io.Reader) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	// SQLite ignores the dump's PRAGMA foreign_keys inside a transaction,
+	// so the checks are turned off on the connection beforehand.
+	if db.Driver == "sqlite3" || db.Driver == "sqlite" {
+		var enabled bool
+		if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&enabled); err != nil {
+			return fmt.Errorf("failed to read foreign key setting: %w", err)
+		}
+		if enabled {
+			if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+				return fmt.Errorf("failed to disable foreign keys: %w", err)
+			}
+			defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+		}
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	scanner := db.Dialect.Statements(r)
+	count := 0
+	for scanner.Scan() {
+		stmt := scanner.Statement()
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to execute statement %d '%s': %w", count+1, database.AbbreviateStatement(stmt), err)
+		}
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,279 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"migr8/pkg/config"
+)
+
+// newTestManager is given "sqlite3" by most tests, which is mattn/go-sqlite3
+// in cgo builds and modernc.org/sqlite otherwise, so CI covers both.
+func newTestManager(t *testing.T, driver string) *BackupManager {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	var cfg config.Config
+	cfg.Database.Driver = driver
+	cfg.Database.Database = filepath.Join(dir, "test.db")
+	cfg.Backup.Directory = filepath.Join(dir, "backups")
+	cfg.Backup.Compression = true
+	cfg.Backup.Engine = "native"
+	cfg.Backup.BatchSize = 2
+
+	bm, err := NewBackupManager(&cfg)
+	if err != nil {
+		t.Fatalf("Failed to create backup manager: %v", err)
+	}
+	t.Cleanup(func() { bm.Close() })
+
+	return bm
+}
+
+func TestNativeBackupRoundTrip(t *testing.T) {
+	for _, driver := range []string{"sqlite3", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			bm := newTestManager(t, driver)
+
+			schema := []string{
+				`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL, avatar BLOB)`,
+				`CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users(id), body TEXT)`,
+				`CREATE INDEX idx_posts_user ON posts(user_id)`,
+				`INSERT INTO users (id, name, avatar) VALUES (1, 'O''Brien; Jr.', X'00ff'), (2, 'Ann', NULL), (3, 'Bo', NULL)`,
+				`INSERT INTO posts (id, user_id, body) VALUES (1, 1, '-- not a comment'), (2, 2, NULL)`,
+			}
+			for _, stmt := range schema {
+				if _, err := bm.db.Exec(stmt); err != nil {
+					t.Fatalf("Failed to prepare schema: %v", err)
+				}
+			}
+
+			info, err := bm.Create(BackupOptions{})
+			if err != nil {
+				t.Fatalf("Failed to create backup: %v", err)
+			}
+			if !info.Compressed || info.Size == 0 {
+				t.Errorf("Expected non-empty compressed backup, got %+v", info)
+			}
+
+			if _, err := bm.db.Exec(`DELETE FROM posts`); err != nil {
+				t.Fatalf("Failed to modify data: %v", err)
+			}
+			if _, err := bm.db.Exec(`UPDATE users SET name = 'changed'`); err != nil {
+				t.Fatalf("Failed to modify data: %v", err)
+			}
+
+			if _, err := bm.Restore(info.Path, RestoreOptions{}); err != nil {
+				t.Fatalf("Failed to restore backup: %v", err)
+			}
+
+			var name string
+			var avatar []byte
+			if err := bm.db.QueryRow(`SELECT name, avatar FROM users WHERE id = 1`).Scan(&name, &avatar); err != nil {
+				t.Fatalf("Failed to query restored user: %v", err)
+			}
+			if name != "O'Brien; Jr." {
+				t.Errorf("Expected restored name O'Brien; Jr., got %s", name)
+			}
+			if len(avatar) != 2 || avatar[0] != 0x00 || avatar[1] != 0xff {
+				t.Errorf("Expected restored blob 00ff, got %x", avatar)
+			}
+
+			var posts int
+			if err := bm.db.QueryRow(`SELECT COUNT(*) FROM posts`).Scan(&posts); err != nil {
+				t.Fatalf("Failed to count restored posts: %v", err)
+			}
+			if posts != 2 {
+				t.Errorf("Expected 2 restored posts, got %d", posts)
+			}
+
+			var indexes int
+			if err := bm.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_posts_user'`).Scan(&indexes); err != nil {
+				t.Fatalf("Failed to query indexes: %v", err)
+			}
+			if indexes != 1 {
+				t.Error("Expected index to be restored")
+			}
+		})
+	}
+}
+
+func TestNativeRestoreWithForeignKeys(t *testing.T) {
+	bm := newTestManager(t, "sqlite3")
+	// Every pooled connection needs foreign keys enforced.
+	bm.Close()
+	bm.config.Database.Database += "?_pragma=foreign_keys(1)&_foreign_keys=1"
+	bm, err := NewBackupManager(bm.config)
+	if err != nil {
+		t.Fatalf("Failed to reconnect: %v", err)
+	}
+	defer bm.Close()
+
+	// With two rows per INSERT, node 1 is restored before the parent it
+	// points at.
+	for _, stmt := range []string{
+		`CREATE TABLE nodes (id INTEGER PRIMARY KEY, parent_id INTEGER REFERENCES nodes(id))`,
+		`INSERT INTO nodes (id, parent_id) VALUES (3, NULL), (2, NULL), (1, 3)`,
+	} {
+		if _, err := bm.db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to prepare schema: %v", err)
+		}
+	}
+
+	info, err := bm.Create(BackupOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create backup: %v", err)
+	}
+	if _, err := bm.Restore(info.Path, RestoreOptions{}); err != nil {
+		t.Fatalf("Failed to restore backup: %v", err)
+	}
+
+	var parent int
+	if err := bm.db.QueryRow(`SELECT parent_id FROM nodes WHERE id = 1`).Scan(&parent); err != nil || parent != 3 {
+		t.Errorf("Expected node 1 to point at node 3, got %d (%v)", parent, err)
+	}
+}
+
+func TestUnknownBackupEngine(t *testing.T) {
+	bm := newTestManager(t, "sqlite3")
+	bm.config.Backup.Engine = "tape"
+
+	if _, err := bm.Create(BackupOptions{}); err == nil {
+		t.Error("Expected error for unknown backup engine")
+	}
+}
+
+func TestEncryptedBackupRoundTrip(t *testing.T) {
+	bm := newTestManager(t, "sqlite3")
+	bm.config.Backup.Encryption = config.EncryptionConfig{
+		Enabled:       true,
+		PassphraseEnv: "MIGR8_TEST_BACKUP_PASSPHRASE",
+	}
+	t.Setenv("MIGR8_TEST_BACKUP_PASSPHRASE", "correct horse battery staple")
+
+	if _, err := bm.db.Exec(`CREATE TABLE secrets (id INTEGER PRIMARY KEY, value TEXT)`); err != nil {
+		t.Fatalf("Failed to prepare schema: %v", err)
+	}
+	if _, err := bm.db.Exec(`INSERT INTO secrets (id, value) VALUES (1, 'hunter2')`); err != nil {
+		t.Fatalf("Failed to insert data: %v", err)
+	}
+
+	info, err := bm.Create(BackupOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create backup: %v", err)
+	}
+	if !info.Encrypted || !strings.HasSuffix(info.Filename, ".sql.gz.age") {
+		t.Errorf("Expected encrypted .sql.gz.age backup, got %+v", info)
+	}
+
+	manifest, err := ReadManifest(info.Path)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if !manifest.Encrypted || manifest.Encryption != "age-scrypt" {
+		t.Errorf("Expected age-scrypt encryption in manifest, got %+v", manifest)
+	}
+
+	backups, err := bm.List()
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(backups) != 1 || !backups[0].Encrypted {
+		t.Errorf("Expected one encrypted backup in list, got %+v", backups)
+	}
+
+	if _, checked, err := Verify(info.Path, bm.config.Backup.Encryption); err != nil || !checked {
+		t.Errorf("Expected encrypted backup to verify fully, checked=%t err=%v", checked, err)
+	}
+
+	if _, err := bm.db.Exec(`UPDATE secrets SET value = 'changed'`); err != nil {
+		t.Fatalf("Failed to modify data: %v", err)
+	}
+	if _, err := bm.Restore(info.Path, RestoreOptions{}); err != nil {
+		t.Fatalf("Failed to restore encrypted backup: %v", err)
+	}
+
+	var value string
+	if err := bm.db.QueryRow(`SELECT value FROM secrets WHERE id = 1`).Scan(&value); err != nil {
+		t.Fatalf("Failed to query restored data: %v", err)
+	}
+	if value != "hunter2" {
+		t.Errorf("Expected restored value hunter2, got %s", value)
+	}
+
+	t.Setenv("MIGR8_TEST_BACKUP_PASSPHRASE", "wrong")
+	if _, err := bm.Restore(info.Path, RestoreOptions{}); err == nil {
+		t.Error("Expected restore with wrong passphrase to fail")
+	}
+
+	t.Setenv("MIGR8_TEST_BACKUP_PASSPHRASE", "")
+	if _, checked, err := Verify(info.Path, bm.config.Backup.Encryption); err != nil || checked {
+		t.Errorf("Expected checksum-only verification without a key, checked=%t err=%v", checked, err)
+	}
+}
+
+func TestAnonymizedBackup(t *testing.T) {
+	bm := newTestManager(t, "sqlite3")
+	bm.config.Backup.Engine = "external"
+	bm.config.Backup.Compression = false
+	bm.config.Anonymize = config.AnonymizeConfig{
+		SaltEnv: "MIGR8_TEST_ANONYMIZE_SALT",
+		Tables: map[string]map[string]string{
+			"users": {"email": "fake_email", "name": "fake_name"},
+			"posts": {"author_email": "fake_email"},
+		},
+	}
+	t.Setenv("MIGR8_TEST_ANONYMIZE_SALT", "pepper")
+
+	for _, stmt := range []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT UNIQUE)`,
+		`CREATE TABLE posts (id INTEGER PRIMARY KEY, author_email TEXT REFERENCES users(email))`,
+		`INSERT INTO users VALUES (1, 'Ann Real', 'ann@corp.example'), (2, 'Bob Real', 'bob@corp.example')`,
+		`INSERT INTO posts VALUES (1, 'ann@corp.example'), (2, 'bob@corp.example'), (3, 'ann@corp.example')`,
+	} {
+		if _, err := bm.db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to prepare schema: %v", err)
+		}
+	}
+
+	info, err := bm.Create(BackupOptions{Anonymize: true})
+	if err != nil {
+		t.Fatalf("Failed to create backup: %v", err)
+	}
+	manifest, err := ReadManifest(info.Path)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if !info.Anonymized || !manifest.Anonymized || manifest.Engine != "native" {
+		t.Errorf("Expected an anonymized native backup, got %+v", manifest)
+	}
+
+	dump, err := os.ReadFile(info.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"corp.example", "Real"} {
+		if strings.Contains(string(dump), secret) {
+			t.Errorf("Expected %q to be anonymized in the dump", secret)
+		}
+	}
+
+	if _, err := bm.Restore(info.Path, RestoreOptions{}); err != nil {
+		t.Fatalf("Failed to restore backup: %v", err)
+	}
+	var joined int
+	if err := bm.db.QueryRow(`SELECT COUNT(*) FROM posts p JOIN users u ON u.email = p.author_email`).Scan(&joined); err != nil {
+		t.Fatal(err)
+	}
+	if joined != 3 {
+		t.Errorf("Expected anonymized emails to still join, got %d matches", joined)
+	}
+
+	bm.config.Anonymize.Tables["user"] = map[string]string{"email": "hash"}
+	if _, err := bm.Create(BackupOptions{Anonymize: true}); err == nil || !strings.Contains(err.Error(), "user") {
+		t.Errorf("Expected an error for rules naming a missing table, got %v", err)
+	}
+}
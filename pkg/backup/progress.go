@@ -0,0 +1,58 @@
+package backup
+
+import (
+	"io"
+	"time"
+)
+
+// progressInterval is the minimum time between progress reports.
+const progressInterval = time.Second
+
+// BackupProgress describes a running dump. Bytes counts the dump itself,
+// before compression and encryption.
+type BackupProgress struct {
+	Bytes   int64
+	Elapsed time.Duration
+}
+
+// Throughput is the average dump rate so far in bytes per second.
+func (p BackupProgress) Throughput() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) / p.Elapsed.Seconds()
+}
+
+// progressWriter counts the bytes of a dump and reports them to a callback
+// from the writing goroutine, at most once per progressInterval.
+type progressWriter struct {
+	w        io.Writer
+	started  time.Time
+	reported time.Time
+	bytes    int64
+	callback func(BackupProgress)
+}
+
+func newProgressWriter(w io.Writer, started time.Time, callback func(BackupProgress)) *progressWriter {
+	return &progressWriter{w: w, started: started, reported: started, callback: callback}
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.bytes += int64(n)
+
+	if pw.callback != nil {
+		if now := time.Now(); now.Sub(pw.reported) >= progressInterval {
+			pw.reported = now
+			pw.callback(BackupProgress{Bytes: pw.bytes, Elapsed: now.Sub(pw.started)})
+		}
+	}
+	return n, err
+}
+
+// report sends a final progress update.
+func (pw *progressWriter) report() {
+	if pw.callback != nil {
+		pw.callback(BackupProgress{Bytes: pw.bytes, Elapsed: time.Since(pw.started)})
+	}
+}
@@ -0,0 +1,284 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"migr8/pkg/config"
+	"migr8/pkg/database"
+)
+
+// RestorePlan describes a restore before it happens, so it can be shown to
+// the user for confirmation.
+type RestorePlan struct {
+	Backup BackupInfo
+	// Manifest is nil for backups taken before manifests existed.
+	Manifest *Manifest
+	Engine   string
+
+	Target          config.DatabaseConfig
+	TargetMigration string
+
+	// CanSwap reports whether the target's driver can restore into a
+	// scratch database and swap it in.
+	CanSwap bool
+
+	Warnings []string
+
+	storage Storage
+	name    string
+}
+
+type RestoreOptions struct {
+	// PreRestoreBackup takes a backup of the target before anything is
+	// changed.
+	PreRestoreBackup bool
+	// Swap restores into a scratch database and only replaces the target
+	// once the whole backup has been applied. Requires RestorePlan.CanSwap.
+	Swap bool
+}
+
+type RestoreResult struct {
+	PreRestoreBackup *BackupInfo
+	Swapped          bool
+	// PreviousDatabase is what the replaced target was renamed to by a
+	// swap. It is kept so the restore can be undone.
+	PreviousDatabase string
+}
+
+// PlanRestore resolves ref, which is either a local file path or the name of
+// a backup in the configured storage, and compares it with the target.
+func (bm *BackupManager) PlanRestore(ref string) (*RestorePlan, error) {
+	storage, name, err := bm.resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := storage.Get(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("backup file not found: %s", ref)
+		}
+		return nil, err
+	}
+	object.Close()
+
+	plan := &RestorePlan{
+		Engine:          bm.config.Backup.Engine,
+		Target:          bm.config.Database,
+		TargetMigration: bm.latestMigration(),
+		storage:         storage,
+		name:            name,
+	}
+
+	manifest, err := readManifest(storage, name)
+	switch {
+	case err == nil:
+		plan.Manifest = manifest
+		plan.Backup = *manifest.info(name, storage.Location(name))
+		if manifest.Engine != "" {
+			plan.Engine = manifest.Engine
+		}
+	case errors.Is(err, os.ErrNotExist):
+		plan.Backup = BackupInfo{Filename: name, Path: storage.Location(name)}
+		plan.Warnings = append(plan.Warnings, "backup has no manifest; its source cannot be checked")
+	default:
+		return nil, err
+	}
+
+	_, isAdmin := bm.db.Dialect.(database.DatabaseAdmin)
+	_, isRenamer := bm.db.Dialect.(database.DatabaseRenamer)
+	plan.CanSwap = isAdmin && isRenamer && bm.config.Database.Database != ":memory:"
+
+	// Swapping in a scratch database restored from a partial backup would
+	// drop everything the backup does not contain.
+	if plan.Backup.Scope != nil {
+		plan.CanSwap = false
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("backup is partial (%s); it is applied in place and other tables are left alone",
+			DescribeScope(plan.Backup.Scope)))
+	}
+
+	if manifest != nil {
+		if manifest.Driver != "" && manifest.Driver != bm.db.Driver {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("backup was taken from a %s database, target is %s", manifest.Driver, bm.db.Driver))
+		}
+		if manifest.Database != bm.config.Database.Database || manifest.Host != bm.config.Database.Host {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("backup was taken from %s on %s, target is %s on %s",
+				manifest.Database, manifest.Host, bm.config.Database.Database, bm.config.Database.Host))
+		}
+		if plan.TargetMigration > manifest.MigrationVersion {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("target is at migration %s, newer than the backup's %s",
+				plan.TargetMigration, displayMigration(manifest.MigrationVersion)))
+		}
+	}
+
+	return plan, nil
+}
+
+func displayMigration(version string) string {
+	if version == "" {
+		return "(none)"
+	}
+	return version
+}
+
+// Restore replays the backup ref into the configured database. Without
+// Swap the backup is applied in place; native backups run in a single
+// transaction and psql is told to stop and roll back at the first error,
+// but other tools, and MySQL's implicit commits of DDL, may leave a
+// partially restored database behind.
+func (bm *BackupManager) Restore(ref string, opts RestoreOptions) (*RestoreResult, error) {
+	plan, err := bm.PlanRestore(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Swap && !plan.CanSwap {
+		if plan.Backup.Scope != nil {
+			return nil, fmt.Errorf("partial backups cannot be restored via a scratch database")
+		}
+		return nil, fmt.Errorf("restoring via a scratch database is not supported for driver %s", bm.db.Driver)
+	}
+
+	result := &RestoreResult{}
+	if opts.PreRestoreBackup {
+		info, err := bm.create("pre-restore", BackupOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("pre-restore backup failed: %w", err)
+		}
+		result.PreRestoreBackup = info
+	}
+
+	if opts.Swap {
+		// A swap that succeeded can still fail to reconnect afterwards.
+		previous, err := bm.restoreSwap(plan)
+		if previous != "" {
+			result.Swapped = true
+			result.PreviousDatabase = previous
+		}
+		return result, err
+	}
+
+	return result, bm.restoreInto(bm.db, bm.config.Database, plan)
+}
+
+// restoreInto applies the planned backup to db, whose configuration is
+// target.
+func (bm *BackupManager) restoreInto(db *database.DB, target config.DatabaseConfig, plan *RestorePlan) error {
+	reader, err := openBackup(plan.storage, plan.name, bm.config.Backup.Encryption)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if plan.Engine == "native" {
+		return restoreNative(db, reader)
+	}
+
+	cmd, err := db.Dialect.RestoreCommand(target)
+	if err != nil {
+		return fmt.Errorf("restore not supported for driver %s: %w", db.Driver, err)
+	}
+
+	var stderr strings.Builder
+	cmd.Stdin = reader
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("restore command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// restoreSwap restores into <database>_restore_<timestamp>, then renames the
+// target to <database>_before_restore_<timestamp> and the scratch database
+// into its place. The target is untouched if the restore fails. It returns
+// the name the target was moved to once the swap is done, even when
+// reconnecting afterwards fails.
+func (bm *BackupManager) restoreSwap(plan *RestorePlan) (_ string, err error) {
+	admin := bm.db.Dialect.(database.DatabaseAdmin)
+	renamer := bm.db.Dialect.(database.DatabaseRenamer)
+
+	timestamp := time.Now().Format("20060102_150405")
+	target := bm.config.Database
+	scratch := target
+	scratch.Database = target.Database + "_restore_" + timestamp
+	previous := target.Database + "_before_restore_" + timestamp
+
+	if err := admin.CreateDatabase(scratch); err != nil {
+		return "", fmt.Errorf("failed to create scratch database %s: %w", displayDatabase(scratch), err)
+	}
+
+	scratchConfig := *bm.config
+	scratchConfig.Database = scratch
+	scratchDB, err := database.NewConnection(&scratchConfig)
+	if err != nil {
+		admin.DropDatabase(scratch)
+		return "", err
+	}
+
+	err = bm.restoreInto(scratchDB, scratch, plan)
+	scratchDB.Close()
+	if err != nil {
+		admin.DropDatabase(scratch)
+		return "", fmt.Errorf("restore into scratch database failed, target left unchanged: %w", err)
+	}
+
+	// Our own connections would block the rename.
+	bm.db.Close()
+	defer func() {
+		if rerr := bm.reconnect(); rerr != nil {
+			if err == nil {
+				err = fmt.Errorf("database restored, but failed to reconnect: %w", rerr)
+			} else {
+				err = fmt.Errorf("%w; also failed to reconnect: %v", err, rerr)
+			}
+		}
+	}()
+
+	if err := renamer.RenameDatabase(target, previous); err != nil {
+		admin.DropDatabase(scratch)
+		return "", fmt.Errorf("failed to move target aside: %w", err)
+	}
+
+	if err := renamer.RenameDatabase(scratch, target.Database); err != nil {
+		moved := target
+		moved.Database = previous
+		if rerr := renamer.RenameDatabase(moved, target.Database); rerr != nil {
+			return "", fmt.Errorf("failed to swap in restored database (%v) and to put the original back (%v); it is now %s and the restored copy is %s",
+				err, rerr, previous, scratch.Database)
+		}
+		admin.DropDatabase(scratch)
+		return "", fmt.Errorf("failed to swap in restored database, target left unchanged: %w", err)
+	}
+
+	return previous, nil
+}
+
+// reconnect replaces the connection closed for a swap. The manager is left
+// with the closed one when it fails.
+func (bm *BackupManager) reconnect() error {
+	db, err := database.NewConnection(bm.config)
+	if err != nil {
+		return err
+	}
+	bm.db = db
+	return nil
+}
+
+// displayDatabase names a database for messages; SQLite paths are shortened
+// to the file name.
+func displayDatabase(cfg config.DatabaseConfig) string {
+	if cfg.Driver == "sqlite3" || cfg.Driver == "sqlite" {
+		return filepath.Base(cfg.Database)
+	}
+	return cfg.Database
+}
+
+// TargetName is the name the user confirms a restore with.
+func (p *RestorePlan) TargetName() string {
+	return displayDatabase(p.Target)
+}
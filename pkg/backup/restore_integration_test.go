@@ -0,0 +1,153 @@
+//go:build integration
+
+package backup
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+
+	"migr8/pkg/config"
+	"migr8/pkg/database"
+)
+
+// newPostgresTestManager returns a manager for a fresh database of the given
+// name on the server described by the TEST_DB_* variables, taking external
+// backups with pg_dump.
+func newPostgresTestManager(t *testing.T, name string) *BackupManager {
+	t.Helper()
+
+	if os.Getenv("TEST_DB_DRIVER") != "postgres" {
+		t.Skip("TEST_DB_DRIVER is not postgres")
+	}
+	if _, err := exec.LookPath("pg_dump"); err != nil {
+		t.Skip("pg_dump not installed")
+	}
+
+	var cfg config.Config
+	cfg.Database.Driver = "postgres"
+	cfg.Database.Host = getEnv("TEST_DB_HOST", "localhost")
+	cfg.Database.Port = getEnvInt("TEST_DB_PORT", 5432)
+	cfg.Database.Username = getEnv("TEST_DB_USER", "testuser")
+	cfg.Database.Password = getEnv("TEST_DB_PASS", "testpass")
+	cfg.Database.SSLMode = "disable"
+	cfg.Database.Database = name
+	cfg.Backup.Directory = t.TempDir()
+	cfg.Backup.Compression = true
+	cfg.Backup.Engine = "external"
+	cfg.Migration.Table = "schema_migrations"
+
+	dropPostgresDatabase(t, cfg.Database, name)
+	if _, err := database.EnsureDatabase(cfg.Database); err != nil {
+		t.Fatalf("Failed to create database %s: %v", name, err)
+	}
+
+	bm, err := NewBackupManager(&cfg)
+	if err != nil {
+		t.Fatalf("Failed to create backup manager: %v", err)
+	}
+	t.Cleanup(func() {
+		bm.Close()
+		dropPostgresDatabase(t, cfg.Database, name)
+	})
+
+	return bm
+}
+
+func dropPostgresDatabase(t *testing.T, cfg config.DatabaseConfig, name string) {
+	t.Helper()
+
+	dialect, _ := database.GetDialect("postgres")
+	cfg.Database = name
+	if err := dialect.(database.DatabaseAdmin).DropDatabase(cfg); err != nil {
+		t.Fatalf("Failed to drop database %s: %v", name, err)
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if intValue, err := strconv.Atoi(value); err == nil {
+			return intValue
+		}
+	}
+	return defaultValue
+}
+
+// TestPostgresRestoreSwap restores a pg_dump backup into an empty scratch
+// database, where the dump's DROP statements have nothing to drop.
+func TestPostgresRestoreSwap(t *testing.T) {
+	bm := newPostgresTestManager(t, "migr8_restore_test")
+
+	for _, stmt := range []string{
+		`CREATE TABLE items (id SERIAL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO items (name) VALUES ('a'), ('b')`,
+	} {
+		if _, err := bm.db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to prepare schema: %v", err)
+		}
+	}
+
+	info, err := bm.Create(BackupOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create backup: %v", err)
+	}
+
+	if _, err := bm.db.Exec(`INSERT INTO items (name) VALUES ('c')`); err != nil {
+		t.Fatalf("Failed to modify data: %v", err)
+	}
+
+	plan, err := bm.PlanRestore(info.Filename)
+	if err != nil {
+		t.Fatalf("Failed to plan restore: %v", err)
+	}
+	if !plan.CanSwap || plan.Engine != "external" {
+		t.Fatalf("Expected an external backup that can be swapped in, got %+v", plan)
+	}
+
+	result, err := bm.Restore(info.Filename, RestoreOptions{Swap: true})
+	if err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	defer dropPostgresDatabase(t, bm.config.Database, result.PreviousDatabase)
+
+	if n := countRows(t, bm, "items"); n != 2 {
+		t.Errorf("Expected 2 restored items, got %d", n)
+	}
+}
+
+// TestPostgresRestoreIntoOtherDatabase restores in place into a target that
+// was just created empty, as backup restore --target-database does.
+func TestPostgresRestoreIntoOtherDatabase(t *testing.T) {
+	source := newPostgresTestManager(t, "migr8_restore_source")
+
+	for _, stmt := range []string{
+		`CREATE TABLE items (id SERIAL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO items (name) VALUES ('a'), ('b')`,
+	} {
+		if _, err := source.db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to prepare schema: %v", err)
+		}
+	}
+
+	info, err := source.Create(BackupOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create backup: %v", err)
+	}
+
+	target := newPostgresTestManager(t, "migr8_restore_clone")
+
+	if _, err := target.Restore(info.Path, RestoreOptions{}); err != nil {
+		t.Fatalf("Failed to restore into clone: %v", err)
+	}
+	if n := countRows(t, target, "items"); n != 2 {
+		t.Errorf("Expected 2 items in clone, got %d", n)
+	}
+}
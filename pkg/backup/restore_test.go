@@ -0,0 +1,194 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"migr8/pkg/database"
+)
+
+func countRows(t *testing.T, bm *BackupManager, table string) int {
+	t.Helper()
+
+	var count int
+	if err := bm.db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&count); err != nil {
+		t.Fatalf("Failed to count %s: %v", table, err)
+	}
+	return count
+}
+
+func TestRestoreSwap(t *testing.T) {
+	bm := newTestManager(t, "sqlite3")
+
+	for _, stmt := range []string{
+		`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`,
+		`INSERT INTO items (id, name) VALUES (1, 'a'), (2, 'b')`,
+	} {
+		if _, err := bm.db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to prepare schema: %v", err)
+		}
+	}
+
+	info, err := bm.Create(BackupOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create backup: %v", err)
+	}
+
+	if _, err := bm.db.Exec(`INSERT INTO items (id, name) VALUES (3, 'c')`); err != nil {
+		t.Fatalf("Failed to modify data: %v", err)
+	}
+
+	plan, err := bm.PlanRestore(info.Filename)
+	if err != nil {
+		t.Fatalf("Failed to plan restore: %v", err)
+	}
+	if !plan.CanSwap || plan.Manifest == nil || plan.Engine != "native" {
+		t.Errorf("Unexpected plan: %+v", plan)
+	}
+	if plan.TargetName() != "test.db" {
+		t.Errorf("Expected target name test.db, got %s", plan.TargetName())
+	}
+
+	result, err := bm.Restore(info.Filename, RestoreOptions{Swap: true, PreRestoreBackup: true})
+	if err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	if !result.Swapped || result.PreRestoreBackup == nil {
+		t.Fatalf("Expected swapped restore with pre-restore backup, got %+v", result)
+	}
+
+	if n := countRows(t, bm, "items"); n != 2 {
+		t.Errorf("Expected 2 restored items, got %d", n)
+	}
+
+	if _, err := os.Stat(result.PreviousDatabase); err != nil {
+		t.Errorf("Expected previous database to be kept at %s: %v", result.PreviousDatabase, err)
+	}
+
+	scratch, _ := filepath.Glob(bm.config.Database.Database + "_restore_*")
+	if len(scratch) != 0 {
+		t.Errorf("Expected no scratch database left, got %v", scratch)
+	}
+
+	backups, err := bm.List()
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(backups) != 2 {
+		t.Errorf("Expected original and pre-restore backups, got %d", len(backups))
+	}
+}
+
+func TestRestoreSwapFailureLeavesTarget(t *testing.T) {
+	bm := newTestManager(t, "sqlite3")
+
+	for _, stmt := range []string{
+		`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`,
+		`INSERT INTO items (id, name) VALUES (1, 'a')`,
+	} {
+		if _, err := bm.db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to prepare schema: %v", err)
+		}
+	}
+
+	broken := filepath.Join(t.TempDir(), "broken_20200101_000000.sql")
+	script := "CREATE TABLE items (id INTEGER PRIMARY KEY);\nINSERT INTO missing VALUES (1);\n"
+	if err := os.WriteFile(broken, []byte(script), 0644); err != nil {
+		t.Fatalf("Failed to write backup: %v", err)
+	}
+
+	plan, err := bm.PlanRestore(broken)
+	if err != nil {
+		t.Fatalf("Failed to plan restore: %v", err)
+	}
+	if plan.Manifest != nil || len(plan.Warnings) == 0 {
+		t.Errorf("Expected a missing manifest warning, got %+v", plan)
+	}
+
+	_, err = bm.Restore(broken, RestoreOptions{Swap: true})
+	if err == nil || !strings.Contains(err.Error(), "target left unchanged") {
+		t.Fatalf("Expected restore to fail without touching the target, got %v", err)
+	}
+
+	if n := countRows(t, bm, "items"); n != 1 {
+		t.Errorf("Expected target to keep its row, got %d", n)
+	}
+
+	scratch, _ := filepath.Glob(bm.config.Database.Database + "_*")
+	if len(scratch) != 0 {
+		t.Errorf("Expected no scratch or renamed databases, got %v", scratch)
+	}
+}
+
+func TestRestoreIntoOtherDatabase(t *testing.T) {
+	source := newTestManager(t, "sqlite3")
+
+	for _, stmt := range []string{
+		`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`,
+		`INSERT INTO items (id, name) VALUES (1, 'a'), (2, 'b')`,
+	} {
+		if _, err := source.db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to prepare schema: %v", err)
+		}
+	}
+
+	info, err := source.Create(BackupOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create backup: %v", err)
+	}
+
+	targetConfig := *source.config
+	targetConfig.Database.Database = filepath.Join(t.TempDir(), "clone.db")
+	if _, err := database.EnsureDatabase(targetConfig.Database); err != nil {
+		t.Fatalf("Failed to create target database: %v", err)
+	}
+
+	target, err := NewBackupManager(&targetConfig)
+	if err != nil {
+		t.Fatalf("Failed to create target manager: %v", err)
+	}
+	defer target.Close()
+
+	// A unique prefix of the backup name resolves against the shared
+	// backup storage.
+	prefix := strings.TrimSuffix(info.Filename, ".sql.gz")
+	plan, err := target.PlanRestore(prefix)
+	if err != nil {
+		t.Fatalf("Failed to plan restore: %v", err)
+	}
+	if plan.Backup.Filename != info.Filename {
+		t.Errorf("Expected %s to resolve to %s, got %s", prefix, info.Filename, plan.Backup.Filename)
+	}
+	if len(plan.Warnings) == 0 {
+		t.Error("Expected a warning that the backup comes from another database")
+	}
+
+	if _, err := target.Restore(prefix, RestoreOptions{Swap: true}); err != nil {
+		t.Fatalf("Failed to restore into clone: %v", err)
+	}
+	if n := countRows(t, target, "items"); n != 2 {
+		t.Errorf("Expected 2 items in clone, got %d", n)
+	}
+
+	if _, err := source.create("manual", BackupOptions{}); err != nil {
+		t.Fatalf("Failed to create second backup: %v", err)
+	}
+	if _, err := target.PlanRestore("test.db_"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("Expected ambiguous prefix error, got %v", err)
+	}
+}
+
+func TestReconnectFailure(t *testing.T) {
+	bm := newTestManager(t, "sqlite3")
+
+	db := bm.db
+	bm.config.Database.Driver = "nosuchdb"
+	if err := bm.reconnect(); err == nil || !strings.Contains(err.Error(), "nosuchdb") {
+		t.Errorf("Expected the reconnect error to be returned, got %v", err)
+	}
+	if bm.db != db {
+		t.Error("Expected the connection to be left as it was")
+	}
+}
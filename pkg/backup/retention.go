@@ -0,0 +1,227 @@
+package backup
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"migr8/pkg/config"
+)
+
+// RetentionPolicy decides which backups to keep. A backup is kept when at
+// least one rule selects it:
+//
+//   - KeepWithin: everything newer than this
+//   - KeepLast: the newest N backups
+//   - KeepDaily/Weekly/Monthly/Yearly: the newest backup of each of the
+//     last N days, ISO weeks, months or years that have a backup
+//   - MinKeep: the newest N backups, regardless of the other rules
+//
+// Rules are evaluated separately for every source database, so a busy
+// database cannot push another one's backups out, and databases of the same
+// name on different servers sharing a storage target leave each other's
+// backups alone.
+type RetentionPolicy struct {
+	KeepWithin  time.Duration
+	KeepLast    int
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+	KeepYearly  int
+	MinKeep     int
+}
+
+// RetentionDecision records what a policy decided for one backup and why.
+type RetentionDecision struct {
+	Backup  BackupInfo
+	Keep    bool
+	Reasons []string
+	// Err is set by Clean when deleting the backup failed.
+	Err error
+}
+
+// NewRetentionPolicy builds the policy described by the backup config.
+// A non-positive RetentionDays disables the age rule.
+func NewRetentionPolicy(cfg config.BackupConfig) RetentionPolicy {
+	policy := RetentionPolicy{
+		KeepLast:    cfg.Retention.KeepLast,
+		KeepDaily:   cfg.Retention.KeepDaily,
+		KeepWeekly:  cfg.Retention.KeepWeekly,
+		KeepMonthly: cfg.Retention.KeepMonthly,
+		KeepYearly:  cfg.Retention.KeepYearly,
+		MinKeep:     cfg.Retention.MinKeep,
+	}
+	if cfg.RetentionDays > 0 {
+		policy.KeepWithin = time.Duration(cfg.RetentionDays) * 24 * time.Hour
+	}
+	return policy
+}
+
+// BackupSource identifies the database a backup was taken from.
+type BackupSource struct {
+	Driver   string
+	Host     string
+	Port     int
+	Database string
+}
+
+func sourceOf(cfg config.DatabaseConfig) BackupSource {
+	return BackupSource{Driver: cfg.Driver, Host: cfg.Host, Port: cfg.Port, Database: cfg.Database}
+}
+
+// Source returns the database the backup was taken from, as far as it is
+// recorded.
+func (b BackupInfo) Source() BackupSource {
+	return BackupSource{Driver: b.Driver, Host: b.Host, Port: b.Port, Database: b.DatabaseName}
+}
+
+func (s BackupSource) String() string {
+	return fmt.Sprintf("%s://%s:%d/%s", s.Driver, s.Host, s.Port, s.Database)
+}
+
+// matches reports whether a backup taken from s belongs to filter. Empty
+// fields of filter match anything, and so do fields s does not record:
+// backups without a manifest only know their database.
+func (s BackupSource) matches(filter BackupSource) bool {
+	same := func(recorded, wanted string) bool {
+		return wanted == "" || recorded == "" || recorded == wanted
+	}
+	return same(s.Driver, filter.Driver) && same(s.Host, filter.Host) && same(s.Database, filter.Database) &&
+		(filter.Port == 0 || s.Port == 0 || s.Port == filter.Port)
+}
+
+// Apply returns one decision per backup, grouped by source database and
+// newest first within each group.
+func (p RetentionPolicy) Apply(backups []BackupInfo, now time.Time) []RetentionDecision {
+	groups := make(map[string][]BackupInfo)
+	var sources []string
+	for _, backup := range backups {
+		source := backup.Source().String()
+		if _, ok := groups[source]; !ok {
+			sources = append(sources, source)
+		}
+		groups[source] = append(groups[source], backup)
+	}
+	sort.Strings(sources)
+
+	var decisions []RetentionDecision
+	for _, source := range sources {
+		decisions = append(decisions, p.applyGroup(groups[source], now)...)
+	}
+	return decisions
+}
+
+func (p RetentionPolicy) applyGroup(backups []BackupInfo, now time.Time) []RetentionDecision {
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+
+	decisions := make([]RetentionDecision, len(backups))
+	for i, backup := range backups {
+		decisions[i].Backup = backup
+	}
+
+	keep := func(i int, reason string) {
+		decisions[i].Keep = true
+		decisions[i].Reasons = append(decisions[i].Reasons, reason)
+	}
+
+	for i, backup := range backups {
+		if p.KeepWithin > 0 && now.Sub(backup.CreatedAt) < p.KeepWithin {
+			keep(i, fmt.Sprintf("within %s", formatRetentionAge(p.KeepWithin)))
+		}
+		if i < p.KeepLast {
+			keep(i, fmt.Sprintf("last %d", p.KeepLast))
+		}
+	}
+
+	buckets := []struct {
+		name  string
+		count int
+		key   func(time.Time) string
+	}{
+		{"daily", p.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", p.KeepWeekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{"monthly", p.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", p.KeepYearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+	for _, bucket := range buckets {
+		seen := make(map[string]bool)
+		for i, backup := range backups {
+			if len(seen) >= bucket.count {
+				break
+			}
+			key := bucket.key(backup.CreatedAt)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			keep(i, bucket.name+" "+key)
+		}
+	}
+
+	// The floor is applied last so it only shows up as a reason when it
+	// actually saved a backup.
+	for i := 0; i < p.MinKeep && i < len(decisions); i++ {
+		if !decisions[i].Keep {
+			keep(i, fmt.Sprintf("minimum %d", p.MinKeep))
+		}
+	}
+
+	for i := range decisions {
+		if !decisions[i].Keep {
+			decisions[i].Reasons = []string{"not selected by any retention rule"}
+		}
+	}
+	return decisions
+}
+
+func formatRetentionAge(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%d days", d/(24*time.Hour))
+	}
+	return d.String()
+}
+
+// Clean applies the configured retention policy to the backups in storage
+// taken from databases matching source; the zero BackupSource matches them
+// all. With dryRun nothing is deleted and the decisions only describe what
+// would happen.
+func (bm *BackupManager) Clean(source BackupSource, dryRun bool) ([]RetentionDecision, error) {
+	backups, err := bm.List()
+	if err != nil {
+		return nil, err
+	}
+
+	if source != (BackupSource{}) {
+		var scoped []BackupInfo
+		for _, backup := range backups {
+			if backup.Source().matches(source) {
+				scoped = append(scoped, backup)
+			}
+		}
+		backups = scoped
+	}
+
+	decisions := NewRetentionPolicy(bm.config.Backup).Apply(backups, time.Now())
+	if dryRun {
+		return decisions, nil
+	}
+
+	for i, decision := range decisions {
+		if decision.Keep {
+			continue
+		}
+		if err := bm.storage.Delete(decision.Backup.Filename); err != nil {
+			decisions[i].Err = err
+			continue
+		}
+		if decision.Backup.HasManifest {
+			bm.storage.Delete(manifestName(decision.Backup.Filename))
+		}
+	}
+	return decisions, nil
+}
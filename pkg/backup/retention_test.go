@@ -0,0 +1,195 @@
+package backup
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func dailyBackups(database string, now time.Time, days int) []BackupInfo {
+	var backups []BackupInfo
+	for i := 0; i < days; i++ {
+		created := now.AddDate(0, 0, -i)
+		backups = append(backups, BackupInfo{
+			Filename:     fmt.Sprintf("%s_%s.sql.gz", database, created.Format("20060102_150405")),
+			DatabaseName: database,
+			CreatedAt:    created,
+		})
+	}
+	return backups
+}
+
+func keptNames(decisions []RetentionDecision) map[string][]string {
+	kept := make(map[string][]string)
+	for _, decision := range decisions {
+		if decision.Keep {
+			kept[decision.Backup.Filename] = decision.Reasons
+		}
+	}
+	return kept
+}
+
+func TestRetentionPolicyGFS(t *testing.T) {
+	now := time.Date(2024, 3, 15, 2, 0, 0, 0, time.UTC)
+	backups := dailyBackups("app", now, 400)
+
+	policy := RetentionPolicy{KeepDaily: 7, KeepWeekly: 4, KeepMonthly: 6, KeepYearly: 2}
+	decisions := policy.Apply(backups, now)
+	if len(decisions) != len(backups) {
+		t.Fatalf("Expected a decision per backup, got %d", len(decisions))
+	}
+
+	kept := keptNames(decisions)
+
+	for i := 0; i < 7; i++ {
+		name := backups[i].Filename
+		if _, ok := kept[name]; !ok {
+			t.Errorf("Expected daily backup %s to be kept", name)
+		}
+	}
+
+	// 2024 is a leap year, so the newest backup in February is the 29th.
+	feb := "app_20240229_020000.sql.gz"
+	if reasons, ok := kept[feb]; !ok || !strings.Contains(strings.Join(reasons, ","), "monthly 2024-02") {
+		t.Errorf("Expected %s kept as monthly, got %v", feb, reasons)
+	}
+
+	lastYear := "app_20231231_020000.sql.gz"
+	if reasons, ok := kept[lastYear]; !ok || !strings.Contains(strings.Join(reasons, ","), "yearly 2023") {
+		t.Errorf("Expected %s kept as yearly, got %v", lastYear, reasons)
+	}
+
+	// 7 daily, 2 more weekly (the newest of this and last week are daily
+	// picks), 5 more monthly and no extra yearly: 2023's pick is December's
+	// monthly one.
+	if len(kept) != 14 {
+		t.Errorf("Expected 14 backups kept, got %d", len(kept))
+	}
+
+	for _, decision := range decisions {
+		if !decision.Keep && decision.Reasons[0] != "not selected by any retention rule" {
+			t.Errorf("Expected removal reason, got %v", decision.Reasons)
+		}
+	}
+}
+
+func TestRetentionPolicyWithinAndLast(t *testing.T) {
+	now := time.Date(2024, 3, 15, 2, 0, 0, 0, time.UTC)
+	backups := dailyBackups("app", now, 60)
+
+	decisions := RetentionPolicy{KeepWithin: 30 * 24 * time.Hour}.Apply(backups, now)
+	if kept := len(keptNames(decisions)); kept != 30 {
+		t.Errorf("Expected 30 backups within 30 days, got %d", kept)
+	}
+
+	decisions = RetentionPolicy{KeepLast: 3}.Apply(backups, now)
+	if kept := len(keptNames(decisions)); kept != 3 {
+		t.Errorf("Expected last 3 backups kept, got %d", kept)
+	}
+}
+
+func TestRetentionPolicyMinKeepPerDatabase(t *testing.T) {
+	now := time.Date(2024, 3, 15, 2, 0, 0, 0, time.UTC)
+	old := now.AddDate(-1, 0, 0)
+
+	backups := append(dailyBackups("app", now, 10), dailyBackups("reporting", old, 5)...)
+
+	decisions := RetentionPolicy{KeepWithin: 7 * 24 * time.Hour, MinKeep: 2}.Apply(backups, now)
+	kept := keptNames(decisions)
+
+	if len(kept) != 9 {
+		t.Errorf("Expected 7 app and 2 reporting backups kept, got %d", len(kept))
+	}
+
+	floor := "reporting_" + old.Format("20060102_150405") + ".sql.gz"
+	if reasons := kept[floor]; len(reasons) != 1 || reasons[0] != "minimum 2" {
+		t.Errorf("Expected newest reporting backup kept by the floor, got %v", reasons)
+	}
+}
+
+func TestRetentionPolicyPerHost(t *testing.T) {
+	now := time.Date(2024, 3, 15, 2, 0, 0, 0, time.UTC)
+
+	staging := dailyBackups("app", now, 3)
+	production := dailyBackups("app", now.Add(time.Hour), 3)
+	for i := range staging {
+		staging[i].Driver, staging[i].Host, staging[i].Port = "postgres", "staging", 5432
+		production[i].Driver, production[i].Host, production[i].Port = "postgres", "production", 5432
+		production[i].Filename = "prod_" + production[i].Filename
+	}
+
+	kept := keptNames(RetentionPolicy{KeepLast: 1}.Apply(append(staging, production...), now))
+	if len(kept) != 2 {
+		t.Errorf("Expected the newest backup of each host kept, got %v", kept)
+	}
+
+	filter := BackupSource{Driver: "postgres", Host: "staging", Port: 5432, Database: "app"}
+	tests := []struct {
+		backup BackupInfo
+		want   bool
+	}{
+		{staging[0], true},
+		{production[0], false},
+		{BackupInfo{Driver: "postgres", Host: "staging", Port: 6432, DatabaseName: "app"}, false},
+		{BackupInfo{Driver: "postgres", Host: "staging", DatabaseName: "app"}, true},
+		{BackupInfo{DatabaseName: "app"}, true},
+		{BackupInfo{DatabaseName: "other"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.backup.Source().matches(filter); got != tt.want {
+			t.Errorf("Expected %s to match %s: %v, got %v", tt.backup.Source(), filter, tt.want, got)
+		}
+	}
+}
+
+func TestCleanDryRun(t *testing.T) {
+	bm := newTestManager(t, "sqlite3")
+	bm.config.Backup.RetentionDays = 0
+	bm.config.Backup.Retention.KeepLast = 1
+
+	var created []*BackupInfo
+	for i := 0; i < 3; i++ {
+		info, err := bm.Create(BackupOptions{})
+		if err != nil {
+			t.Fatalf("Failed to create backup: %v", err)
+		}
+		created = append(created, info)
+		time.Sleep(1100 * time.Millisecond)
+	}
+
+	decisions, err := bm.Clean(BackupSource{}, true)
+	if err != nil {
+		t.Fatalf("Failed to plan clean: %v", err)
+	}
+	if len(decisions) != 3 || !decisions[0].Keep || decisions[1].Keep || decisions[2].Keep {
+		t.Fatalf("Expected only the newest backup kept, got %+v", decisions)
+	}
+
+	if backups, _ := bm.List(); len(backups) != 3 {
+		t.Errorf("Expected dry run to keep all backups, got %d", len(backups))
+	}
+
+	if _, err := bm.Clean(BackupSource{Database: "other"}, false); err != nil {
+		t.Fatalf("Failed to clean other database: %v", err)
+	}
+	if backups, _ := bm.List(); len(backups) != 3 {
+		t.Errorf("Expected cleaning another database to keep all backups, got %d", len(backups))
+	}
+
+	if _, err := bm.Clean(BackupSource{}, false); err != nil {
+		t.Fatalf("Failed to clean: %v", err)
+	}
+	backups, err := bm.List()
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(backups) != 1 || backups[0].Filename != created[2].Filename {
+		t.Errorf("Expected only %s left, got %+v", created[2].Filename, backups)
+	}
+
+	objects, _ := bm.storage.List()
+	if len(objects) != 2 {
+		t.Errorf("Expected the kept backup and its manifest in storage, got %+v", objects)
+	}
+}
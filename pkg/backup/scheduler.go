@@ -0,0 +1,339 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"migr8/pkg/config"
+)
+
+// DefaultJob names the scheduled job for the top-level database.
+const DefaultJob = "default"
+
+// ScheduledJob backs up one environment's database on a cron schedule.
+type ScheduledJob struct {
+	Name     string
+	Schedule *Schedule
+	Config   *config.Config
+}
+
+// JobState is what the scheduler knows about a job. It is written to the
+// state file after every change, so other processes can report on a
+// running scheduler.
+type JobState struct {
+	Schedule            string    `json:"schedule"`
+	Running             bool      `json:"running"`
+	NextRun             time.Time `json:"next_run"`
+	LastRun             time.Time `json:"last_run"`
+	LastSuccess         time.Time `json:"last_success"`
+	LastFailure         time.Time `json:"last_failure"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastBackup          string    `json:"last_backup,omitempty"`
+	Removed             int       `json:"removed,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	SkippedRuns         int       `json:"skipped_runs,omitempty"`
+}
+
+// Scheduler runs ScheduledJobs until its context is cancelled. A job never
+// overlaps itself: a run that is still going when the next one is due
+// causes that one to be skipped. Across processes, runs of the same
+// database are serialised by a database lock.
+type Scheduler struct {
+	jobs       []ScheduledJob
+	retries    int
+	retryDelay time.Duration
+	statePath  string
+	out        io.Writer
+
+	// run performs one attempt of a job; tests replace it.
+	run func(job ScheduledJob) (*BackupInfo, int, error)
+
+	mu    sync.Mutex
+	state map[string]*JobState
+}
+
+// NewScheduler builds a job for the top-level database when
+// backup.schedule.cron is set and one for every environment with a
+// backup_schedule.
+func NewScheduler(cfg *config.Config, out io.Writer) (*Scheduler, error) {
+	var jobs []ScheduledJob
+
+	if cfg.Backup.Schedule.Cron != "" {
+		schedule, err := ParseSchedule(cfg.Backup.Schedule.Cron)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, ScheduledJob{Name: DefaultJob, Schedule: schedule, Config: cfg})
+	}
+
+	names := make([]string, 0, len(cfg.Environments))
+	for name := range cfg.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		expr := cfg.Environments[name].BackupSchedule
+		if expr == "" {
+			continue
+		}
+		schedule, err := ParseSchedule(expr)
+		if err != nil {
+			return nil, fmt.Errorf("environment %s: %w", name, err)
+		}
+		envCfg, err := cfg.ForEnvironment(name)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, ScheduledJob{Name: name, Schedule: schedule, Config: envCfg})
+	}
+
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("no backup schedules configured; set backup.schedule.cron or an environment's backup_schedule")
+	}
+
+	s := &Scheduler{
+		jobs:       jobs,
+		retries:    cfg.Backup.Schedule.Retries,
+		retryDelay: cfg.Backup.Schedule.RetryDelay,
+		statePath:  cfg.Backup.Schedule.StateFile,
+		out:        out,
+		run:        runScheduledBackup,
+		state:      make(map[string]*JobState, len(jobs)),
+	}
+	for _, job := range jobs {
+		s.state[job.Name] = &JobState{Schedule: job.Schedule.String()}
+	}
+	return s, nil
+}
+
+// Jobs returns the configured jobs.
+func (s *Scheduler) Jobs() []ScheduledJob {
+	return s.jobs
+}
+
+// Run blocks until ctx is cancelled, then waits for runs in progress to
+// finish before returning. Pending retries are abandoned.
+func (s *Scheduler) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, job := range s.jobs {
+		wg.Add(1)
+		go func(job ScheduledJob) {
+			defer wg.Done()
+			s.loop(ctx, job)
+		}(job)
+	}
+	wg.Wait()
+	return s.saveState()
+}
+
+func (s *Scheduler) loop(ctx context.Context, job ScheduledJob) {
+	next := job.Schedule.Next(time.Now())
+	for {
+		if next.IsZero() {
+			s.logf("%s: schedule %s never fires again", job.Name, job.Schedule)
+			return
+		}
+		s.update(job.Name, func(st *JobState) { st.NextRun = next })
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.runJob(ctx, job)
+
+		// Runs that came due while this one was going are skipped, not
+		// queued.
+		now := time.Now()
+		skipped := 0
+		for next = job.Schedule.Next(next); !next.IsZero() && !next.After(now); next = job.Schedule.Next(next) {
+			skipped++
+		}
+		if skipped > 0 {
+			s.logf("%s: skipped %d run(s) while the previous run was in progress", job.Name, skipped)
+			s.update(job.Name, func(st *JobState) { st.SkippedRuns += skipped })
+		}
+	}
+}
+
+// runJob runs a job once, retrying failures. It returns early without
+// retrying further when ctx is cancelled, but never interrupts an attempt.
+func (s *Scheduler) runJob(ctx context.Context, job ScheduledJob) {
+	s.update(job.Name, func(st *JobState) {
+		st.Running = true
+		st.LastRun = time.Now()
+	})
+	defer s.update(job.Name, func(st *JobState) { st.Running = false })
+
+	for attempt := 0; ; attempt++ {
+		s.logf("%s: starting backup (attempt %d of %d)", job.Name, attempt+1, s.retries+1)
+		started := time.Now()
+		info, removed, err := s.run(job)
+		if info != nil {
+			// The backup exists; a failed cleanup is reported but does not
+			// warrant taking another one.
+			if err != nil {
+				s.logf("%s: %v", job.Name, err)
+			} else {
+				s.logf("%s: created %s in %s, removed %d old backup(s)", job.Name, info.Filename,
+					time.Since(started).Round(time.Millisecond), removed)
+			}
+			s.update(job.Name, func(st *JobState) {
+				st.LastSuccess = time.Now()
+				st.LastBackup = info.Filename
+				st.Removed = removed
+				st.ConsecutiveFailures = 0
+				if err != nil {
+					st.LastFailure = st.LastSuccess
+					st.LastError = err.Error()
+				}
+			})
+			return
+		}
+
+		s.logf("%s: backup failed: %v", job.Name, err)
+		s.update(job.Name, func(st *JobState) {
+			st.LastFailure = time.Now()
+			st.LastError = err.Error()
+			st.ConsecutiveFailures++
+		})
+
+		if attempt >= s.retries {
+			return
+		}
+
+		delay := s.retryDelay * time.Duration(1<<uint(attempt))
+		s.logf("%s: retrying in %s", job.Name, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.logf("%s: shutting down, retry abandoned", job.Name)
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// runScheduledBackup takes a backup of the job's database and applies the
+// retention policy to that database's backups, leaving those of other
+// environments in the same storage alone. A fresh connection is used
+// for every run so a daemon survives database restarts.
+func runScheduledBackup(job ScheduledJob) (*BackupInfo, int, error) {
+	bm, err := NewBackupManager(job.Config)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer bm.Close()
+
+	var info *BackupInfo
+	var removed int
+	err = bm.db.WithLock("migr8_backup", func() error {
+		created, err := bm.create("scheduled", BackupOptions{})
+		if err != nil {
+			return err
+		}
+		info = created
+
+		decisions, err := bm.Clean(sourceOf(job.Config.Database), false)
+		if err != nil {
+			return fmt.Errorf("backup %s created, but cleanup failed: %w", info.Filename, err)
+		}
+		for _, decision := range decisions {
+			if decision.Err != nil {
+				return fmt.Errorf("backup %s created, but removing %s failed: %w", info.Filename, decision.Backup.Filename, decision.Err)
+			}
+			if !decision.Keep {
+				removed++
+			}
+		}
+		return nil
+	})
+	return info, removed, err
+}
+
+// State returns a copy of every job's state.
+func (s *Scheduler) State() map[string]JobState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := make(map[string]JobState, len(s.state))
+	for name, st := range s.state {
+		state[name] = *st
+	}
+	return state
+}
+
+func (s *Scheduler) update(name string, fn func(st *JobState)) {
+	s.mu.Lock()
+	fn(s.state[name])
+	s.mu.Unlock()
+
+	if err := s.saveState(); err != nil {
+		s.logf("failed to write state file: %v", err)
+	}
+}
+
+// saveState writes the state file atomically, so readers never see a
+// partial document.
+func (s *Scheduler) saveState() error {
+	if s.statePath == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.State(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.statePath), ".migr8-schedule.*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.statePath)
+}
+
+// ReadScheduleState loads the state file written by a scheduler.
+func ReadScheduleState(path string) (map[string]JobState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no scheduler state at %s; is `backup schedule` running?", path)
+		}
+		return nil, err
+	}
+
+	var state map[string]JobState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse scheduler state %s: %w", path, err)
+	}
+	return state, nil
+}
+
+func (s *Scheduler) logf(format string, args ...interface{}) {
+	if s.out == nil {
+		return
+	}
+	fmt.Fprintf(s.out, "%s %s\n", time.Now().Format(time.RFC3339), fmt.Sprintf(format, args...))
+}
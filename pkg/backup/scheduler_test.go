@@ -0,0 +1,192 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"migr8/pkg/config"
+)
+
+func TestSchedulerJobs(t *testing.T) {
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{Driver: "sqlite", Database: "prod.db"},
+		Environments: map[string]config.EnvironmentConfig{
+			"staging": {Database: config.DatabaseConfig{Database: "staging.db"}, BackupSchedule: "@hourly"},
+			"dev":     {Database: config.DatabaseConfig{Database: "dev.db"}},
+		},
+	}
+
+	if _, err := NewScheduler(&config.Config{}, nil); err == nil {
+		t.Error("Expected error without any schedule")
+	}
+
+	cfg.Backup.Schedule.Cron = "0 2 * * *"
+	s, err := NewScheduler(cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed to create scheduler: %v", err)
+	}
+
+	jobs := s.Jobs()
+	if len(jobs) != 2 || jobs[0].Name != DefaultJob || jobs[1].Name != "staging" {
+		t.Fatalf("Expected default and staging jobs, got %+v", jobs)
+	}
+	if jobs[1].Config.Database.Database != "staging.db" || jobs[1].Config.Database.Driver != "sqlite" {
+		t.Errorf("Expected staging job to use the merged environment database, got %+v", jobs[1].Config.Database)
+	}
+
+	cfg.Environments["dev"] = config.EnvironmentConfig{BackupSchedule: "every day"}
+	if _, err := NewScheduler(cfg, nil); err == nil || !strings.Contains(err.Error(), "dev") {
+		t.Errorf("Expected invalid environment schedule to be reported, got %v", err)
+	}
+}
+
+func TestSchedulerRetriesAndState(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Backup.Schedule = config.ScheduleConfig{
+		Cron:       "@daily",
+		Retries:    2,
+		RetryDelay: time.Millisecond,
+		StateFile:  filepath.Join(t.TempDir(), "state.json"),
+	}
+
+	var log strings.Builder
+	s, err := NewScheduler(cfg, &log)
+	if err != nil {
+		t.Fatalf("Failed to create scheduler: %v", err)
+	}
+
+	attempts := 0
+	s.run = func(job ScheduledJob) (*BackupInfo, int, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, 0, errors.New("connection refused")
+		}
+		return &BackupInfo{Filename: "app_20240101_020000_scheduled.sql.gz"}, 1, nil
+	}
+
+	s.runJob(context.Background(), s.Jobs()[0])
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+
+	state, err := ReadScheduleState(cfg.Backup.Schedule.StateFile)
+	if err != nil {
+		t.Fatalf("Failed to read state: %v", err)
+	}
+	st := state[DefaultJob]
+	if st.Running || st.LastSuccess.IsZero() || st.LastFailure.IsZero() || st.ConsecutiveFailures != 0 ||
+		st.LastError != "connection refused" || st.LastBackup != "app_20240101_020000_scheduled.sql.gz" || st.Removed != 1 {
+		t.Errorf("Unexpected state after recovery: %+v", st)
+	}
+
+	s.run = func(job ScheduledJob) (*BackupInfo, int, error) {
+		return nil, 0, errors.New("disk full")
+	}
+	s.runJob(context.Background(), s.Jobs()[0])
+	if st := s.State()[DefaultJob]; st.ConsecutiveFailures != 3 {
+		t.Errorf("Expected 3 consecutive failures once retries are exhausted, got %d", st.ConsecutiveFailures)
+	}
+
+	if !strings.Contains(log.String(), "retrying in") {
+		t.Errorf("Expected retries to be logged, got:\n%s", log.String())
+	}
+}
+
+func TestSchedulerStopsOnCancel(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Backup.Schedule = config.ScheduleConfig{
+		Cron:      "@yearly",
+		StateFile: filepath.Join(t.TempDir(), "state.json"),
+	}
+
+	s, err := NewScheduler(cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed to create scheduler: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Scheduler did not stop after cancellation")
+	}
+
+	if st := s.State()[DefaultJob]; st.NextRun.IsZero() {
+		t.Error("Expected next run to be recorded")
+	}
+}
+
+func TestScheduledBackupRun(t *testing.T) {
+	bm := newTestManager(t, "sqlite3")
+	bm.config.Backup.RetentionDays = 0
+	bm.config.Backup.Retention.KeepLast = 1
+
+	if _, err := bm.db.Exec(`CREATE TABLE items (id INTEGER PRIMARY KEY)`); err != nil {
+		t.Fatalf("Failed to prepare schema: %v", err)
+	}
+	if _, err := bm.Create(BackupOptions{}); err != nil {
+		t.Fatalf("Failed to create backup: %v", err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+
+	info, removed, err := runScheduledBackup(ScheduledJob{Name: DefaultJob, Config: bm.config})
+	if err != nil {
+		t.Fatalf("Scheduled run failed: %v", err)
+	}
+	if !strings.Contains(info.Filename, "_scheduled.sql") || removed != 1 {
+		t.Errorf("Expected a labelled backup replacing the old one, got %s and %d removed", info.Filename, removed)
+	}
+
+	backups, err := bm.List()
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(backups) != 1 || backups[0].Filename != info.Filename {
+		t.Errorf("Expected only the scheduled backup to remain, got %+v", backups)
+	}
+}
+
+func TestScheduledBackupLeavesOtherHosts(t *testing.T) {
+	bm := newTestManager(t, "sqlite3")
+	bm.config.Backup.RetentionDays = 0
+	bm.config.Backup.Retention.KeepLast = 1
+
+	// Another environment with a database of the same name on another host
+	// backs up to the same storage, more often than its retention allows.
+	bm.config.Database.Host = "staging"
+	for i := 0; i < 2; i++ {
+		if _, err := bm.Create(BackupOptions{}); err != nil {
+			t.Fatalf("Failed to create backup: %v", err)
+		}
+	}
+
+	bm.config.Database.Host = "production"
+	_, removed, err := runScheduledBackup(ScheduledJob{Name: DefaultJob, Config: bm.config})
+	if err != nil {
+		t.Fatalf("Scheduled run failed: %v", err)
+	}
+	if removed != 0 {
+		t.Errorf("Expected nothing removed, got %d", removed)
+	}
+
+	backups, err := bm.List()
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(backups) != 3 {
+		t.Errorf("Expected the other host's backups to be kept, got %+v", backups)
+	}
+}
@@ -0,0 +1,181 @@
+package backup
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"migr8/pkg/database"
+)
+
+// resolveScope validates scope and, where the dialect can list tables,
+// expands it into the exact tables to dump, parents first. Naming a table
+// that does not exist is an error rather than an empty backup.
+func (bm *BackupManager) resolveScope(scope database.DumpScope) (database.DumpScope, error) {
+	if err := scope.Validate(); err != nil {
+		return scope, err
+	}
+	// Schema-only and data-only dumps of everything need no table list.
+	if len(scope.Tables) == 0 && len(scope.ExcludeTables) == 0 && len(scope.Where) == 0 {
+		return scope, nil
+	}
+
+	introspector, ok := bm.db.Dialect.(database.Introspector)
+	if !ok {
+		return scope, nil
+	}
+
+	all, err := introspector.Tables(bm.db)
+	if err != nil {
+		return scope, fmt.Errorf("failed to list tables: %w", err)
+	}
+	exists := make(map[string]bool, len(all))
+	for _, table := range all {
+		exists[table] = true
+	}
+
+	check := func(tables []string) error {
+		for _, table := range tables {
+			if !exists[table] {
+				return fmt.Errorf("table %s does not exist", table)
+			}
+		}
+		return nil
+	}
+	if err := check(scope.Tables); err != nil {
+		return scope, err
+	}
+	if err := check(scope.ExcludeTables); err != nil {
+		return scope, err
+	}
+
+	selected := all
+	if len(scope.Tables) > 0 {
+		selected = scope.Tables
+	}
+	excluded := make(map[string]bool, len(scope.ExcludeTables))
+	for _, table := range scope.ExcludeTables {
+		excluded[table] = true
+	}
+
+	var tables []string
+	for _, table := range selected {
+		if !excluded[table] {
+			tables = append(tables, table)
+		}
+	}
+	if len(tables) == 0 {
+		return scope, fmt.Errorf("backup scope selects no tables")
+	}
+
+	for table := range scope.Where {
+		if !exists[table] {
+			return scope, fmt.Errorf("filter given for table %s, which does not exist", table)
+		}
+		if excluded[table] || !contains(tables, table) {
+			return scope, fmt.Errorf("filter given for table %s, which is not part of the backup", table)
+		}
+	}
+
+	foreignKeys, err := introspector.ForeignKeys(bm.db)
+	if err != nil {
+		return scope, fmt.Errorf("failed to list foreign keys: %w", err)
+	}
+	ordered, cyclic := database.SortTablesByDependency(tables, foreignKeys)
+
+	resolved := scope
+	resolved.Tables = append(ordered, cyclic...)
+	resolved.ExcludeTables = nil
+	return resolved, nil
+}
+
+// dumpCommands builds the external tool invocations for scope. Dump tools
+// apply a row filter to every table they dump, so each filtered table gets
+// a run of its own after the unfiltered ones.
+func (bm *BackupManager) dumpCommands(scope database.DumpScope) ([]*exec.Cmd, error) {
+	if len(scope.Where) == 0 || len(scope.Tables) == 0 {
+		cmd, err := bm.db.Dialect.DumpCommand(bm.config.Database, scope)
+		if err != nil {
+			return nil, err
+		}
+		return []*exec.Cmd{cmd}, nil
+	}
+
+	var cmds []*exec.Cmd
+
+	unfiltered := scope
+	unfiltered.Tables = nil
+	unfiltered.Where = nil
+	for _, table := range scope.Tables {
+		if _, ok := scope.Where[table]; !ok {
+			unfiltered.Tables = append(unfiltered.Tables, table)
+		}
+	}
+	if len(unfiltered.Tables) > 0 {
+		cmd, err := bm.db.Dialect.DumpCommand(bm.config.Database, unfiltered)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
+
+	filtered := make([]string, 0, len(scope.Where))
+	for table := range scope.Where {
+		filtered = append(filtered, table)
+	}
+	sort.Strings(filtered)
+
+	for _, table := range filtered {
+		single := scope
+		single.Tables = []string{table}
+		single.Where = map[string]string{table: scope.Where[table]}
+		cmd, err := bm.db.Dialect.DumpCommand(bm.config.Database, single)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
+
+	return cmds, nil
+}
+
+// DescribeScope summarises what a backup contains for messages; a nil
+// scope is a full backup.
+func DescribeScope(scope *database.DumpScope) string {
+	if scope == nil {
+		return "full"
+	}
+
+	var parts []string
+	switch {
+	case scope.SchemaOnly:
+		parts = append(parts, "schema only")
+	case scope.DataOnly:
+		parts = append(parts, "data only")
+	}
+	if len(scope.Tables) > 0 {
+		parts = append(parts, "tables "+strings.Join(scope.Tables, ", "))
+	}
+	if len(scope.ExcludeTables) > 0 {
+		parts = append(parts, "excluding "+strings.Join(scope.ExcludeTables, ", "))
+	}
+	if len(scope.Where) > 0 {
+		filtered := make([]string, 0, len(scope.Where))
+		for table := range scope.Where {
+			filtered = append(filtered, table)
+		}
+		sort.Strings(filtered)
+		parts = append(parts, "rows filtered in "+strings.Join(filtered, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package backup
+
+import (
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"migr8/pkg/database"
+)
+
+func prepareScopeSchema(t *testing.T, bm *BackupManager) {
+	t.Helper()
+
+	for _, stmt := range []string{
+		`CREATE TABLE settings (key TEXT PRIMARY KEY, value TEXT)`,
+		`CREATE TABLE orders (id INTEGER PRIMARY KEY, total INTEGER)`,
+		`CREATE TABLE audit (id INTEGER PRIMARY KEY, message TEXT)`,
+		`INSERT INTO settings (key, value) VALUES ('theme', 'dark'), ('lang', 'en')`,
+		`INSERT INTO orders (id, total) VALUES (1, 5), (2, 50), (3, 500)`,
+		`INSERT INTO audit (id, message) VALUES (1, 'boot')`,
+	} {
+		if _, err := bm.db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to prepare schema: %v", err)
+		}
+	}
+}
+
+func readBackup(t *testing.T, bm *BackupManager, name string) string {
+	t.Helper()
+
+	reader, err := openBackup(bm.storage, name, bm.config.Backup.Encryption)
+	if err != nil {
+		t.Fatalf("Failed to open backup: %v", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to read backup: %v", err)
+	}
+	return string(data)
+}
+
+func TestScopedNativeBackup(t *testing.T) {
+	bm := newTestManager(t, "sqlite3")
+	prepareScopeSchema(t, bm)
+
+	scope := database.DumpScope{
+		ExcludeTables: []string{"audit"},
+		Where:         map[string]string{"orders": "total >= 50"},
+	}
+	info, err := bm.Create(BackupOptions{Scope: scope})
+	if err != nil {
+		t.Fatalf("Failed to create backup: %v", err)
+	}
+
+	manifest, err := ReadManifest(info.Path)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if manifest.Scope == nil || strings.Join(manifest.Scope.Tables, ",") != "orders,settings" ||
+		manifest.Scope.Where["orders"] != "total >= 50" {
+		t.Errorf("Expected resolved scope in manifest, got %+v", manifest.Scope)
+	}
+
+	dump := readBackup(t, bm, info.Filename)
+	if strings.Contains(dump, "audit") {
+		t.Error("Expected excluded table to be left out of the dump")
+	}
+
+	for _, stmt := range []string{
+		`DELETE FROM orders`,
+		`UPDATE settings SET value = 'light'`,
+		`INSERT INTO audit (id, message) VALUES (2, 'after backup')`,
+	} {
+		if _, err := bm.db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to modify data: %v", err)
+		}
+	}
+
+	plan, err := bm.PlanRestore(info.Filename)
+	if err != nil {
+		t.Fatalf("Failed to plan restore: %v", err)
+	}
+	if plan.CanSwap {
+		t.Error("Expected partial backup not to be swapped in")
+	}
+	if _, err := bm.Restore(info.Filename, RestoreOptions{Swap: true}); err == nil {
+		t.Error("Expected swap restore of a partial backup to fail")
+	}
+
+	if _, err := bm.Restore(info.Filename, RestoreOptions{}); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	if n := countRows(t, bm, "orders"); n != 2 {
+		t.Errorf("Expected 2 filtered orders, got %d", n)
+	}
+	if n := countRows(t, bm, "audit"); n != 2 {
+		t.Errorf("Expected excluded table to be left alone, got %d rows", n)
+	}
+
+	var theme string
+	if err := bm.db.QueryRow(`SELECT value FROM settings WHERE key = 'theme'`).Scan(&theme); err != nil || theme != "dark" {
+		t.Errorf("Expected restored setting dark, got %q (%v)", theme, err)
+	}
+}
+
+func TestScopedNativeBackupModes(t *testing.T) {
+	bm := newTestManager(t, "sqlite3")
+	prepareScopeSchema(t, bm)
+
+	info, err := bm.Create(BackupOptions{Scope: database.DumpScope{Tables: []string{"settings"}, SchemaOnly: true}})
+	if err != nil {
+		t.Fatalf("Failed to create schema-only backup: %v", err)
+	}
+	dump := readBackup(t, bm, info.Filename)
+	if !strings.Contains(dump, "CREATE TABLE") || strings.Contains(dump, "INSERT") {
+		t.Errorf("Expected schema without data, got:\n%s", dump)
+	}
+
+	info, err = bm.Create(BackupOptions{Scope: database.DumpScope{DataOnly: true}})
+	if err != nil {
+		t.Fatalf("Failed to create data-only backup: %v", err)
+	}
+	dump = readBackup(t, bm, info.Filename)
+	if strings.Contains(dump, "CREATE TABLE") || !strings.Contains(dump, "INSERT") {
+		t.Errorf("Expected data without schema, got:\n%s", dump)
+	}
+
+	invalid := []database.DumpScope{
+		{SchemaOnly: true, DataOnly: true},
+		{Tables: []string{"missing"}},
+		{Tables: []string{"settings"}, Where: map[string]string{"orders": "id = 1"}},
+		{Tables: []string{"settings"}, ExcludeTables: []string{"settings"}},
+	}
+	for _, scope := range invalid {
+		if _, err := bm.Create(BackupOptions{Scope: scope}); err == nil {
+			t.Errorf("Expected scope %+v to be rejected", scope)
+		}
+	}
+}
+
+func TestScopedExternalSQLiteBackup(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 shell not installed")
+	}
+
+	bm := newTestManager(t, "sqlite3")
+	bm.config.Backup.Engine = "external"
+	prepareScopeSchema(t, bm)
+
+	info, err := bm.Create(BackupOptions{Scope: database.DumpScope{
+		ExcludeTables: []string{"audit"},
+		Where:         map[string]string{"orders": "total >= 50"},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to create backup: %v", err)
+	}
+
+	dump := readBackup(t, bm, info.Filename)
+	for _, want := range []string{"CREATE TABLE settings", "'theme','dark'", "CREATE TABLE orders", "VALUES(2,50)", "VALUES(3,500)"} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("Expected %q in dump:\n%s", want, dump)
+		}
+	}
+	for _, unwanted := range []string{"audit", "VALUES(1,5)"} {
+		if strings.Contains(dump, unwanted) {
+			t.Errorf("Did not expect %q in dump:\n%s", unwanted, dump)
+		}
+	}
+}
+
+func TestExternalBackupReportsStderr(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 shell not installed")
+	}
+
+	bm := newTestManager(t, "sqlite3")
+	bm.config.Backup.Engine = "external"
+	prepareScopeSchema(t, bm)
+
+	_, err := bm.Create(BackupOptions{Scope: database.DumpScope{
+		Tables: []string{"orders"},
+		Where:  map[string]string{"orders": "no_such_column > 1"},
+	}})
+	if err == nil || !strings.Contains(err.Error(), "no_such_column") {
+		t.Errorf("Expected the dump tool's error output, got %v", err)
+	}
+
+	if backups, _ := bm.List(); len(backups) != 0 {
+		t.Errorf("Expected nothing stored for a failed backup, got %+v", backups)
+	}
+}
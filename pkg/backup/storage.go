@@ -0,0 +1,138 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"migr8/pkg/config"
+)
+
+// Storage is where backups and their manifests are kept. Objects are
+// addressed by file name and streamed in both directions, so a backup
+// never has to be staged on local disk before it is uploaded.
+//
+// Get returns an error wrapping os.ErrNotExist for unknown names.
+type Storage interface {
+	Put(name string, r io.Reader) error
+	Get(name string) (io.ReadCloser, error)
+	List() ([]Object, error)
+	Delete(name string) error
+
+	// Location describes where name is stored, for display.
+	Location(name string) string
+
+	Close() error
+}
+
+// Object describes a stored file.
+type Object struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// NewStorage returns the storage backend selected by cfg.Storage.Type.
+func NewStorage(cfg config.BackupConfig) (Storage, error) {
+	switch cfg.Storage.Type {
+	case "local", "":
+		return newLocalStorage(cfg.Directory), nil
+	case "s3":
+		return newS3Storage(cfg.Storage.S3)
+	case "sftp":
+		return newSFTPStorage(cfg.Storage.SFTP)
+	default:
+		return nil, fmt.Errorf("unknown backup storage: %s", cfg.Storage.Type)
+	}
+}
+
+// localStorage keeps backups in a directory on the local file system.
+type localStorage struct {
+	dir string
+}
+
+func newLocalStorage(dir string) *localStorage {
+	return &localStorage{dir: dir}
+}
+
+// Put writes to a hidden temporary file and renames it into place, so an
+// interrupted backup never shows up in List.
+func (ls *localStorage) Put(name string, r io.Reader) error {
+	if err := os.MkdirAll(ls.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(ls.dir, "."+name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write backup file: %w", err)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write backup file: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), ls.Location(name))
+}
+
+func (ls *localStorage) Get(name string) (io.ReadCloser, error) {
+	return os.Open(ls.Location(name))
+}
+
+func (ls *localStorage) List() ([]Object, error) {
+	entries, err := os.ReadDir(ls.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backup files: %w", err)
+	}
+
+	var objects []Object
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		objects = append(objects, Object{
+			Name:    entry.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	return objects, nil
+}
+
+func (ls *localStorage) Delete(name string) error {
+	return os.Remove(ls.Location(name))
+}
+
+func (ls *localStorage) Location(name string) string {
+	return filepath.Join(ls.dir, name)
+}
+
+func (ls *localStorage) Close() error {
+	return nil
+}
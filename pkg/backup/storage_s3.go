@@ -0,0 +1,140 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"migr8/pkg/config"
+)
+
+// s3PartSize bounds the memory used while streaming a backup of unknown
+// length: one part is buffered at a time, and S3 allows at most 10,000
+// parts, which puts the largest backup at about 160 GB.
+const s3PartSize = 16 << 20
+
+// s3Storage keeps backups in an S3-compatible bucket (AWS S3, MinIO, R2,
+// Spaces, ...), optionally below a key prefix.
+type s3Storage struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func newS3Storage(cfg config.S3Config) (*s3Storage, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 backup storage requires a bucket")
+	}
+
+	endpoint := cfg.Endpoint
+	secure := !cfg.Insecure
+	if strings.HasPrefix(endpoint, "http://") {
+		secure = false
+	}
+	endpoint = strings.TrimPrefix(strings.TrimPrefix(endpoint, "https://"), "http://")
+
+	creds := credentials.NewEnvAWS()
+	if cfg.AccessKeyID != "" {
+		creds = credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, "")
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: secure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %w", err)
+	}
+
+	return &s3Storage{
+		client: client,
+		bucket: cfg.Bucket,
+		prefix: strings.Trim(cfg.Prefix, "/"),
+	}, nil
+}
+
+func (s *s3Storage) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+func (s *s3Storage) Put(name string, r io.Reader) error {
+	_, err := s.client.PutObject(context.Background(), s.bucket, s.key(name), r, -1, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+		PartSize:    s3PartSize,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", s.Location(name), err)
+	}
+	return nil
+}
+
+func (s *s3Storage) Get(name string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(context.Background(), s.bucket, s.key(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s.wrapError(name, err)
+	}
+
+	// GetObject is lazy; stat it so a missing key is reported here rather
+	// than on the first read.
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, s.wrapError(name, err)
+	}
+	return object, nil
+}
+
+func (s *s3Storage) List() ([]Object, error) {
+	prefix := ""
+	if s.prefix != "" {
+		prefix = s.prefix + "/"
+	}
+
+	var objects []Object
+	for info := range s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if info.Err != nil {
+			return nil, fmt.Errorf("failed to list s3://%s/%s: %w", s.bucket, prefix, info.Err)
+		}
+		name := strings.TrimPrefix(info.Key, prefix)
+		if name == "" || strings.HasSuffix(name, "/") {
+			continue
+		}
+		objects = append(objects, Object{
+			Name:    name,
+			Size:    info.Size,
+			ModTime: info.LastModified,
+		})
+	}
+	return objects, nil
+}
+
+func (s *s3Storage) Delete(name string) error {
+	if err := s.client.RemoveObject(context.Background(), s.bucket, s.key(name), minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", s.Location(name), err)
+	}
+	return nil
+}
+
+func (s *s3Storage) Location(name string) string {
+	return "s3://" + path.Join(s.bucket, s.key(name))
+}
+
+func (s *s3Storage) Close() error {
+	return nil
+}
+
+func (s *s3Storage) wrapError(name string, err error) error {
+	if code := minio.ToErrorResponse(err).Code; code == "NoSuchKey" || code == "NoSuchBucket" {
+		return fmt.Errorf("%s: %w", s.Location(name), os.ErrNotExist)
+	}
+	return fmt.Errorf("failed to download %s: %w", s.Location(name), err)
+}
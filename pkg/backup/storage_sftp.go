@@ -0,0 +1,176 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"migr8/pkg/config"
+)
+
+// sftpStorage keeps backups in a directory on an SSH server.
+type sftpStorage struct {
+	conn     *ssh.Client
+	client   *sftp.Client
+	dir      string
+	location string
+}
+
+func newSFTPStorage(cfg config.SFTPConfig) (*sftpStorage, error) {
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("sftp backup storage requires a host")
+	}
+
+	var auth []ssh.AuthMethod
+	if cfg.KeyFile != "" {
+		key, err := os.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sftp key file: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse sftp key file %s: %w", cfg.KeyFile, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if cfg.Password != "" {
+		auth = append(auth, ssh.Password(cfg.Password))
+	}
+
+	knownHostsFile := cfg.KnownHostsFile
+	if knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate known_hosts: %w", err)
+		}
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known hosts: %w", err)
+	}
+
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            cfg.Username,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start sftp session: %w", err)
+	}
+
+	storage := newSFTPStorageWithClient(client, cfg.Directory, fmt.Sprintf("sftp://%s@%s", cfg.Username, addr))
+	storage.conn = conn
+	return storage, nil
+}
+
+func newSFTPStorageWithClient(client *sftp.Client, dir, location string) *sftpStorage {
+	if dir == "" {
+		dir = "."
+	}
+	return &sftpStorage{client: client, dir: dir, location: location}
+}
+
+func (s *sftpStorage) remotePath(name string) string {
+	return path.Join(s.dir, name)
+}
+
+// Put uploads to a hidden temporary file and renames it into place, like
+// the local backend.
+func (s *sftpStorage) Put(name string, r io.Reader) error {
+	if err := s.client.MkdirAll(s.dir); err != nil {
+		return fmt.Errorf("failed to create %s: %w", s.Location(""), err)
+	}
+
+	tmp := s.remotePath("." + name + ".tmp")
+	file, err := s.client.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", s.Location(name), err)
+	}
+
+	if _, err := file.ReadFrom(r); err != nil {
+		file.Close()
+		s.client.Remove(tmp)
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		s.client.Remove(tmp)
+		return fmt.Errorf("failed to upload %s: %w", s.Location(name), err)
+	}
+
+	if err := s.client.PosixRename(tmp, s.remotePath(name)); err != nil {
+		s.client.Remove(tmp)
+		return fmt.Errorf("failed to upload %s: %w", s.Location(name), err)
+	}
+	return nil
+}
+
+func (s *sftpStorage) Get(name string) (io.ReadCloser, error) {
+	file, err := s.client.Open(s.remotePath(name))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Location(name), err)
+	}
+	return file, nil
+}
+
+func (s *sftpStorage) List() ([]Object, error) {
+	entries, err := s.client.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", s.Location(""), err)
+	}
+
+	var objects []Object
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		objects = append(objects, Object{
+			Name:    entry.Name(),
+			Size:    entry.Size(),
+			ModTime: entry.ModTime(),
+		})
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	return objects, nil
+}
+
+func (s *sftpStorage) Delete(name string) error {
+	if err := s.client.Remove(s.remotePath(name)); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", s.Location(name), err)
+	}
+	return nil
+}
+
+func (s *sftpStorage) Location(name string) string {
+	return s.location + path.Join("/", s.dir, name)
+}
+
+func (s *sftpStorage) Close() error {
+	err := s.client.Close()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	return err
+}
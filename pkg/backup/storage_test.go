@@ -0,0 +1,316 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"migr8/pkg/config"
+)
+
+// fakeS3 implements the handful of S3 calls the s3 backend makes, against
+// an in-memory bucket.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	t.Helper()
+
+	fake := &fakeS3{
+		objects: make(map[string][]byte),
+		uploads: make(map[string]map[int][]byte),
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, query.Get("prefix"))
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[id] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", bucket, key, id)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		part, _ := strconv.Atoi(query.Get("partNumber"))
+		f.uploads[query.Get("uploadId")][part] = readS3Body(r)
+		w.Header().Set("ETag", fmt.Sprintf(`"part-%d"`, part))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		parts := f.uploads[query.Get("uploadId")]
+		numbers := make([]int, 0, len(parts))
+		for n := range parts {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+		var data []byte
+		for _, n := range numbers {
+			data = append(data, parts[n]...)
+		}
+		f.objects[key] = data
+		delete(f.uploads, query.Get("uploadId"))
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>"done"</ETag></CompleteMultipartUploadResult>`, bucket, key)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		f.objects[key] = readS3Body(r)
+		w.Header().Set("ETag", `"object"`)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				fmt.Fprintf(w, "<Error><Code>NoSuchKey</Code><Key>%s</Key></Error>", key)
+			}
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", `"object"`)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "<ListBucketResult><Prefix>%s</Prefix><KeyCount>%d</KeyCount><IsTruncated>false</IsTruncated>", prefix, len(keys))
+	for _, key := range keys {
+		fmt.Fprintf(w, "<Contents><Key>%s</Key><LastModified>%s</LastModified><Size>%d</Size></Contents>",
+			key, time.Now().UTC().Format("2006-01-02T15:04:05.000Z"), len(f.objects[key]))
+	}
+	fmt.Fprintf(w, "</ListBucketResult>")
+}
+
+// readS3Body returns the payload of a request, decoding the aws-chunked
+// framing the client uses for signed uploads over plain HTTP.
+func readS3Body(r *http.Request) []byte {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		data, _ := io.ReadAll(r.Body)
+		return data
+	}
+
+	var data []byte
+	br := bufio.NewReader(r.Body)
+	for {
+		header, err := br.ReadString('\n')
+		if err != nil {
+			return data
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(header), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil || size == 0 {
+			return data
+		}
+		chunk := make([]byte, size)
+		io.ReadFull(br, chunk)
+		data = append(data, chunk...)
+		br.ReadString('\n')
+	}
+}
+
+func newTestS3Storage(t *testing.T) *s3Storage {
+	t.Helper()
+
+	_, server := newFakeS3(t)
+	storage, err := newS3Storage(config.S3Config{
+		Endpoint:        server.URL,
+		Region:          "us-east-1",
+		Bucket:          "backups",
+		Prefix:          "prod/",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create s3 storage: %v", err)
+	}
+	return storage
+}
+
+func newTestSFTPStorage(t *testing.T) *sftpStorage {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	server, err := sftp.NewServer(serverConn)
+	if err != nil {
+		t.Fatalf("Failed to start sftp server: %v", err)
+	}
+	go server.Serve()
+
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		t.Fatalf("Failed to start sftp client: %v", err)
+	}
+
+	storage := newSFTPStorageWithClient(client, filepath.Join(t.TempDir(), "remote"), "sftp://test@localhost")
+	t.Cleanup(func() {
+		storage.Close()
+		server.Close()
+	})
+	return storage
+}
+
+func TestStorageBackends(t *testing.T) {
+	backends := map[string]func(t *testing.T) Storage{
+		"local": func(t *testing.T) Storage { return newLocalStorage(filepath.Join(t.TempDir(), "backups")) },
+		"s3":    func(t *testing.T) Storage { return newTestS3Storage(t) },
+		"sftp":  func(t *testing.T) Storage { return newTestSFTPStorage(t) },
+	}
+
+	for name, newStorage := range backends {
+		t.Run(name, func(t *testing.T) {
+			storage := newStorage(t)
+
+			objects, err := storage.List()
+			if err != nil || len(objects) != 0 {
+				t.Fatalf("Expected empty storage, got %v (%v)", objects, err)
+			}
+
+			if _, err := storage.Get("missing.sql"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("Expected os.ErrNotExist for missing object, got %v", err)
+			}
+
+			payload := bytes.Repeat([]byte("INSERT INTO t VALUES (1);\n"), 1000)
+			if err := storage.Put("b.sql", bytes.NewReader(payload)); err != nil {
+				t.Fatalf("Failed to put object: %v", err)
+			}
+			if err := storage.Put("a.sql.json", strings.NewReader("{}")); err != nil {
+				t.Fatalf("Failed to put object: %v", err)
+			}
+
+			failed := io.MultiReader(strings.NewReader("partial"), errReader{errors.New("dump failed")})
+			if err := storage.Put("c.sql", failed); err == nil {
+				t.Error("Expected failing reader to fail the upload")
+			}
+
+			objects, err = storage.List()
+			if err != nil {
+				t.Fatalf("Failed to list objects: %v", err)
+			}
+			if len(objects) != 2 || objects[0].Name != "a.sql.json" || objects[1].Name != "b.sql" {
+				t.Fatalf("Expected a.sql.json and b.sql, got %+v", objects)
+			}
+			if objects[1].Size != int64(len(payload)) {
+				t.Errorf("Expected size %d, got %d", len(payload), objects[1].Size)
+			}
+
+			reader, err := storage.Get("b.sql")
+			if err != nil {
+				t.Fatalf("Failed to get object: %v", err)
+			}
+			data, err := io.ReadAll(reader)
+			reader.Close()
+			if err != nil || !bytes.Equal(data, payload) {
+				t.Errorf("Expected payload to round-trip, got %d bytes (%v)", len(data), err)
+			}
+
+			if err := storage.Delete("b.sql"); err != nil {
+				t.Fatalf("Failed to delete object: %v", err)
+			}
+			if _, err := storage.Get("b.sql"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("Expected deleted object to be gone, got %v", err)
+			}
+
+			if !strings.HasSuffix(storage.Location("b.sql"), "b.sql") {
+				t.Errorf("Expected location to end in the object name, got %s", storage.Location("b.sql"))
+			}
+		})
+	}
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestBackupToRemoteStorage(t *testing.T) {
+	bm := newTestManager(t, "sqlite3")
+	bm.storage = newTestS3Storage(t)
+
+	if _, err := bm.db.Exec(`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatalf("Failed to prepare schema: %v", err)
+	}
+	if _, err := bm.db.Exec(`INSERT INTO items (id, name) VALUES (1, 'remote')`); err != nil {
+		t.Fatalf("Failed to insert data: %v", err)
+	}
+
+	info, err := bm.Create(BackupOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create backup: %v", err)
+	}
+	if !strings.HasPrefix(info.Path, "s3://backups/prod/") {
+		t.Errorf("Expected s3 location, got %s", info.Path)
+	}
+	if _, err := os.Stat(bm.config.Backup.Directory); !os.IsNotExist(err) {
+		t.Error("Expected nothing to be written to the local backup directory")
+	}
+
+	backups, err := bm.List()
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(backups) != 1 || !backups[0].HasManifest || backups[0].SHA256 != info.SHA256 {
+		t.Fatalf("Expected the remote backup with its manifest, got %+v", backups)
+	}
+
+	if _, checked, err := VerifyStored(bm.storage, info.Filename, bm.config.Backup.Encryption); err != nil || !checked {
+		t.Errorf("Expected remote backup to verify, checked=%t err=%v", checked, err)
+	}
+
+	if _, err := bm.db.Exec(`DELETE FROM items`); err != nil {
+		t.Fatalf("Failed to modify data: %v", err)
+	}
+	if _, err := bm.Restore(info.Filename, RestoreOptions{}); err != nil {
+		t.Fatalf("Failed to restore by name: %v", err)
+	}
+
+	var name string
+	if err := bm.db.QueryRow(`SELECT name FROM items WHERE id = 1`).Scan(&name); err != nil {
+		t.Fatalf("Failed to query restored data: %v", err)
+	}
+	if name != "remote" {
+		t.Errorf("Expected restored name remote, got %s", name)
+	}
+
+	if _, err := bm.Restore("nope_20200101_000000.sql.gz", RestoreOptions{}); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected not found error, got %v", err)
+	}
+}
@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"net/url"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -16,27 +18,139 @@ type DatabaseConfig struct {
 	SSLMode  string `mapstructure:"sslmode" yaml:"sslmode"`
 }
 
+// MigrationConfig controls migrations. With BackupBeforeUp, `migrate up`
+// takes a backup before applying anything, which `migrate restore-point`
+// can return to.
 type MigrationConfig struct {
-	Directory string `mapstructure:"directory" yaml:"directory"`
-	Table     string `mapstructure:"table" yaml:"table"`
+	Directory      string `mapstructure:"directory" yaml:"directory"`
+	Table          string `mapstructure:"table" yaml:"table"`
+	BackupBeforeUp bool   `mapstructure:"backup_before_up" yaml:"backup_before_up"`
 }
 
+// BackupConfig controls backups. CompressionAlgorithm (gzip, zstd or none)
+// and CompressionLevel only apply when Compression is on; a level of 0 uses
+// the algorithm's default.
 type BackupConfig struct {
-	Directory     string `mapstructure:"directory" yaml:"directory"`
-	Compression   bool   `mapstructure:"compression" yaml:"compression"`
-	RetentionDays int    `mapstructure:"retention_days" yaml:"retention_days"`
+	Directory            string           `mapstructure:"directory" yaml:"directory"`
+	Compression          bool             `mapstructure:"compression" yaml:"compression"`
+	CompressionAlgorithm string           `mapstructure:"compression_algorithm" yaml:"compression_algorithm"`
+	CompressionLevel     int              `mapstructure:"compression_level" yaml:"compression_level"`
+	RetentionDays        int              `mapstructure:"retention_days" yaml:"retention_days"`
+	Engine               string           `mapstructure:"engine" yaml:"engine"`
+	BatchSize            int              `mapstructure:"batch_size" yaml:"batch_size"`
+	Encryption           EncryptionConfig `mapstructure:"encryption" yaml:"encryption"`
+	Storage              StorageConfig    `mapstructure:"storage" yaml:"storage"`
+	Retention            RetentionConfig  `mapstructure:"retention" yaml:"retention"`
+	Schedule             ScheduleConfig   `mapstructure:"schedule" yaml:"schedule"`
 }
 
+// ScheduleConfig drives `backup schedule`. Cron is the schedule for the
+// top-level database; environments add their own with BackupSchedule. A
+// failed run is retried Retries times, waiting RetryDelay before the first
+// retry and twice as long before each further one.
+type ScheduleConfig struct {
+	Cron       string        `mapstructure:"cron" yaml:"cron"`
+	Retries    int           `mapstructure:"retries" yaml:"retries"`
+	RetryDelay time.Duration `mapstructure:"retry_delay" yaml:"retry_delay"`
+	StateFile  string        `mapstructure:"state_file" yaml:"state_file"`
+}
+
+// RetentionConfig adds grandfather-father-son rules on top of
+// RetentionDays: a backup is kept if any rule selects it, and the newest
+// MinKeep backups of every database are always kept.
+type RetentionConfig struct {
+	KeepLast    int `mapstructure:"keep_last" yaml:"keep_last"`
+	KeepDaily   int `mapstructure:"keep_daily" yaml:"keep_daily"`
+	KeepWeekly  int `mapstructure:"keep_weekly" yaml:"keep_weekly"`
+	KeepMonthly int `mapstructure:"keep_monthly" yaml:"keep_monthly"`
+	KeepYearly  int `mapstructure:"keep_yearly" yaml:"keep_yearly"`
+	MinKeep     int `mapstructure:"min_keep" yaml:"min_keep"`
+}
+
+// EncryptionConfig controls age encryption of backups. Public-key
+// recipients (from Recipients or IdentityFile) take precedence over the
+// passphrase, since age does not allow mixing the two.
+type EncryptionConfig struct {
+	Enabled       bool     `mapstructure:"enabled" yaml:"enabled"`
+	Recipients    []string `mapstructure:"recipients" yaml:"recipients"`
+	IdentityFile  string   `mapstructure:"identity_file" yaml:"identity_file"`
+	PassphraseEnv string   `mapstructure:"passphrase_env" yaml:"passphrase_env"`
+}
+
+// StorageConfig selects where backups are kept. The local type stores them
+// in BackupConfig.Directory.
+type StorageConfig struct {
+	Type string     `mapstructure:"type" yaml:"type"`
+	S3   S3Config   `mapstructure:"s3" yaml:"s3"`
+	SFTP SFTPConfig `mapstructure:"sftp" yaml:"sftp"`
+}
+
+// S3Config describes an S3-compatible bucket. Empty credentials fall back to
+// the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables.
+type S3Config struct {
+	Endpoint        string `mapstructure:"endpoint" yaml:"endpoint"`
+	Region          string `mapstructure:"region" yaml:"region"`
+	Bucket          string `mapstructure:"bucket" yaml:"bucket"`
+	Prefix          string `mapstructure:"prefix" yaml:"prefix"`
+	AccessKeyID     string `mapstructure:"access_key_id" yaml:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key" yaml:"secret_access_key"`
+	Insecure        bool   `mapstructure:"insecure" yaml:"insecure"`
+}
+
+// SFTPConfig describes a directory on an SSH server. Host keys are checked
+// against KnownHostsFile.
+type SFTPConfig struct {
+	Host           string `mapstructure:"host" yaml:"host"`
+	Port           int    `mapstructure:"port" yaml:"port"`
+	Username       string `mapstructure:"username" yaml:"username"`
+	Password       string `mapstructure:"password" yaml:"password"`
+	KeyFile        string `mapstructure:"key_file" yaml:"key_file"`
+	KnownHostsFile string `mapstructure:"known_hosts_file" yaml:"known_hosts_file"`
+	Directory      string `mapstructure:"directory" yaml:"directory"`
+}
+
+// SeedConfig controls seeding. Table records which seed files have run,
+// and with what content, in the same layout as the migrations table.
+// BatchSize is the number of rows sent per INSERT when loading CSV and
+// generated rows. Environment selects the seed set, a subdirectory of
+// Directory, to run on top of the common one; only reference seeds run
+// against the environments in ProtectedEnvironments.
 type SeedConfig struct {
-	Directory string `mapstructure:"directory" yaml:"directory"`
+	Directory             string   `mapstructure:"directory" yaml:"directory"`
+	Table                 string   `mapstructure:"table" yaml:"table"`
+	BatchSize             int      `mapstructure:"batch_size" yaml:"batch_size"`
+	Environment           string   `mapstructure:"environment" yaml:"environment,omitempty"`
+	ProtectedEnvironments []string `mapstructure:"protected_environments" yaml:"protected_environments,omitempty"`
+}
+
+// AnonymizeConfig declares how personal data is rewritten when it leaves
+// the database through `seed export --anonymize` or `backup create
+// --anonymize`. Tables maps table names to a rule per column: hash,
+// fake_name, fake_email, nullify or preserve_format. The rules are keyed
+// with a salt read from the SaltEnv environment variable, so the same value
+// always gets the same replacement and anonymized keys still join.
+type AnonymizeConfig struct {
+	SaltEnv string                       `mapstructure:"salt_env" yaml:"salt_env"`
+	Tables  map[string]map[string]string `mapstructure:"tables" yaml:"tables,omitempty"`
+}
+
+// EnvironmentConfig names another deployment of the same schema, such as
+// staging. Database fields left empty are inherited from the top-level
+// database section.
+type EnvironmentConfig struct {
+	Database DatabaseConfig `mapstructure:"database" yaml:"database"`
+	// BackupSchedule is a cron expression for `backup schedule`.
+	BackupSchedule string `mapstructure:"backup_schedule" yaml:"backup_schedule,omitempty"`
 }
 
 type Config struct {
-	Database  DatabaseConfig  `mapstructure:"database" yaml:"database"`
-	Migration MigrationConfig `mapstructure:"migration" yaml:"migration"`
-	Backup    BackupConfig    `mapstructure:"backup" yaml:"backup"`
-	Seed      SeedConfig      `mapstructure:"seed" yaml:"seed"`
-	Verbose   bool            `mapstructure:"verbose" yaml:"verbose"`
+	Database     DatabaseConfig               `mapstructure:"database" yaml:"database"`
+	Migration    MigrationConfig              `mapstructure:"migration" yaml:"migration"`
+	Backup       BackupConfig                 `mapstructure:"backup" yaml:"backup"`
+	Seed         SeedConfig                   `mapstructure:"seed" yaml:"seed"`
+	Anonymize    AnonymizeConfig              `mapstructure:"anonymize" yaml:"anonymize"`
+	Environments map[string]EnvironmentConfig `mapstructure:"environments" yaml:"environments,omitempty"`
+	Verbose      bool                         `mapstructure:"verbose" yaml:"verbose"`
 }
 
 func Load() (*Config, error) {
@@ -63,14 +177,7 @@ func setDefaults(cfg *Config) error {
 	}
 	
 	if cfg.Database.Port == 0 {
-		switch cfg.Database.Driver {
-		case "postgres":
-			cfg.Database.Port = 5432
-		case "mysql":
-			cfg.Database.Port = 3306
-		case "sqlite3":
-			cfg.Database.Port = 0
-		}
+		cfg.Database.Port = defaultPort(cfg.Database.Driver)
 	}
 	
 	if cfg.Migration.Directory == "" {
@@ -88,17 +195,125 @@ func setDefaults(cfg *Config) error {
 	if cfg.Backup.RetentionDays == 0 {
 		cfg.Backup.RetentionDays = 30
 	}
+
+	if cfg.Backup.Retention.MinKeep == 0 {
+		cfg.Backup.Retention.MinKeep = 1
+	}
+
+	if cfg.Backup.CompressionAlgorithm == "" {
+		cfg.Backup.CompressionAlgorithm = "gzip"
+	}
+
+	if cfg.Backup.Engine == "" {
+		cfg.Backup.Engine = "external"
+	}
+
+	if cfg.Backup.BatchSize == 0 {
+		cfg.Backup.BatchSize = 500
+	}
+
+	if cfg.Backup.Encryption.PassphraseEnv == "" {
+		cfg.Backup.Encryption.PassphraseEnv = "MIGR8_BACKUP_PASSPHRASE"
+	}
+
+	if cfg.Backup.Storage.Type == "" {
+		cfg.Backup.Storage.Type = "local"
+	}
+
+	if cfg.Backup.Storage.S3.Endpoint == "" {
+		cfg.Backup.Storage.S3.Endpoint = "s3.amazonaws.com"
+	}
+
+	if cfg.Backup.Storage.SFTP.Port == 0 {
+		cfg.Backup.Storage.SFTP.Port = 22
+	}
+	
+	if cfg.Backup.Schedule.RetryDelay == 0 {
+		cfg.Backup.Schedule.RetryDelay = time.Minute
+	}
+
+	if cfg.Backup.Schedule.StateFile == "" {
+		cfg.Backup.Schedule.StateFile = ".migr8-schedule.json"
+	}
 	
 	if cfg.Seed.Directory == "" {
 		cfg.Seed.Directory = "./seeds"
 	}
 
+	if cfg.Seed.Table == "" {
+		cfg.Seed.Table = "seed_history"
+	}
+
+	if cfg.Seed.BatchSize <= 0 {
+		cfg.Seed.BatchSize = 1000
+	}
+
+	if cfg.Anonymize.SaltEnv == "" {
+		cfg.Anonymize.SaltEnv = "MIGR8_ANONYMIZE_SALT"
+	}
+
 	return nil
 }
 
+func defaultPort(driver string) int {
+	switch driver {
+	case "postgres":
+		return 5432
+	case "mysql":
+		return 3306
+	case "cockroach":
+		return 26257
+	case "sqlserver":
+		return 1433
+	default:
+		return 0
+	}
+}
+
+// ForEnvironment returns a copy of the config that targets the database of
+// the named environment.
+func (c *Config) ForEnvironment(name string) (*Config, error) {
+	env, ok := c.Environments[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown environment: %s", name)
+	}
+
+	merged := *c
+	merged.Database = c.Database.Merge(env.Database)
+	return &merged, nil
+}
+
+// Merge returns d with every non-empty field of override applied. Switching
+// drivers without a port resets the port to the new driver's default.
+func (d DatabaseConfig) Merge(override DatabaseConfig) DatabaseConfig {
+	if override.Driver != "" && override.Driver != d.Driver {
+		d.Driver = override.Driver
+		d.Port = defaultPort(override.Driver)
+	}
+	if override.Host != "" {
+		d.Host = override.Host
+	}
+	if override.Port != 0 {
+		d.Port = override.Port
+	}
+	if override.Database != "" {
+		d.Database = override.Database
+	}
+	if override.Username != "" {
+		d.Username = override.Username
+	}
+	if override.Password != "" {
+		d.Password = override.Password
+	}
+	if override.SSLMode != "" {
+		d.SSLMode = override.SSLMode
+	}
+	return d
+}
+
 func (c *Config) GetDSN() string {
 	switch c.Database.Driver {
-	case "postgres":
+	case "postgres", "cockroach":
 		return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
 			c.Database.Host, c.Database.Port, c.Database.Username, 
 			c.Database.Password, c.Database.Database, c.Database.SSLMode)
@@ -106,7 +321,20 @@ func (c *Config) GetDSN() string {
 		return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s",
 			c.Database.Username, c.Database.Password, 
 			c.Database.Host, c.Database.Port, c.Database.Database)
-	case "sqlite3":
+	case "sqlserver":
+		query := url.Values{}
+		query.Set("database", c.Database.Database)
+		if c.Database.SSLMode != "" {
+			query.Set("encrypt", c.Database.SSLMode)
+		}
+		dsn := url.URL{
+			Scheme:   "sqlserver",
+			User:     url.UserPassword(c.Database.Username, c.Database.Password),
+			Host:     fmt.Sprintf("%s:%d", c.Database.Host, c.Database.Port),
+			RawQuery: query.Encode(),
+		}
+		return dsn.String()
+	case "sqlite3", "sqlite":
 		return c.Database.Database
 	default:
 		return ""
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"migr8/pkg/config"
+)
+
+// DatabaseAdmin is implemented by dialects that can create and drop whole
+// databases. cfg.Database names the database to operate on; server-based
+// dialects connect to their maintenance database to do so.
+type DatabaseAdmin interface {
+	DatabaseExists(cfg config.DatabaseConfig) (bool, error)
+	CreateDatabase(cfg config.DatabaseConfig) error
+	DropDatabase(cfg config.DatabaseConfig) error
+}
+
+// DatabaseRenamer is implemented by dialects that can rename a database,
+// which lets a restore be prepared in a scratch database and swapped in.
+// Other sessions connected to the database may be disconnected.
+type DatabaseRenamer interface {
+	RenameDatabase(cfg config.DatabaseConfig, newName string) error
+}
+
+// EnsureDatabase creates the database described by cfg if it does not exist
+// yet and reports whether it did.
+func EnsureDatabase(cfg config.DatabaseConfig) (bool, error) {
+	dialect, err := GetDialect(cfg.Driver)
+	if err != nil {
+		return false, err
+	}
+
+	admin, ok := dialect.(DatabaseAdmin)
+	if !ok {
+		return false, fmt.Errorf("creating databases is not supported for driver %s", cfg.Driver)
+	}
+
+	exists, err := admin.DatabaseExists(cfg)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for database %s: %w", cfg.Database, err)
+	}
+	if exists {
+		return false, nil
+	}
+
+	if err := admin.CreateDatabase(cfg); err != nil {
+		return false, fmt.Errorf("failed to create database %s: %w", cfg.Database, err)
+	}
+	return true, nil
+}
+
+// openMaintenance connects to the named database on the server described
+// by cfg, without the pool tuning or ping of NewConnection.
+func openMaintenance(d Dialect, cfg config.DatabaseConfig, database string) (*sql.DB, error) {
+	cfg.Database = database
+	c := config.Config{Database: cfg}
+
+	db, err := sql.Open(d.DriverName(), c.GetDSN())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open maintenance connection: %w", err)
+	}
+	return db, nil
+}
+
+// execMaintenance runs a single statement on the maintenance database.
+func execMaintenance(d Dialect, cfg config.DatabaseConfig, database, query string, args ...interface{}) error {
+	db, err := openMaintenance(d, cfg, database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(query, args...)
+	return err
+}
+
+// existsMaintenance runs a COUNT query on the maintenance database.
+func existsMaintenance(d Dialect, cfg config.DatabaseConfig, database, query string, args ...interface{}) (bool, error) {
+	db, err := openMaintenance(d, cfg, database)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	var count int
+	if err := db.QueryRow(query, args...).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrBulkLoadUnavailable is returned by BulkLoad when the server does not
+// allow the dialect's bulk load path, before any row has been sent. Callers
+// fall back to INSERT statements.
+var ErrBulkLoadUnavailable = errors.New("bulk load is not available")
+
+// BulkLoader is implemented by dialects that can load many rows faster than
+// INSERT statements, such as PostgreSQL with COPY.
+type BulkLoader interface {
+	// BulkLoad starts loading rows into the columns of tableName as part of
+	// tx. Rows are plain inserts; conflicts fail the load.
+	BulkLoad(tx *sql.Tx, tableName string, columns []string) (RowWriter, error)
+}
+
+// RowWriter takes rows with a fixed set of columns. Close must always be
+// called: it sends whatever is still buffered and reports errors that only
+// surface once every row has been sent.
+type RowWriter interface {
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+// BatchLimiter is implemented by dialects that cap the rows or bind
+// parameters of a single statement below what InsertRowsSQL would
+// otherwise be asked for. Zero means no limit.
+type BatchLimiter interface {
+	MaxInsertRows() int
+	MaxParameters() int
+}
+
+// defaultMaxParameters is the bind parameter limit of the PostgreSQL and
+// MySQL protocols, which count parameters in 16 bits.
+const defaultMaxParameters = 65535
+
+// BatchRows returns how many rows of columns columns fit in one multi-row
+// INSERT, at most batchSize.
+func BatchRows(d Dialect, columns, batchSize int) int {
+	rows, params := 0, defaultMaxParameters
+	if limiter, ok := d.(BatchLimiter); ok {
+		rows, params = limiter.MaxInsertRows(), limiter.MaxParameters()
+	}
+
+	n := batchSize
+	if rows > 0 && n > rows {
+		n = rows
+	}
+	if params > 0 && columns > 0 && n*columns > params {
+		n = params / columns
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// InsertRowsSQL builds a parameterised INSERT statement that writes rows
+// rows at once, with the parameters of each row in turn.
+func InsertRowsSQL(d Dialect, tableName string, columns []string, rows int) string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = d.QuoteIdentifier(column)
+	}
+
+	values := make([]string, rows)
+	placeholders := make([]string, len(columns))
+	for row := range values {
+		for i := range columns {
+			placeholders[i] = d.Placeholder(row*len(columns) + i + 1)
+		}
+		values[row] = "(" + strings.Join(placeholders, ", ") + ")"
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES %s",
+		d.QuoteIdentifier(tableName),
+		strings.Join(quoted, ", "),
+		strings.Join(values, ", "))
+}
@@ -1,29 +1,37 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
-	_ "github.com/mattn/go-sqlite3"
+	_ "github.com/microsoft/go-mssqldb"
+	_ "modernc.org/sqlite"
 
 	"migr8/pkg/config"
 )
 
 type DB struct {
 	*sql.DB
-	Driver string
+	Driver  string
+	Dialect Dialect
 }
 
 func NewConnection(cfg *config.Config) (*DB, error) {
+	dialect, err := GetDialect(cfg.Database.Driver)
+	if err != nil {
+		return nil, err
+	}
+
 	dsn := cfg.GetDSN()
 	if dsn == "" {
 		return nil, fmt.Errorf("unsupported database driver: %s", cfg.Database.Driver)
 	}
 
-	sqlDB, err := sql.Open(cfg.Database.Driver, dsn)
+	sqlDB, err := sql.Open(dialect.DriverName(), dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database connection: %w", err)
 	}
@@ -38,51 +46,33 @@ func NewConnection(cfg *config.Config) (*DB, error) {
 	}
 
 	return &DB{
-		DB:     sqlDB,
-		Driver: cfg.Database.Driver,
+		DB:      sqlDB,
+		Driver:  cfg.Database.Driver,
+		Dialect: dialect,
 	}, nil
 }
 
+// dialect returns the connection's dialect, resolving it from Driver for
+// values constructed without NewConnection.
+func (db *DB) dialect() (Dialect, error) {
+	if db.Dialect != nil {
+		return db.Dialect, nil
+	}
+	return GetDialect(db.Driver)
+}
+
 func (db *DB) CreateMigrationsTable(tableName string) error {
-	var query string
-	
-	switch db.Driver {
-	case "postgres":
-		query = fmt.Sprintf(`
-			CREATE TABLE IF NOT EXISTS %s (
-				id SERIAL PRIMARY KEY,
-				filename VARCHAR(255) NOT NULL UNIQUE,
-				applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-				checksum VARCHAR(32) NOT NULL
-			);
-			CREATE INDEX IF NOT EXISTS idx_%s_filename ON %s(filename);
-		`, tableName, tableName, tableName)
-	case "mysql":
-		query = fmt.Sprintf(`
-			CREATE TABLE IF NOT EXISTS %s (
-				id INT AUTO_INCREMENT PRIMARY KEY,
-				filename VARCHAR(255) NOT NULL UNIQUE,
-				applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-				checksum VARCHAR(32) NOT NULL,
-				INDEX idx_%s_filename (filename)
-			) ENGINE=InnoDB;
-		`, tableName, tableName)
-	case "sqlite3":
-		query = fmt.Sprintf(`
-			CREATE TABLE IF NOT EXISTS %s (
-				id INTEGER PRIMARY KEY AUTOINCREMENT,
-				filename TEXT NOT NULL UNIQUE,
-				applied_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-				checksum TEXT NOT NULL
-			);
-			CREATE INDEX IF NOT EXISTS idx_%s_filename ON %s(filename);
-		`, tableName, tableName, tableName)
-	default:
-		return fmt.Errorf("unsupported database driver: %s", db.Driver)
+	dialect, err := db.dialect()
+	if err != nil {
+		return err
 	}
 
-	_, err := db.Exec(query)
-	return err
+	for _, query := range dialect.MigrationsTableDDL(tableName) {
+		if _, err := db.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (db *DB) GetAppliedMigrations(tableName string) ([]string, error) {
@@ -105,24 +95,142 @@ func (db *DB) GetAppliedMigrations(tableName string) ([]string, error) {
 	return migrations, rows.Err()
 }
 
+// HistoryEntry is a row of a history table, as created by
+// CreateMigrationsTable. Seeds use the same layout as migrations.
+type HistoryEntry struct {
+	Filename  string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// GetHistory returns every row of a history table in the order they were
+// recorded.
+func (db *DB) GetHistory(tableName string) ([]HistoryEntry, error) {
+	query := fmt.Sprintf("SELECT filename, checksum, applied_at FROM %s ORDER BY id", tableName)
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []HistoryEntry
+	for rows.Next() {
+		var entry HistoryEntry
+		if err := rows.Scan(&entry.Filename, &entry.Checksum, (*timestamp)(&entry.AppliedAt)); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// timestamp scans a column that drivers return either as a time.Time or,
+// like MySQL without parseTime, as text.
+type timestamp time.Time
+
+func (t *timestamp) Scan(value interface{}) error {
+	var text string
+	switch v := value.(type) {
+	case nil:
+		*t = timestamp{}
+		return nil
+	case time.Time:
+		*t = timestamp(v)
+		return nil
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	default:
+		return fmt.Errorf("cannot scan %T into a timestamp", value)
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05"} {
+		if parsed, err := time.Parse(layout, text); err == nil {
+			*t = timestamp(parsed)
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot parse timestamp %q", text)
+}
+
 func (db *DB) RecordMigration(tableName, filename, checksum string) error {
-	query := fmt.Sprintf("INSERT INTO %s (filename, checksum) VALUES (?, ?)", tableName)
-	
-	if db.Driver == "postgres" {
-		query = fmt.Sprintf("INSERT INTO %s (filename, checksum) VALUES ($1, $2)", tableName)
+	return db.RecordMigrationWith(db.DB, tableName, filename, checksum)
+}
+
+func (db *DB) RemoveMigration(tableName, filename string) error {
+	return db.RemoveMigrationWith(db.DB, tableName, filename)
+}
+
+// Execer is satisfied by *sql.DB, *sql.Tx and *sql.Conn.
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// RecordMigrationWith inserts a history row using exec, which lets callers
+// record the migration inside the transaction that applied it.
+func (db *DB) RecordMigrationWith(exec Execer, tableName, filename, checksum string) error {
+	dialect, err := db.dialect()
+	if err != nil {
+		return err
 	}
-	
-	_, err := db.Exec(query, filename, checksum)
+
+	query := fmt.Sprintf("INSERT INTO %s (filename, checksum) VALUES (%s, %s)",
+		tableName, dialect.Placeholder(1), dialect.Placeholder(2))
+
+	_, err = exec.Exec(query, filename, checksum)
 	return err
 }
 
-func (db *DB) RemoveMigration(tableName, filename string) error {
-	query := fmt.Sprintf("DELETE FROM %s WHERE filename = ?", tableName)
-	
-	if db.Driver == "postgres" {
-		query = fmt.Sprintf("DELETE FROM %s WHERE filename = $1", tableName)
+func (db *DB) RemoveMigrationWith(exec Execer, tableName, filename string) error {
+	dialect, err := db.dialect()
+	if err != nil {
+		return err
 	}
-	
-	_, err := db.Exec(query, filename)
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE filename = %s", tableName, dialect.Placeholder(1))
+
+	_, err = exec.Exec(query, filename)
 	return err
-}
\ No newline at end of file
+}
+
+// BeginSnapshot starts a read-only transaction whose reads all see the
+// same state of the database, so that a dump read table by table is
+// consistent. It runs on a single connection until it ends.
+func (db *DB) BeginSnapshot() (*sql.Tx, error) {
+	dialect, err := db.dialect()
+	if err != nil {
+		return nil, err
+	}
+
+	var opts *sql.TxOptions
+	if snapshotter, ok := dialect.(Snapshotter); ok {
+		if opts, err = snapshotter.SnapshotOptions(db); err != nil {
+			return nil, err
+		}
+	}
+	return db.BeginTx(context.Background(), opts)
+}
+
+// WithLock runs fn while holding the dialect's named advisory lock on a
+// dedicated connection, so concurrent migr8 processes serialise.
+func (db *DB) WithLock(name string, fn func() error) error {
+	dialect, err := db.dialect()
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection for lock: %w", err)
+	}
+	defer conn.Close()
+
+	if err := dialect.Lock(conn, name); err != nil {
+		return fmt.Errorf("failed to acquire lock %s: %w", name, err)
+	}
+	defer dialect.Unlock(conn, name)
+
+	return fn()
+}
@@ -25,7 +25,7 @@ func getTestConfig(driver string) *config.Config {
 		cfg.Database.SSLMode = "disable"
 	case "mysql":
 		cfg.Database.Port = getEnvInt("TEST_DB_PORT", 3306)
-	case "sqlite3":
+	case "sqlite3", "sqlite":
 		cfg.Database.Database = ":memory:"
 		cfg.Database.Port = 0
 	}
@@ -52,7 +52,7 @@ func getEnvInt(key string, defaultValue int) int {
 }
 
 func TestNewConnection(t *testing.T) {
-	drivers := []string{"sqlite3"}
+	drivers := []string{"sqlite3", "sqlite"}
 	
 	// Only test postgres/mysql if running in CI environment
 	if os.Getenv("CI") == "true" {
@@ -82,7 +82,7 @@ func TestNewConnection(t *testing.T) {
 }
 
 func TestCreateMigrationsTable(t *testing.T) {
-	drivers := []string{"sqlite3"}
+	drivers := []string{"sqlite3", "sqlite"}
 	
 	if os.Getenv("CI") == "true" {
 		drivers = append(drivers, "postgres", "mysql")
@@ -120,7 +120,7 @@ func TestCreateMigrationsTable(t *testing.T) {
 }
 
 func TestMigrationOperations(t *testing.T) {
-	drivers := []string{"sqlite3"}
+	drivers := []string{"sqlite3", "sqlite"}
 	
 	if os.Getenv("CI") == "true" {
 		drivers = append(drivers, "postgres", "mysql")
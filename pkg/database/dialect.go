@@ -0,0 +1,197 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"migr8/pkg/config"
+)
+
+// Dialect captures everything that differs between database engines so the
+// migration, seed and backup code can stay driver agnostic. Adding support
+// for a new database means implementing Dialect in a new file and calling
+// RegisterDialect from its init function.
+type Dialect interface {
+	// Name is the value used for database.driver in the configuration.
+	Name() string
+	// DriverName is the database/sql driver passed to sql.Open.
+	DriverName() string
+
+	Placeholder(n int) string
+	QuoteIdentifier(name string) string
+	// Statements breaks a script into the units that are sent to the
+	// server one Exec at a time.
+	Statements(r io.Reader) *StatementScanner
+
+	MigrationsTableDDL(tableName string) []string
+	// TruncateTableSQL empties a table inside the seed transaction, so it
+	// must not commit implicitly.
+	TruncateTableSQL(tableName string) string
+	UpsertSQL(tableName string, columns, keyColumns []string) string
+	// InsertIgnoreSQL inserts a row unless one with the same key already
+	// exists, leaving the existing row as it is.
+	InsertIgnoreSQL(tableName string, columns, keyColumns []string) string
+
+	Lock(conn *sql.Conn, name string) error
+	Unlock(conn *sql.Conn, name string) error
+
+	// DumpCommand returns the external tool invocation that writes a SQL
+	// dump of the part of the database selected by scope to stdout.
+	DumpCommand(cfg config.DatabaseConfig, scope DumpScope) (*exec.Cmd, error)
+	RestoreCommand(cfg config.DatabaseConfig) (*exec.Cmd, error)
+}
+
+// DumpScope selects what part of a database a backup contains. The zero
+// value is a full backup.
+type DumpScope struct {
+	// Tables limits the dump to these tables; empty means all of them.
+	Tables []string `json:"tables,omitempty"`
+	// ExcludeTables are left out, even when listed in Tables.
+	ExcludeTables []string `json:"exclude_tables,omitempty"`
+	SchemaOnly    bool     `json:"schema_only,omitempty"`
+	DataOnly      bool     `json:"data_only,omitempty"`
+	// Where holds a row filter per table, as a SQL boolean expression.
+	Where map[string]string `json:"where,omitempty"`
+}
+
+// IsFull reports whether the scope selects the whole database.
+func (s DumpScope) IsFull() bool {
+	return len(s.Tables) == 0 && len(s.ExcludeTables) == 0 && !s.SchemaOnly && !s.DataOnly && len(s.Where) == 0
+}
+
+// Validate rejects scopes that cannot be dumped by any engine.
+func (s DumpScope) Validate() error {
+	if s.SchemaOnly && s.DataOnly {
+		return fmt.Errorf("schema-only and data-only backups are mutually exclusive")
+	}
+	if s.SchemaOnly && len(s.Where) > 0 {
+		return fmt.Errorf("row filters cannot be combined with a schema-only backup")
+	}
+	return nil
+}
+
+// IdentityInserter is implemented by dialects that reject explicit values
+// for identity columns unless the session opts in, as SQL Server does with
+// IDENTITY_INSERT.
+type IdentityInserter interface {
+	IdentityInsertSQL(tableName string, columns []string, enable bool) string
+}
+
+// RowLimiter is implemented by dialects that do not take LIMIT at the end
+// of a SELECT, as SQL Server, which takes TOP after the SELECT keyword.
+type RowLimiter interface {
+	LimitSQL(query string, limit int) string
+}
+
+// Snapshotter is implemented by dialects whose default transactions do not
+// read a single snapshot of the database. SnapshotOptions returns the
+// options of a read-only transaction whose reads all do.
+type Snapshotter interface {
+	SnapshotOptions(q Queryer) (*sql.TxOptions, error)
+}
+
+var (
+	dialectsMu sync.RWMutex
+	dialects   = make(map[string]Dialect)
+)
+
+func RegisterDialect(d Dialect) {
+	dialectsMu.Lock()
+	defer dialectsMu.Unlock()
+
+	if d == nil {
+		panic("database: RegisterDialect dialect is nil")
+	}
+	if _, dup := dialects[d.Name()]; dup {
+		panic("database: RegisterDialect called twice for " + d.Name())
+	}
+	dialects[d.Name()] = d
+}
+
+func GetDialect(name string) (Dialect, error) {
+	dialectsMu.RLock()
+	defer dialectsMu.RUnlock()
+
+	d, ok := dialects[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database driver: %s", name)
+	}
+	return d, nil
+}
+
+func Dialects() []string {
+	dialectsMu.RLock()
+	defer dialectsMu.RUnlock()
+
+	names := make([]string, 0, len(dialects))
+	for name := range dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// InsertSQL builds a plain parameterised INSERT statement using the
+// dialect's quoting and placeholder rules.
+func InsertSQL(d Dialect, tableName string, columns []string) string {
+	quoted := make([]string, len(columns))
+	placeholders := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = d.QuoteIdentifier(column)
+		placeholders[i] = d.Placeholder(i + 1)
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		d.QuoteIdentifier(tableName),
+		strings.Join(quoted, ", "),
+		strings.Join(placeholders, ", "))
+}
+
+// SelectRowsSQL selects every column of the rows of tableName matching
+// where, a SQL boolean expression, or all rows when it is empty. A positive
+// limit caps the number of rows.
+func SelectRowsSQL(d Dialect, tableName, where string, limit int) string {
+	query := fmt.Sprintf("SELECT * FROM %s", d.QuoteIdentifier(tableName))
+	if where != "" {
+		query += " WHERE " + where
+	}
+	if limit <= 0 {
+		return query
+	}
+	if limiter, ok := d.(RowLimiter); ok {
+		return limiter.LimitSQL(query, limit)
+	}
+	return fmt.Sprintf("%s LIMIT %d", query, limit)
+}
+
+func isCommentOnly(stmt string) bool {
+	for _, line := range strings.Split(stmt, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
+
+// quoteParts quotes every dot-separated part of a possibly schema-qualified
+// identifier with the given quote characters.
+func quoteParts(name, open, close string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		part = strings.ReplaceAll(part, close, close+close)
+		parts[i] = open + part + close
+	}
+	return strings.Join(parts, ".")
+}
+
+// indexName derives a history table index name that stays a valid plain
+// identifier even when the table name is schema-qualified.
+func indexName(tableName string) string {
+	return "idx_" + strings.ReplaceAll(tableName, ".", "_") + "_filename"
+}
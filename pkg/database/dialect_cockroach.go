@@ -0,0 +1,192 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"migr8/pkg/config"
+)
+
+// cockroachDialect speaks the postgres wire protocol through lib/pq but
+// papers over the features CockroachDB does not share with PostgreSQL:
+// SERIAL yields unordered unique_rowid values, advisory locks are no-ops
+// and TRUNCATE ... RESTART IDENTITY is rejected.
+type cockroachDialect struct {
+	postgresDialect
+}
+
+const (
+	cockroachLockTable = "migr8_locks"
+	// cockroachLockTTL bounds how long a lock left behind by a crashed
+	// process blocks others.
+	cockroachLockTTL      = time.Hour
+	cockroachLockInterval = time.Second
+)
+
+func init() {
+	RegisterDialect(cockroachDialect{})
+}
+
+func (cockroachDialect) Name() string { return "cockroach" }
+
+func (cockroachDialect) MigrationsTableDDL(tableName string) []string {
+	sequence := strings.ReplaceAll(tableName, ".", "_") + "_id_seq"
+	return []string{
+		fmt.Sprintf("CREATE SEQUENCE IF NOT EXISTS %s", sequence),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+				id INT8 PRIMARY KEY DEFAULT nextval('%s'),
+				filename VARCHAR(255) NOT NULL UNIQUE,
+				applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				checksum VARCHAR(32) NOT NULL
+			)`, tableName, sequence),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s(filename)", indexName(tableName), tableName),
+	}
+}
+
+// TruncateTableSQL uses DELETE because TRUNCATE is a schema change in
+// CockroachDB and does not mix well with the seed's data transaction.
+func (d cockroachDialect) TruncateTableSQL(tableName string) string {
+	return fmt.Sprintf("DELETE FROM %s", d.QuoteIdentifier(tableName))
+}
+
+func (d cockroachDialect) UpsertSQL(tableName string, columns, keyColumns []string) string {
+	return InsertSQL(d, tableName, columns) + onConflictClause(d, columns, keyColumns)
+}
+
+func (d cockroachDialect) InsertIgnoreSQL(tableName string, columns, keyColumns []string) string {
+	return InsertSQL(d, tableName, columns) + onConflictClause(d, keyColumns, keyColumns)
+}
+
+// Lock emulates an advisory lock with a row in a small lock table, polling
+// until the row can be inserted.
+func (cockroachDialect) Lock(conn *sql.Conn, name string) error {
+	ctx := context.Background()
+
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		name STRING PRIMARY KEY,
+		acquired_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`, cockroachLockTable))
+	if err != nil {
+		return err
+	}
+
+	for {
+		_, err := conn.ExecContext(ctx,
+			fmt.Sprintf("DELETE FROM %s WHERE name = $1 AND acquired_at < now() - $2::INT8 * INTERVAL '1 second'", cockroachLockTable),
+			name, int64(cockroachLockTTL.Seconds()))
+		if err != nil {
+			return err
+		}
+
+		result, err := conn.ExecContext(ctx,
+			fmt.Sprintf("INSERT INTO %s (name) VALUES ($1) ON CONFLICT (name) DO NOTHING", cockroachLockTable),
+			name)
+		if err != nil {
+			return err
+		}
+
+		if affected, err := result.RowsAffected(); err == nil && affected == 1 {
+			return nil
+		}
+
+		time.Sleep(cockroachLockInterval)
+	}
+}
+
+func (cockroachDialect) Unlock(conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(context.Background(),
+		fmt.Sprintf("DELETE FROM %s WHERE name = $1", cockroachLockTable), name)
+	return err
+}
+
+// DumpCommand always fails: cockroach dump was removed in CockroachDB 20.2
+// and nothing replaced it with a streaming SQL dump, so backups of
+// CockroachDB go through the native engine.
+func (cockroachDialect) DumpCommand(cfg config.DatabaseConfig, scope DumpScope) (*exec.Cmd, error) {
+	return nil, fmt.Errorf("cockroach dump no longer exists; set backup.engine: native to back up CockroachDB")
+}
+
+// RestoreCommand replays SQL dumps taken with older CockroachDB versions.
+// The connection URL carries the password, so it goes through COCKROACH_URL
+// rather than --url, where it would show up in the process list.
+func (cockroachDialect) RestoreCommand(cfg config.DatabaseConfig) (*exec.Cmd, error) {
+	cmd := exec.Command("cockroach", "sql")
+	cmd.Env = append(os.Environ(), "COCKROACH_URL="+cockroachURL(cfg))
+	return cmd, nil
+}
+
+// CockroachDB always has a defaultdb database to connect to while managing
+// others, and renames databases online, so no sessions are terminated.
+
+func (d cockroachDialect) DatabaseExists(cfg config.DatabaseConfig) (bool, error) {
+	return existsMaintenance(d, cfg, "defaultdb", `SELECT COUNT(*) FROM pg_database WHERE datname = $1`, cfg.Database)
+}
+
+func (d cockroachDialect) CreateDatabase(cfg config.DatabaseConfig) error {
+	return execMaintenance(d, cfg, "defaultdb", "CREATE DATABASE "+d.QuoteIdentifier(cfg.Database))
+}
+
+func (d cockroachDialect) DropDatabase(cfg config.DatabaseConfig) error {
+	return execMaintenance(d, cfg, "defaultdb", "DROP DATABASE IF EXISTS "+d.QuoteIdentifier(cfg.Database)+" CASCADE")
+}
+
+func (d cockroachDialect) RenameDatabase(cfg config.DatabaseConfig, newName string) error {
+	return execMaintenance(d, cfg, "defaultdb", fmt.Sprintf("ALTER DATABASE %s RENAME TO %s",
+		d.QuoteIdentifier(cfg.Database), d.QuoteIdentifier(newName)))
+}
+
+func cockroachURL(cfg config.DatabaseConfig) string {
+	query := url.Values{}
+	if cfg.SSLMode != "" {
+		query.Set("sslmode", cfg.SSLMode)
+	}
+
+	u := url.URL{
+		Scheme:   "postgresql",
+		User:     url.UserPassword(cfg.Username, cfg.Password),
+		Host:     fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		Path:     "/" + cfg.Database,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+// TableDefinition uses SHOW CREATE TABLE, which reproduces CockroachDB
+// specifics such as hash-sharded indexes that pg_catalog does not expose.
+func (d cockroachDialect) TableDefinition(q Queryer, tableName string) (*TableDefinition, error) {
+	quoted := d.QuoteIdentifier(tableName)
+
+	var name, create string
+	if err := q.QueryRow(fmt.Sprintf("SHOW CREATE TABLE %s", quoted)).Scan(&name, &create); err != nil {
+		return nil, err
+	}
+
+	columns, err := d.Columns(q, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	def := &TableDefinition{
+		Drop: fmt.Sprintf("DROP TABLE IF EXISTS %s CASCADE", quoted),
+	}
+	for _, c := range columns {
+		if !c.Default.Valid {
+			continue
+		}
+		if m := nextvalPattern.FindStringSubmatch(c.Default.String); m != nil {
+			def.Create = append(def.Create, fmt.Sprintf("CREATE SEQUENCE IF NOT EXISTS %s", m[1]))
+			def.After = append(def.After, fmt.Sprintf(
+				"SELECT setval('%s', COALESCE(MAX(%s), 0) + 1, false) FROM %s",
+				m[1], d.QuoteIdentifier(c.Name), quoted))
+		}
+	}
+	def.Create = append(def.Create, create)
+
+	return def, nil
+}
@@ -0,0 +1,371 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"migr8/pkg/config"
+)
+
+type mysqlDialect struct{}
+
+func init() {
+	RegisterDialect(mysqlDialect{})
+}
+
+func (mysqlDialect) Name() string       { return "mysql" }
+func (mysqlDialect) DriverName() string { return "mysql" }
+
+func (mysqlDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (mysqlDialect) QuoteIdentifier(name string) string {
+	return quoteParts(name, "`", "`")
+}
+
+func (mysqlDialect) Statements(r io.Reader) *StatementScanner {
+	return NewStatementScanner(r).WithBackslashEscapes()
+}
+
+func (mysqlDialect) MigrationsTableDDL(tableName string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+				id INT AUTO_INCREMENT PRIMARY KEY,
+				filename VARCHAR(255) NOT NULL UNIQUE,
+				applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				checksum VARCHAR(32) NOT NULL,
+				INDEX %s (filename)
+			) ENGINE=InnoDB`, tableName, indexName(tableName)),
+	}
+}
+
+// TruncateTableSQL uses DELETE because TRUNCATE commits the seed's data
+// transaction implicitly, so a failed run would leave tables emptied. The
+// AUTO_INCREMENT counter is not reset.
+func (d mysqlDialect) TruncateTableSQL(tableName string) string {
+	return fmt.Sprintf("DELETE FROM %s", d.QuoteIdentifier(tableName))
+}
+
+func (d mysqlDialect) UpsertSQL(tableName string, columns, keyColumns []string) string {
+	keys := make(map[string]bool, len(keyColumns))
+	for _, key := range keyColumns {
+		keys[key] = true
+	}
+
+	var updates []string
+	for _, column := range columns {
+		if keys[column] {
+			continue
+		}
+		quoted := d.QuoteIdentifier(column)
+		updates = append(updates, fmt.Sprintf("%s = VALUES(%s)", quoted, quoted))
+	}
+
+	// MySQL has no DO NOTHING; a self-assignment of a key column is the
+	// conventional no-op update.
+	if len(updates) == 0 && len(keyColumns) > 0 {
+		quoted := d.QuoteIdentifier(keyColumns[0])
+		updates = append(updates, fmt.Sprintf("%s = %s", quoted, quoted))
+	}
+
+	return InsertSQL(d, tableName, columns) + " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+}
+
+// InsertIgnoreSQL uses the no-op update rather than INSERT IGNORE, which
+// also turns conversion errors into warnings. Without any column there is
+// nothing to assign, and the row is inserted as is.
+func (d mysqlDialect) InsertIgnoreSQL(tableName string, columns, keyColumns []string) string {
+	var key string
+	switch {
+	case len(keyColumns) > 0:
+		key = keyColumns[0]
+	case len(columns) > 0:
+		key = columns[0]
+	default:
+		return InsertSQL(d, tableName, columns)
+	}
+	quoted := d.QuoteIdentifier(key)
+	return InsertSQL(d, tableName, columns) + fmt.Sprintf(" ON DUPLICATE KEY UPDATE %s = %s", quoted, quoted)
+}
+
+func (mysqlDialect) Lock(conn *sql.Conn, name string) error {
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(context.Background(), "SELECT GET_LOCK(?, -1)", name).Scan(&acquired); err != nil {
+		return err
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		return fmt.Errorf("failed to acquire lock %s", name)
+	}
+	return nil
+}
+
+func (mysqlDialect) Unlock(conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", name)
+	return err
+}
+
+// DumpCommand supports row filters only for a single table, because
+// mysqldump applies --where to every table it dumps.
+func (mysqlDialect) DumpCommand(cfg config.DatabaseConfig, scope DumpScope) (*exec.Cmd, error) {
+	args := []string{
+		"-h", cfg.Host,
+		"-P", fmt.Sprintf("%d", cfg.Port),
+		"-u", cfg.Username,
+		fmt.Sprintf("-p%s", cfg.Password),
+		"--single-transaction",
+		"--triggers",
+	}
+
+	// Routines belong to the whole database, not to a selection of tables.
+	if len(scope.Tables) == 0 && !scope.DataOnly {
+		args = append(args, "--routines")
+	}
+	if scope.SchemaOnly {
+		args = append(args, "--no-data")
+	}
+	if scope.DataOnly {
+		args = append(args, "--no-create-info")
+	}
+	if len(scope.Where) > 0 {
+		if len(scope.Tables) != 1 {
+			return nil, fmt.Errorf("mysqldump can only filter rows when dumping a single table")
+		}
+		args = append(args, "--where="+scope.Where[scope.Tables[0]])
+	}
+	for _, table := range scope.ExcludeTables {
+		args = append(args, fmt.Sprintf("--ignore-table=%s.%s", cfg.Database, table))
+	}
+
+	args = append(args, cfg.Database)
+	args = append(args, scope.Tables...)
+
+	return exec.Command("mysqldump", args...), nil
+}
+
+func (mysqlDialect) RestoreCommand(cfg config.DatabaseConfig) (*exec.Cmd, error) {
+	args := []string{
+		"-h", cfg.Host,
+		"-P", fmt.Sprintf("%d", cfg.Port),
+		"-u", cfg.Username,
+		fmt.Sprintf("-p%s", cfg.Password),
+		cfg.Database,
+	}
+
+	return exec.Command("mysql", args...), nil
+}
+
+// MySQL has no RENAME DATABASE, so restores into a scratch database cannot
+// be swapped in; only creating and dropping is supported.
+
+func (d mysqlDialect) DatabaseExists(cfg config.DatabaseConfig) (bool, error) {
+	return existsMaintenance(d, cfg, "", `SELECT COUNT(*) FROM information_schema.schemata WHERE schema_name = ?`, cfg.Database)
+}
+
+func (d mysqlDialect) CreateDatabase(cfg config.DatabaseConfig) error {
+	return execMaintenance(d, cfg, "", "CREATE DATABASE "+d.QuoteIdentifier(cfg.Database))
+}
+
+func (d mysqlDialect) DropDatabase(cfg config.DatabaseConfig) error {
+	return execMaintenance(d, cfg, "", "DROP DATABASE IF EXISTS "+d.QuoteIdentifier(cfg.Database))
+}
+
+var mysqlLiterals = literalStyle{
+	binary:           hexLiteral("X'", "'"),
+	boolTrue:         "1",
+	boolFalse:        "0",
+	timeLayout:       "2006-01-02 15:04:05.999999",
+	backslashEscapes: true,
+}
+
+func (mysqlDialect) Tables(q Queryer) ([]string, error) {
+	return scanStrings(q, `SELECT table_name FROM information_schema.tables
+		WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE' ORDER BY table_name`)
+}
+
+func (mysqlDialect) Columns(q Queryer, tableName string) ([]Column, error) {
+	rows, err := q.Query(`SELECT column_name, column_type, is_nullable = 'YES', column_default, column_key = 'PRI'
+		FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND table_name = ?
+		ORDER BY ordinal_position`, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []Column
+	for rows.Next() {
+		var c Column
+		if err := rows.Scan(&c.Name, &c.Type, &c.Nullable, &c.Default, &c.PrimaryKey); err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
+
+func (mysqlDialect) ForeignKeys(q Queryer) ([]ForeignKey, error) {
+	rows, err := q.Query(`SELECT constraint_name, table_name, column_name, referenced_table_name, referenced_column_name
+		FROM information_schema.key_column_usage
+		WHERE table_schema = DATABASE() AND referenced_table_name IS NOT NULL
+		ORDER BY table_name, constraint_name, ordinal_position`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []ForeignKey
+	for rows.Next() {
+		var fk ForeignKey
+		if err := rows.Scan(&fk.Name, &fk.Table, &fk.Column, &fk.ReferencedTable, &fk.ReferencedColumn); err != nil {
+			return nil, err
+		}
+		keys = append(keys, fk)
+	}
+	return keys, rows.Err()
+}
+
+// TableDefinition uses SHOW CREATE TABLE verbatim. Foreign keys stay
+// inline; DumpPreamble disables their checks while the dump is replayed.
+func (d mysqlDialect) TableDefinition(q Queryer, tableName string) (*TableDefinition, error) {
+	var name, create string
+	if err := q.QueryRow(fmt.Sprintf("SHOW CREATE TABLE %s", d.QuoteIdentifier(tableName))).Scan(&name, &create); err != nil {
+		return nil, err
+	}
+
+	return &TableDefinition{
+		Drop:   fmt.Sprintf("DROP TABLE IF EXISTS %s", d.QuoteIdentifier(tableName)),
+		Create: []string{create},
+	}, nil
+}
+
+func (mysqlDialect) Literal(value interface{}, databaseType string) string {
+	return mysqlLiterals.format(value, databaseType)
+}
+
+func (mysqlDialect) DumpPreamble() []string  { return []string{"SET FOREIGN_KEY_CHECKS = 0"} }
+func (mysqlDialect) DumpPostamble() []string { return []string{"SET FOREIGN_KEY_CHECKS = 1"} }
+
+// SnapshotOptions asks for REPEATABLE READ, under which InnoDB reads every
+// table as of the transaction's first read. Schema changes are not
+// versioned, so DDL running during a dump can still break it.
+func (mysqlDialect) SnapshotOptions(q Queryer) (*sql.TxOptions, error) {
+	return &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}, nil
+}
+
+// mysqlLoads numbers the reader handlers of concurrent bulk loads.
+var mysqlLoads uint64
+
+// errLoadFinished stops writers once the server is no longer reading.
+var errLoadFinished = errors.New("LOAD DATA finished before all rows were sent")
+
+// BulkLoad streams rows with LOAD DATA LOCAL INFILE, which needs
+// local_infile enabled on the server. LOAD DATA LOCAL turns errors into
+// warnings, like INSERT IGNORE does, so any warning fails the load.
+func (d mysqlDialect) BulkLoad(tx *sql.Tx, tableName string, columns []string) (RowWriter, error) {
+	var enabled int
+	if err := tx.QueryRow("SELECT @@GLOBAL.local_infile").Scan(&enabled); err != nil {
+		return nil, err
+	}
+	if enabled == 0 {
+		return nil, ErrBulkLoadUnavailable
+	}
+
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = d.QuoteIdentifier(column)
+	}
+
+	name := fmt.Sprintf("migr8-%d", atomic.AddUint64(&mysqlLoads, 1))
+	query := fmt.Sprintf(`LOAD DATA LOCAL INFILE 'Reader::%s' INTO TABLE %s CHARACTER SET utf8mb4 `+
+		`FIELDS TERMINATED BY '\t' ESCAPED BY '\\' LINES TERMINATED BY '\n' (%s)`,
+		name, d.QuoteIdentifier(tableName), strings.Join(quoted, ", "))
+
+	pr, pw := io.Pipe()
+	mysql.RegisterReaderHandler(name, func() io.Reader { return pr })
+
+	w := &loadDataWriter{pipe: pw, buf: bufio.NewWriter(pw), done: make(chan error, 1)}
+	go func() {
+		defer mysql.DeregisterReaderHandler(name)
+		err := loadData(tx, query)
+		pr.CloseWithError(errLoadFinished)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+func loadData(tx *sql.Tx, query string) error {
+	if _, err := tx.Exec(query); err != nil {
+		return err
+	}
+
+	var level, message string
+	var code int
+	err := tx.QueryRow("SHOW WARNINGS LIMIT 1").Scan(&level, &code, &message)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("LOAD DATA %s %d: %s", strings.ToLower(level), code, message)
+}
+
+// loadDataWriter feeds the rows LOAD DATA reads through a pipe, in its
+// default tab-separated format.
+type loadDataWriter struct {
+	pipe *io.PipeWriter
+	buf  *bufio.Writer
+	done chan error
+}
+
+func (w *loadDataWriter) WriteRow(values []interface{}) error {
+	for i, value := range values {
+		if i > 0 {
+			w.buf.WriteByte('\t')
+		}
+		w.buf.WriteString(loadDataValue(value))
+	}
+	return w.buf.WriteByte('\n')
+}
+
+func (w *loadDataWriter) Close() error {
+	flushErr := w.buf.Flush()
+	w.pipe.Close()
+	if err := <-w.done; err != nil {
+		return err
+	}
+	return flushErr
+}
+
+var loadDataEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`, "\x00", `\0`)
+
+func loadDataValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return `\N`
+	case []byte:
+		return loadDataEscaper.Replace(string(v))
+	case string:
+		return loadDataEscaper.Replace(v)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.999999")
+	default:
+		return loadDataEscaper.Replace(fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,370 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"migr8/pkg/config"
+)
+
+type postgresDialect struct{}
+
+func init() {
+	RegisterDialect(postgresDialect{})
+}
+
+func (postgresDialect) Name() string       { return "postgres" }
+func (postgresDialect) DriverName() string { return "postgres" }
+
+func (postgresDialect) Placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+func (postgresDialect) QuoteIdentifier(name string) string {
+	return quoteParts(name, `"`, `"`)
+}
+
+func (postgresDialect) Statements(r io.Reader) *StatementScanner {
+	return NewStatementScanner(r)
+}
+
+func (d postgresDialect) MigrationsTableDDL(tableName string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+				id SERIAL PRIMARY KEY,
+				filename VARCHAR(255) NOT NULL UNIQUE,
+				applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				checksum VARCHAR(32) NOT NULL
+			)`, tableName),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s(filename)", indexName(tableName), tableName),
+	}
+}
+
+func (d postgresDialect) TruncateTableSQL(tableName string) string {
+	return fmt.Sprintf("TRUNCATE TABLE %s RESTART IDENTITY CASCADE", d.QuoteIdentifier(tableName))
+}
+
+func (d postgresDialect) UpsertSQL(tableName string, columns, keyColumns []string) string {
+	return InsertSQL(d, tableName, columns) + onConflictClause(d, columns, keyColumns)
+}
+
+func (d postgresDialect) InsertIgnoreSQL(tableName string, columns, keyColumns []string) string {
+	return InsertSQL(d, tableName, columns) + onConflictClause(d, keyColumns, keyColumns)
+}
+
+func (postgresDialect) Lock(conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_lock(hashtext($1))", name)
+	return err
+}
+
+func (postgresDialect) Unlock(conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", name)
+	return err
+}
+
+func (postgresDialect) DumpCommand(cfg config.DatabaseConfig, scope DumpScope) (*exec.Cmd, error) {
+	if len(scope.Where) > 0 {
+		return nil, fmt.Errorf("pg_dump cannot filter rows; use backup engine native for WHERE filters")
+	}
+
+	args := []string{
+		"-h", cfg.Host,
+		"-p", fmt.Sprintf("%d", cfg.Port),
+		"-U", cfg.Username,
+		"-d", cfg.Database,
+		"--no-password",
+		"--verbose",
+		"--no-acl",
+		"--no-owner",
+	}
+
+	// --clean would drop objects a data-only restore expects to exist.
+	// --if-exists keeps the drops from failing, and with ON_ERROR_STOP
+	// aborting, a restore into an empty scratch or target database.
+	if !scope.DataOnly {
+		args = append(args, "--clean", "--if-exists")
+	}
+	if scope.SchemaOnly {
+		args = append(args, "--schema-only")
+	}
+	if scope.DataOnly {
+		args = append(args, "--data-only")
+	}
+	for _, table := range scope.Tables {
+		args = append(args, "--table", table)
+	}
+	for _, table := range scope.ExcludeTables {
+		args = append(args, "--exclude-table", table)
+	}
+
+	cmd := exec.Command("pg_dump", args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", cfg.Password))
+	return cmd, nil
+}
+
+func (postgresDialect) RestoreCommand(cfg config.DatabaseConfig) (*exec.Cmd, error) {
+	args := []string{
+		"-h", cfg.Host,
+		"-p", fmt.Sprintf("%d", cfg.Port),
+		"-U", cfg.Username,
+		"-d", cfg.Database,
+		"--no-password",
+		"--verbose",
+		"--single-transaction",
+		"-v", "ON_ERROR_STOP=1",
+	}
+
+	cmd := exec.Command("psql", args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", cfg.Password))
+	return cmd, nil
+}
+
+func (d postgresDialect) DatabaseExists(cfg config.DatabaseConfig) (bool, error) {
+	return existsMaintenance(d, cfg, "postgres", `SELECT COUNT(*) FROM pg_database WHERE datname = $1`, cfg.Database)
+}
+
+func (d postgresDialect) CreateDatabase(cfg config.DatabaseConfig) error {
+	return execMaintenance(d, cfg, "postgres", "CREATE DATABASE "+d.QuoteIdentifier(cfg.Database))
+}
+
+func (d postgresDialect) DropDatabase(cfg config.DatabaseConfig) error {
+	return execMaintenance(d, cfg, "postgres", "DROP DATABASE IF EXISTS "+d.QuoteIdentifier(cfg.Database))
+}
+
+// RenameDatabase terminates other sessions connected to the database first,
+// since PostgreSQL refuses to rename a database that is in use.
+func (d postgresDialect) RenameDatabase(cfg config.DatabaseConfig, newName string) error {
+	db, err := openMaintenance(d, cfg, "postgres")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`SELECT pg_terminate_backend(pid) FROM pg_stat_activity
+		WHERE datname = $1 AND pid <> pg_backend_pid()`, cfg.Database); err != nil {
+		return fmt.Errorf("failed to disconnect sessions: %w", err)
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER DATABASE %s RENAME TO %s",
+		d.QuoteIdentifier(cfg.Database), d.QuoteIdentifier(newName)))
+	return err
+}
+
+// onConflictClause renders the ON CONFLICT suffix shared by postgres and
+// sqlite. It updates every non-key column of a conflicting row, and falls
+// back to DO NOTHING when there are none.
+func onConflictClause(d Dialect, columns, keyColumns []string) string {
+	keys := make(map[string]bool, len(keyColumns))
+	quotedKeys := make([]string, len(keyColumns))
+	for i, key := range keyColumns {
+		keys[key] = true
+		quotedKeys[i] = d.QuoteIdentifier(key)
+	}
+
+	var updates []string
+	for _, column := range columns {
+		if keys[column] {
+			continue
+		}
+		quoted := d.QuoteIdentifier(column)
+		updates = append(updates, fmt.Sprintf("%s = excluded.%s", quoted, quoted))
+	}
+
+	if len(updates) == 0 {
+		if len(quotedKeys) == 0 {
+			return " ON CONFLICT DO NOTHING"
+		}
+		return fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", strings.Join(quotedKeys, ", "))
+	}
+	return fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s",
+		strings.Join(quotedKeys, ", "), strings.Join(updates, ", "))
+}
+
+var postgresLiterals = literalStyle{
+	binary:     hexLiteral(`'\x`, `'::bytea`),
+	boolTrue:   "TRUE",
+	boolFalse:  "FALSE",
+	timeLayout: "2006-01-02 15:04:05.999999999-07:00",
+}
+
+var nextvalPattern = regexp.MustCompile(`nextval\('([^']+)'`)
+
+func (postgresDialect) Tables(q Queryer) ([]string, error) {
+	return scanStrings(q, `SELECT tablename FROM pg_catalog.pg_tables
+		WHERE schemaname = current_schema() ORDER BY tablename`)
+}
+
+func (d postgresDialect) Columns(q Queryer, tableName string) ([]Column, error) {
+	rows, err := q.Query(`SELECT a.attname, format_type(a.atttypid, a.atttypmod), NOT a.attnotnull,
+			pg_get_expr(ad.adbin, ad.adrelid),
+			EXISTS (SELECT 1 FROM pg_index i WHERE i.indrelid = a.attrelid AND i.indisprimary AND a.attnum = ANY(i.indkey))
+		FROM pg_attribute a
+		LEFT JOIN pg_attrdef ad ON ad.adrelid = a.attrelid AND ad.adnum = a.attnum
+		WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY a.attnum`, d.QuoteIdentifier(tableName))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []Column
+	for rows.Next() {
+		var c Column
+		if err := rows.Scan(&c.Name, &c.Type, &c.Nullable, &c.Default, &c.PrimaryKey); err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
+
+func (postgresDialect) ForeignKeys(q Queryer) ([]ForeignKey, error) {
+	rows, err := q.Query(`SELECT c.conname, cl.relname, a.attname, rcl.relname, ra.attname
+		FROM pg_constraint c
+		JOIN pg_class cl ON cl.oid = c.conrelid
+		JOIN pg_class rcl ON rcl.oid = c.confrelid
+		JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = c.conkey[1]
+		JOIN pg_attribute ra ON ra.attrelid = c.confrelid AND ra.attnum = c.confkey[1]
+		JOIN pg_namespace n ON n.oid = cl.relnamespace
+		WHERE c.contype = 'f' AND n.nspname = current_schema()
+		ORDER BY cl.relname, c.conname`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []ForeignKey
+	for rows.Next() {
+		var fk ForeignKey
+		if err := rows.Scan(&fk.Name, &fk.Table, &fk.Column, &fk.ReferencedTable, &fk.ReferencedColumn); err != nil {
+			return nil, err
+		}
+		keys = append(keys, fk)
+	}
+	return keys, rows.Err()
+}
+
+// TableDefinition rebuilds CREATE TABLE from the catalog. Foreign keys and
+// secondary indexes are deferred to After so tables can be loaded in any
+// order, and sequences behind serial columns are created up front and
+// advanced past the loaded data afterwards.
+func (d postgresDialect) TableDefinition(q Queryer, tableName string) (*TableDefinition, error) {
+	columns, err := d.Columns(q, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	quoted := d.QuoteIdentifier(tableName)
+	def := &TableDefinition{
+		Drop: fmt.Sprintf("DROP TABLE IF EXISTS %s CASCADE", quoted),
+	}
+
+	var lines []string
+	for _, c := range columns {
+		line := fmt.Sprintf("%s %s", d.QuoteIdentifier(c.Name), c.Type)
+		if c.Default.Valid {
+			line += " DEFAULT " + c.Default.String
+			if m := nextvalPattern.FindStringSubmatch(c.Default.String); m != nil {
+				def.Create = append(def.Create, fmt.Sprintf("CREATE SEQUENCE IF NOT EXISTS %s", m[1]))
+				def.After = append(def.After, fmt.Sprintf(
+					"SELECT setval('%s', COALESCE(MAX(%s), 0) + 1, false) FROM %s",
+					m[1], d.QuoteIdentifier(c.Name), quoted))
+			}
+		}
+		if !c.Nullable {
+			line += " NOT NULL"
+		}
+		lines = append(lines, line)
+	}
+
+	rows, err := q.Query(`SELECT conname, contype, pg_get_constraintdef(oid)
+		FROM pg_constraint WHERE conrelid = $1::regclass ORDER BY contype, conname`, quoted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, kind, constraint string
+		if err := rows.Scan(&name, &kind, &constraint); err != nil {
+			return nil, err
+		}
+		if kind == "f" {
+			def.After = append(def.After, fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s",
+				quoted, d.QuoteIdentifier(name), constraint))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("CONSTRAINT %s %s", d.QuoteIdentifier(name), constraint))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	def.Create = append(def.Create, fmt.Sprintf("CREATE TABLE %s (\n\t%s\n)", quoted, strings.Join(lines, ",\n\t")))
+
+	indexes, err := scanStrings(q, `SELECT indexdef FROM pg_indexes i
+		WHERE i.schemaname = current_schema() AND i.tablename = $1
+		AND NOT EXISTS (SELECT 1 FROM pg_constraint c WHERE c.conname = i.indexname)
+		ORDER BY i.indexname`, tableName)
+	if err != nil {
+		return nil, err
+	}
+	def.After = append(def.After, indexes...)
+
+	return def, nil
+}
+
+func (postgresDialect) Literal(value interface{}, databaseType string) string {
+	return postgresLiterals.format(value, databaseType)
+}
+
+func (postgresDialect) DumpPreamble() []string  { return nil }
+func (postgresDialect) DumpPostamble() []string { return nil }
+
+// SnapshotOptions asks for REPEATABLE READ, under which every query of the
+// transaction sees the snapshot taken by its first. CockroachDB runs it as
+// SERIALIZABLE, which does the same.
+func (postgresDialect) SnapshotOptions(q Queryer) (*sql.TxOptions, error) {
+	return &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}, nil
+}
+
+// BulkLoad streams rows with COPY FROM STDIN.
+func (postgresDialect) BulkLoad(tx *sql.Tx, tableName string, columns []string) (RowWriter, error) {
+	query := pq.CopyIn(tableName, columns...)
+	if schema, table, ok := strings.Cut(tableName, "."); ok {
+		query = pq.CopyInSchema(schema, table, columns...)
+	}
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return copyWriter{stmt: stmt}, nil
+}
+
+// copyWriter sends every row to a COPY statement; lib/pq buffers them and
+// reports most errors only when the copy is finished.
+type copyWriter struct {
+	stmt *sql.Stmt
+}
+
+func (w copyWriter) WriteRow(values []interface{}) error {
+	_, err := w.stmt.Exec(values...)
+	return err
+}
+
+func (w copyWriter) Close() error {
+	_, err := w.stmt.Exec()
+	if closeErr := w.stmt.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
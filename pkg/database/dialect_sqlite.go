@@ -0,0 +1,271 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"migr8/pkg/config"
+)
+
+// sqliteDialect serves both SQLite drivers. "sqlite3" uses mattn/go-sqlite3
+// when the binary is built with cgo and falls back to the pure-Go
+// modernc.org/sqlite driver otherwise; "sqlite" always uses the pure-Go one.
+type sqliteDialect struct {
+	name       string
+	driverName string
+}
+
+func init() {
+	RegisterDialect(sqliteDialect{name: "sqlite3", driverName: cgoSQLiteDriver})
+	RegisterDialect(sqliteDialect{name: "sqlite", driverName: "sqlite"})
+}
+
+func (d sqliteDialect) Name() string       { return d.name }
+func (d sqliteDialect) DriverName() string { return d.driverName }
+
+func (sqliteDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (sqliteDialect) QuoteIdentifier(name string) string {
+	return quoteParts(name, `"`, `"`)
+}
+
+func (sqliteDialect) Statements(r io.Reader) *StatementScanner {
+	return NewStatementScanner(r)
+}
+
+func (sqliteDialect) MigrationsTableDDL(tableName string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				filename TEXT NOT NULL UNIQUE,
+				applied_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				checksum TEXT NOT NULL
+			)`, tableName),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s(filename)", indexName(tableName), tableName),
+	}
+}
+
+func (d sqliteDialect) TruncateTableSQL(tableName string) string {
+	return fmt.Sprintf("DELETE FROM %s", d.QuoteIdentifier(tableName))
+}
+
+func (d sqliteDialect) UpsertSQL(tableName string, columns, keyColumns []string) string {
+	return InsertSQL(d, tableName, columns) + onConflictClause(d, columns, keyColumns)
+}
+
+func (d sqliteDialect) InsertIgnoreSQL(tableName string, columns, keyColumns []string) string {
+	return InsertSQL(d, tableName, columns) + onConflictClause(d, keyColumns, keyColumns)
+}
+
+// SQLite serialises writers on the database file itself, so no additional
+// lock is needed.
+func (sqliteDialect) Lock(conn *sql.Conn, name string) error   { return nil }
+func (sqliteDialect) Unlock(conn *sql.Conn, name string) error { return nil }
+
+// SQLite has no row limit, and allows 32766 parameters since 3.32.
+func (sqliteDialect) MaxInsertRows() int { return 0 }
+func (sqliteDialect) MaxParameters() int { return 32766 }
+
+// DumpCommand drives the sqlite3 shell with dot-commands. It needs the
+// tables spelled out for anything but a full dump, and filters rows of a
+// single table by selecting them in insert mode.
+func (d sqliteDialect) DumpCommand(cfg config.DatabaseConfig, scope DumpScope) (*exec.Cmd, error) {
+	if scope.IsFull() {
+		return exec.Command("sqlite3", cfg.Database, ".dump"), nil
+	}
+	if len(scope.Tables) == 0 {
+		return nil, fmt.Errorf("sqlite3 needs the tables to dump listed explicitly")
+	}
+
+	args := []string{cfg.Database}
+	switch {
+	case len(scope.Where) > 0:
+		if len(scope.Tables) != 1 {
+			return nil, fmt.Errorf("sqlite3 can only filter rows when dumping a single table")
+		}
+		table := scope.Tables[0]
+		if !scope.DataOnly {
+			args = append(args, ".schema "+sqliteShellArg(table))
+		}
+		args = append(args,
+			".mode insert "+sqliteShellArg(table),
+			fmt.Sprintf("SELECT * FROM %s WHERE %s;", d.QuoteIdentifier(table), scope.Where[table]))
+	case scope.SchemaOnly:
+		// .schema takes a single pattern.
+		for _, table := range scope.Tables {
+			args = append(args, ".schema "+sqliteShellArg(table))
+		}
+	default:
+		dump := ".dump"
+		if scope.DataOnly {
+			dump += " --data-only"
+		}
+		for _, table := range scope.Tables {
+			dump += " " + sqliteShellArg(table)
+		}
+		args = append(args, dump)
+	}
+
+	return exec.Command("sqlite3", args...), nil
+}
+
+// sqliteShellArg quotes an argument of a sqlite3 shell dot-command.
+func sqliteShellArg(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// RestoreCommand uses -bail so the shell stops at the first failing
+// statement.
+func (sqliteDialect) RestoreCommand(cfg config.DatabaseConfig) (*exec.Cmd, error) {
+	return exec.Command("sqlite3", "-bail", cfg.Database), nil
+}
+
+// SQLite databases are files, so managing them is file system work.
+
+func (sqliteDialect) DatabaseExists(cfg config.DatabaseConfig) (bool, error) {
+	if cfg.Database == ":memory:" {
+		return true, nil
+	}
+	_, err := os.Stat(cfg.Database)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (sqliteDialect) CreateDatabase(cfg config.DatabaseConfig) error {
+	file, err := os.OpenFile(cfg.Database, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func (sqliteDialect) DropDatabase(cfg config.DatabaseConfig) error {
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		if err := os.Remove(cfg.Database + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sqliteDialect) RenameDatabase(cfg config.DatabaseConfig, newName string) error {
+	if cfg.Database == ":memory:" {
+		return fmt.Errorf("cannot rename an in-memory database")
+	}
+	return os.Rename(cfg.Database, newName)
+}
+
+var sqliteLiterals = literalStyle{
+	binary:     hexLiteral("X'", "'"),
+	boolTrue:   "1",
+	boolFalse:  "0",
+	timeLayout: "2006-01-02 15:04:05.999999999-07:00",
+}
+
+func (sqliteDialect) Tables(q Queryer) ([]string, error) {
+	return scanStrings(q, `SELECT name FROM sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+}
+
+func (d sqliteDialect) Columns(q Queryer, tableName string) ([]Column, error) {
+	rows, err := q.Query(fmt.Sprintf("PRAGMA table_info(%s)", d.QuoteIdentifier(tableName)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []Column
+	for rows.Next() {
+		var (
+			c       Column
+			cid     int
+			notNull bool
+			pk      int
+		)
+		if err := rows.Scan(&cid, &c.Name, &c.Type, &notNull, &c.Default, &pk); err != nil {
+			return nil, err
+		}
+		c.Nullable = !notNull
+		c.PrimaryKey = pk > 0
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
+
+func (d sqliteDialect) ForeignKeys(q Queryer) ([]ForeignKey, error) {
+	tables, err := d.Tables(q)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []ForeignKey
+	for _, table := range tables {
+		rows, err := q.Query(fmt.Sprintf("PRAGMA foreign_key_list(%s)", d.QuoteIdentifier(table)))
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var (
+				id, seq                  int
+				refTable, from           string
+				to                       sql.NullString
+				onUpdate, onDelete, mtch string
+			)
+			if err := rows.Scan(&id, &seq, &refTable, &from, &to, &onUpdate, &onDelete, &mtch); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			keys = append(keys, ForeignKey{
+				Name:             fmt.Sprintf("%s_fk%d", table, id),
+				Table:            table,
+				Column:           from,
+				ReferencedTable:  refTable,
+				ReferencedColumn: to.String,
+			})
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// TableDefinition replays the DDL SQLite stored for the table, followed by
+// its explicit indexes and triggers.
+func (d sqliteDialect) TableDefinition(q Queryer, tableName string) (*TableDefinition, error) {
+	var create string
+	if err := q.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", tableName).Scan(&create); err != nil {
+		return nil, err
+	}
+
+	after, err := scanStrings(q, `SELECT sql FROM sqlite_master
+		WHERE type IN ('index', 'trigger') AND tbl_name = ? AND sql IS NOT NULL
+		ORDER BY type, name`, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TableDefinition{
+		Drop:   fmt.Sprintf("DROP TABLE IF EXISTS %s", d.QuoteIdentifier(tableName)),
+		Create: []string{create},
+		After:  after,
+	}, nil
+}
+
+func (sqliteDialect) Literal(value interface{}, databaseType string) string {
+	return sqliteLiterals.format(value, databaseType)
+}
+
+func (sqliteDialect) DumpPreamble() []string  { return []string{"PRAGMA foreign_keys = OFF"} }
+func (sqliteDialect) DumpPostamble() []string { return nil }
@@ -0,0 +1,384 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"migr8/pkg/config"
+)
+
+type sqlserverDialect struct{}
+
+func init() {
+	RegisterDialect(sqlserverDialect{})
+}
+
+func (sqlserverDialect) Name() string       { return "sqlserver" }
+func (sqlserverDialect) DriverName() string { return "sqlserver" }
+
+func (sqlserverDialect) Placeholder(n int) string {
+	return fmt.Sprintf("@p%d", n)
+}
+
+func (sqlserverDialect) QuoteIdentifier(name string) string {
+	return quoteParts(name, "[", "]")
+}
+
+// Statements splits on GO batch separators the way sqlcmd does. Each batch
+// is sent as a whole, so procedure and trigger bodies containing semicolons
+// survive intact. Scripts without GO are one batch.
+func (sqlserverDialect) Statements(r io.Reader) *StatementScanner {
+	return NewStatementScanner(r).WithBatches()
+}
+
+func (sqlserverDialect) MigrationsTableDDL(tableName string) []string {
+	return []string{
+		fmt.Sprintf(`IF OBJECT_ID(N'%s', N'U') IS NULL
+			CREATE TABLE %s (
+				id INT IDENTITY(1,1) PRIMARY KEY,
+				filename NVARCHAR(255) NOT NULL UNIQUE,
+				applied_at DATETIME2 DEFAULT SYSUTCDATETIME(),
+				checksum VARCHAR(32) NOT NULL
+			)`, sqlString(tableName), tableName),
+		fmt.Sprintf(`IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'%s' AND object_id = OBJECT_ID(N'%s'))
+			CREATE INDEX %s ON %s(filename)`,
+			indexName(tableName), sqlString(tableName), indexName(tableName), tableName),
+	}
+}
+
+// TruncateTableSQL falls back to DELETE when the table is referenced by a
+// foreign key, since SQL Server refuses to TRUNCATE such tables.
+func (d sqlserverDialect) TruncateTableSQL(tableName string) string {
+	quoted := d.QuoteIdentifier(tableName)
+	return fmt.Sprintf(`IF EXISTS (SELECT 1 FROM sys.foreign_keys WHERE referenced_object_id = OBJECT_ID(N'%s'))
+	DELETE FROM %s
+ELSE
+	TRUNCATE TABLE %s`, sqlString(tableName), quoted, quoted)
+}
+
+func (d sqlserverDialect) UpsertSQL(tableName string, columns, keyColumns []string) string {
+	return d.mergeSQL(tableName, columns, keyColumns, true)
+}
+
+func (d sqlserverDialect) InsertIgnoreSQL(tableName string, columns, keyColumns []string) string {
+	return d.mergeSQL(tableName, columns, keyColumns, false)
+}
+
+// mergeSQL inserts a row unless one with the same key exists, which is
+// updated when update is set.
+func (d sqlserverDialect) mergeSQL(tableName string, columns, keyColumns []string, update bool) string {
+	keys := make(map[string]bool, len(keyColumns))
+	for _, key := range keyColumns {
+		keys[key] = true
+	}
+
+	quoted := make([]string, len(columns))
+	placeholders := make([]string, len(columns))
+	sourceColumns := make([]string, len(columns))
+	var updates []string
+	for i, column := range columns {
+		quoted[i] = d.QuoteIdentifier(column)
+		placeholders[i] = d.Placeholder(i + 1)
+		sourceColumns[i] = "source." + quoted[i]
+		if update && !keys[column] {
+			updates = append(updates, fmt.Sprintf("target.%s = source.%s", quoted[i], quoted[i]))
+		}
+	}
+
+	var matches []string
+	for _, key := range keyColumns {
+		q := d.QuoteIdentifier(key)
+		matches = append(matches, fmt.Sprintf("target.%s = source.%s", q, q))
+	}
+
+	query := fmt.Sprintf("MERGE INTO %s AS target USING (VALUES (%s)) AS source (%s) ON %s",
+		d.QuoteIdentifier(tableName),
+		strings.Join(placeholders, ", "),
+		strings.Join(quoted, ", "),
+		strings.Join(matches, " AND "))
+	if len(updates) > 0 {
+		query += " WHEN MATCHED THEN UPDATE SET " + strings.Join(updates, ", ")
+	}
+	query += fmt.Sprintf(" WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s);",
+		strings.Join(quoted, ", "), strings.Join(sourceColumns, ", "))
+
+	return query
+}
+
+// A table value constructor takes at most 1000 rows, and a request at most
+// 2100 parameters.
+func (sqlserverDialect) MaxInsertRows() int { return 1000 }
+func (sqlserverDialect) MaxParameters() int { return 2100 }
+
+func (sqlserverDialect) LimitSQL(query string, limit int) string {
+	return strings.Replace(query, "SELECT ", fmt.Sprintf("SELECT TOP (%d) ", limit), 1)
+}
+
+// IdentityInsertSQL toggles IDENTITY_INSERT, but only when one of the
+// supplied columns is the table's identity column; enabling it otherwise
+// would make inserts that rely on the generated value fail.
+func (d sqlserverDialect) IdentityInsertSQL(tableName string, columns []string, enable bool) string {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = "N'" + sqlString(column) + "'"
+	}
+
+	state := "OFF"
+	if enable {
+		state = "ON"
+	}
+
+	return fmt.Sprintf(`IF EXISTS (SELECT 1 FROM sys.identity_columns WHERE object_id = OBJECT_ID(N'%s') AND name IN (%s))
+	SET IDENTITY_INSERT %s %s`, sqlString(tableName), strings.Join(names, ", "), d.QuoteIdentifier(tableName), state)
+}
+
+func (sqlserverDialect) Lock(conn *sql.Conn, name string) error {
+	var result int
+	query := `DECLARE @result INT;
+EXEC @result = sp_getapplock @Resource = @p1, @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = -1;
+SELECT @result`
+	if err := conn.QueryRowContext(context.Background(), query, name).Scan(&result); err != nil {
+		return err
+	}
+	if result < 0 {
+		return fmt.Errorf("sp_getapplock returned %d", result)
+	}
+	return nil
+}
+
+func (sqlserverDialect) Unlock(conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(context.Background(),
+		"EXEC sp_releaseapplock @Resource = @p1, @LockOwner = 'Session'", name)
+	return err
+}
+
+// SQL Server ships no tool that streams a logical dump to stdout; BACKUP
+// DATABASE writes .bak files on the server host instead.
+func (sqlserverDialect) DumpCommand(cfg config.DatabaseConfig, scope DumpScope) (*exec.Cmd, error) {
+	return nil, fmt.Errorf("no streaming dump tool available for sqlserver")
+}
+
+func (sqlserverDialect) RestoreCommand(cfg config.DatabaseConfig) (*exec.Cmd, error) {
+	return nil, fmt.Errorf("no streaming restore tool available for sqlserver")
+}
+
+func (d sqlserverDialect) DatabaseExists(cfg config.DatabaseConfig) (bool, error) {
+	return existsMaintenance(d, cfg, "master", `SELECT COUNT(*) FROM sys.databases WHERE name = @p1`, cfg.Database)
+}
+
+func (d sqlserverDialect) CreateDatabase(cfg config.DatabaseConfig) error {
+	return execMaintenance(d, cfg, "master", "CREATE DATABASE "+d.QuoteIdentifier(cfg.Database))
+}
+
+func (d sqlserverDialect) DropDatabase(cfg config.DatabaseConfig) error {
+	return execMaintenance(d, cfg, "master", "DROP DATABASE IF EXISTS "+d.QuoteIdentifier(cfg.Database))
+}
+
+// sqlString escapes a value for use inside an N'...' literal.
+func sqlString(value string) string {
+	return strings.ReplaceAll(value, "'", "''")
+}
+
+var sqlserverLiterals = literalStyle{
+	binary:          hexLiteral("0x", ""),
+	boolTrue:        "1",
+	boolFalse:       "0",
+	timeLayout:      "2006-01-02T15:04:05.9999999Z07:00",
+	nationalStrings: true,
+}
+
+func (sqlserverDialect) BatchSeparator() string { return "GO" }
+
+func (sqlserverDialect) Tables(q Queryer) ([]string, error) {
+	return scanStrings(q, `SELECT TABLE_NAME FROM INFORMATION_SCHEMA.TABLES
+		WHERE TABLE_TYPE = 'BASE TABLE' AND TABLE_SCHEMA = SCHEMA_NAME() ORDER BY TABLE_NAME`)
+}
+
+func (sqlserverDialect) Columns(q Queryer, tableName string) ([]Column, error) {
+	columns, _, err := sqlserverColumns(q, tableName)
+	return columns, err
+}
+
+// sqlserverColumns also reports which column, if any, is the identity.
+func sqlserverColumns(q Queryer, tableName string) ([]Column, string, error) {
+	rows, err := q.Query(`SELECT c.COLUMN_NAME, c.DATA_TYPE, c.CHARACTER_MAXIMUM_LENGTH,
+			c.NUMERIC_PRECISION, c.NUMERIC_SCALE, c.IS_NULLABLE, c.COLUMN_DEFAULT,
+			CASE WHEN pk.COLUMN_NAME IS NULL THEN 0 ELSE 1 END,
+			COLUMNPROPERTY(OBJECT_ID(c.TABLE_SCHEMA + '.' + c.TABLE_NAME), c.COLUMN_NAME, 'IsIdentity')
+		FROM INFORMATION_SCHEMA.COLUMNS c
+		LEFT JOIN (
+			SELECT k.TABLE_NAME, k.COLUMN_NAME FROM INFORMATION_SCHEMA.KEY_COLUMN_USAGE k
+			JOIN INFORMATION_SCHEMA.TABLE_CONSTRAINTS t ON t.CONSTRAINT_NAME = k.CONSTRAINT_NAME
+			WHERE t.CONSTRAINT_TYPE = 'PRIMARY KEY'
+		) pk ON pk.TABLE_NAME = c.TABLE_NAME AND pk.COLUMN_NAME = c.COLUMN_NAME
+		WHERE c.TABLE_SCHEMA = SCHEMA_NAME() AND c.TABLE_NAME = @p1
+		ORDER BY c.ORDINAL_POSITION`, tableName)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var columns []Column
+	var identity string
+	for rows.Next() {
+		var (
+			c                   Column
+			dataType            string
+			length              sql.NullInt64
+			precision, scale    sql.NullInt64
+			nullable            string
+			primary, isIdentity int
+		)
+		if err := rows.Scan(&c.Name, &dataType, &length, &precision, &scale, &nullable, &c.Default, &primary, &isIdentity); err != nil {
+			return nil, "", err
+		}
+
+		c.Type = sqlserverColumnType(dataType, length, precision, scale)
+		c.Nullable = nullable == "YES"
+		c.PrimaryKey = primary == 1
+		if isIdentity == 1 {
+			identity = c.Name
+		}
+		columns = append(columns, c)
+	}
+	return columns, identity, rows.Err()
+}
+
+// sqlserverColumnType adds the length or precision to the types that take
+// one. INFORMATION_SCHEMA also reports a length for text, ntext and image,
+// but SQL Server rejects those types with a length.
+func sqlserverColumnType(dataType string, length, precision, scale sql.NullInt64) string {
+	switch strings.ToLower(dataType) {
+	case "char", "varchar", "nchar", "nvarchar", "binary", "varbinary":
+		if length.Valid && length.Int64 == -1 {
+			return dataType + "(max)"
+		}
+		if length.Valid {
+			return fmt.Sprintf("%s(%d)", dataType, length.Int64)
+		}
+	case "decimal", "numeric":
+		if precision.Valid {
+			return fmt.Sprintf("%s(%d,%d)", dataType, precision.Int64, scale.Int64)
+		}
+	}
+	return dataType
+}
+
+func (sqlserverDialect) ForeignKeys(q Queryer) ([]ForeignKey, error) {
+	rows, err := q.Query(`SELECT fk.name, OBJECT_NAME(fk.parent_object_id),
+			COL_NAME(fkc.parent_object_id, fkc.parent_column_id),
+			OBJECT_NAME(fk.referenced_object_id),
+			COL_NAME(fkc.referenced_object_id, fkc.referenced_column_id)
+		FROM sys.foreign_keys fk
+		JOIN sys.foreign_key_columns fkc ON fkc.constraint_object_id = fk.object_id
+		WHERE SCHEMA_NAME(fk.schema_id) = SCHEMA_NAME()
+		ORDER BY fk.name, fkc.constraint_column_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []ForeignKey
+	for rows.Next() {
+		var fk ForeignKey
+		if err := rows.Scan(&fk.Name, &fk.Table, &fk.Column, &fk.ReferencedTable, &fk.ReferencedColumn); err != nil {
+			return nil, err
+		}
+		keys = append(keys, fk)
+	}
+	return keys, rows.Err()
+}
+
+// TableDefinition synthesises CREATE TABLE from INFORMATION_SCHEMA, since
+// SQL Server has no server-side equivalent of SHOW CREATE TABLE. Foreign
+// keys are added once every table is loaded.
+func (d sqlserverDialect) TableDefinition(q Queryer, tableName string) (*TableDefinition, error) {
+	columns, identity, err := sqlserverColumns(q, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	quoted := d.QuoteIdentifier(tableName)
+	def := &TableDefinition{
+		Drop: fmt.Sprintf("DROP TABLE IF EXISTS %s", quoted),
+	}
+
+	var lines, primary []string
+	for _, c := range columns {
+		line := fmt.Sprintf("%s %s", d.QuoteIdentifier(c.Name), c.Type)
+		if c.Name == identity {
+			line += " IDENTITY(1,1)"
+		}
+		if c.Nullable {
+			line += " NULL"
+		} else {
+			line += " NOT NULL"
+		}
+		if c.Default.Valid {
+			line += " DEFAULT " + c.Default.String
+		}
+		lines = append(lines, line)
+		if c.PrimaryKey {
+			primary = append(primary, d.QuoteIdentifier(c.Name))
+		}
+	}
+	if len(primary) > 0 {
+		lines = append(lines, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(primary, ", ")))
+	}
+	def.Create = []string{fmt.Sprintf("CREATE TABLE %s (\n\t%s\n)", quoted, strings.Join(lines, ",\n\t"))}
+
+	keys, err := d.ForeignKeys(q)
+	if err != nil {
+		return nil, err
+	}
+	// Composite keys come back as one row per column; group them by name.
+	var names []string
+	grouped := make(map[string][]ForeignKey)
+	for _, fk := range keys {
+		if fk.Table != tableName {
+			continue
+		}
+		if _, ok := grouped[fk.Name]; !ok {
+			names = append(names, fk.Name)
+		}
+		grouped[fk.Name] = append(grouped[fk.Name], fk)
+	}
+	for _, name := range names {
+		var from, to []string
+		for _, fk := range grouped[name] {
+			from = append(from, d.QuoteIdentifier(fk.Column))
+			to = append(to, d.QuoteIdentifier(fk.ReferencedColumn))
+		}
+		def.After = append(def.After, fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)",
+			quoted, d.QuoteIdentifier(name), strings.Join(from, ", "),
+			d.QuoteIdentifier(grouped[name][0].ReferencedTable), strings.Join(to, ", ")))
+	}
+
+	return def, nil
+}
+
+func (sqlserverDialect) Literal(value interface{}, databaseType string) string {
+	return sqlserverLiterals.format(value, databaseType)
+}
+
+func (sqlserverDialect) DumpPreamble() []string  { return nil }
+func (sqlserverDialect) DumpPostamble() []string { return nil }
+
+// SnapshotOptions uses SNAPSHOT isolation when the database allows it.
+// Otherwise it falls back to SERIALIZABLE, which is just as consistent but
+// holds its locks until the transaction ends, so writers wait for the dump.
+// The driver does not take read-only transactions.
+func (sqlserverDialect) SnapshotOptions(q Queryer) (*sql.TxOptions, error) {
+	var state int
+	if err := q.QueryRow(`SELECT snapshot_isolation_state FROM sys.databases WHERE name = DB_NAME()`).Scan(&state); err != nil {
+		return nil, fmt.Errorf("failed to check snapshot isolation: %w", err)
+	}
+	if state == 1 {
+		return &sql.TxOptions{Isolation: sql.LevelSnapshot}, nil
+	}
+	return &sql.TxOptions{Isolation: sql.LevelSerializable}, nil
+}
@@ -0,0 +1,405 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"migr8/pkg/config"
+)
+
+func TestGetDialect(t *testing.T) {
+	for _, name := range []string{"postgres", "mysql", "sqlite3", "sqlite", "sqlserver", "cockroach"} {
+		d, err := GetDialect(name)
+		if err != nil {
+			t.Fatalf("Expected dialect %s to be registered: %v", name, err)
+		}
+		if d.Name() != name {
+			t.Errorf("Expected dialect name %s, got %s", name, d.Name())
+		}
+	}
+
+	if _, err := GetDialect("unsupported"); err == nil {
+		t.Error("Expected error for unsupported dialect")
+	}
+}
+
+func TestInsertSQL(t *testing.T) {
+	tests := []struct {
+		driver   string
+		expected string
+	}{
+		{"postgres", `INSERT INTO "users" ("id", "name") VALUES ($1, $2)`},
+		{"mysql", "INSERT INTO `users` (`id`, `name`) VALUES (?, ?)"},
+		{"sqlite3", `INSERT INTO "users" ("id", "name") VALUES (?, ?)`},
+		{"sqlserver", `INSERT INTO [users] ([id], [name]) VALUES (@p1, @p2)`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.driver, func(t *testing.T) {
+			d, _ := GetDialect(tt.driver)
+			got := InsertSQL(d, "users", []string{"id", "name"})
+			if got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	d, _ := GetDialect("postgres")
+
+	if got := d.QuoteIdentifier("public.users"); got != `"public"."users"` {
+		t.Errorf("Expected schema-qualified quoting, got %s", got)
+	}
+
+	if got := d.QuoteIdentifier(`we"ird`); got != `"we""ird"` {
+		t.Errorf("Expected embedded quote to be escaped, got %s", got)
+	}
+}
+
+func TestUpsertSQL(t *testing.T) {
+	tests := []struct {
+		driver   string
+		expected string
+	}{
+		{"postgres", `ON CONFLICT ("code") DO UPDATE SET "name" = excluded."name"`},
+		{"mysql", "ON DUPLICATE KEY UPDATE `name` = VALUES(`name`)"},
+		{"sqlite3", `ON CONFLICT ("code") DO UPDATE SET "name" = excluded."name"`},
+		{"sqlserver", `WHEN NOT MATCHED THEN INSERT ([code], [name]) VALUES (source.[code], source.[name]);`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.driver, func(t *testing.T) {
+			d, _ := GetDialect(tt.driver)
+			got := d.UpsertSQL("countries", []string{"code", "name"}, []string{"code"})
+			if !strings.HasSuffix(got, tt.expected) {
+				t.Errorf("Expected upsert to end with %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestInsertIgnoreSQL(t *testing.T) {
+	tests := []struct {
+		driver   string
+		expected string
+	}{
+		{"postgres", `ON CONFLICT ("code") DO NOTHING`},
+		{"cockroach", `ON CONFLICT ("code") DO NOTHING`},
+		{"mysql", "ON DUPLICATE KEY UPDATE `code` = `code`"},
+		{"sqlite", `ON CONFLICT ("code") DO NOTHING`},
+		{"sqlserver", `ON target.[code] = source.[code] WHEN NOT MATCHED THEN INSERT ([code], [name]) VALUES (source.[code], source.[name]);`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.driver, func(t *testing.T) {
+			d, _ := GetDialect(tt.driver)
+			got := d.InsertIgnoreSQL("countries", []string{"code", "name"}, []string{"code"})
+			if !strings.HasSuffix(got, tt.expected) {
+				t.Errorf("Expected insert-ignore to end with %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	d, _ := GetDialect("mysql")
+	if got := d.InsertIgnoreSQL("counters", nil, nil); got != "INSERT INTO `counters` () VALUES ()" {
+		t.Errorf("Expected a plain insert without columns, got %s", got)
+	}
+}
+
+func TestTruncateTableSQL(t *testing.T) {
+	tests := []struct {
+		driver   string
+		expected string
+	}{
+		{"postgres", `TRUNCATE TABLE "users" RESTART IDENTITY CASCADE`},
+		{"cockroach", `DELETE FROM "users"`},
+		{"mysql", "DELETE FROM `users`"},
+		{"sqlite", `DELETE FROM "users"`},
+	}
+
+	for _, tt := range tests {
+		d, _ := GetDialect(tt.driver)
+		if got := d.TruncateTableSQL("users"); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.driver, tt.expected, got)
+		}
+	}
+}
+
+func TestInsertRowsSQL(t *testing.T) {
+	d, _ := GetDialect("postgres")
+	got := InsertRowsSQL(d, "users", []string{"id", "name"}, 3)
+	expected := `INSERT INTO "users" ("id", "name") VALUES ($1, $2), ($3, $4), ($5, $6)`
+	if got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestSelectRowsSQL(t *testing.T) {
+	tests := []struct {
+		driver   string
+		where    string
+		limit    int
+		expected string
+	}{
+		{"postgres", "", 0, `SELECT * FROM "users"`},
+		{"postgres", "active", 10, `SELECT * FROM "users" WHERE active LIMIT 10`},
+		{"mysql", "id > 5", 3, "SELECT * FROM `users` WHERE id > 5 LIMIT 3"},
+		{"sqlserver", "active = 1", 10, "SELECT TOP (10) * FROM [users] WHERE active = 1"},
+	}
+
+	for _, tt := range tests {
+		d, _ := GetDialect(tt.driver)
+		if got := SelectRowsSQL(d, "users", tt.where, tt.limit); got != tt.expected {
+			t.Errorf("SelectRowsSQL(%s) = %s, want %s", tt.driver, got, tt.expected)
+		}
+	}
+}
+
+func TestBatchRows(t *testing.T) {
+	tests := []struct {
+		driver    string
+		columns   int
+		batchSize int
+		expected  int
+	}{
+		{"postgres", 5, 1000, 1000},
+		{"postgres", 100, 1000, 655},
+		{"sqlite", 50, 1000, 655},
+		{"sqlserver", 1, 5000, 1000},
+		{"sqlserver", 10, 1000, 210},
+		{"sqlserver", 3000, 1000, 1},
+	}
+
+	for _, tt := range tests {
+		d, _ := GetDialect(tt.driver)
+		if got := BatchRows(d, tt.columns, tt.batchSize); got != tt.expected {
+			t.Errorf("BatchRows(%s, %d, %d) = %d, want %d", tt.driver, tt.columns, tt.batchSize, got, tt.expected)
+		}
+	}
+}
+
+func TestLoadDataValue(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, `\N`},
+		{"a\tb\nc\\d", `a\tb\nc\\d`},
+		{[]byte("x"), "x"},
+		{true, "1"},
+		{int64(42), "42"},
+	}
+
+	for _, tt := range tests {
+		if got := loadDataValue(tt.value); got != tt.expected {
+			t.Errorf("loadDataValue(%#v) = %s, want %s", tt.value, got, tt.expected)
+		}
+	}
+}
+
+func TestMigrationsTableDDL(t *testing.T) {
+	for _, name := range Dialects() {
+		d, _ := GetDialect(name)
+		statements := d.MigrationsTableDDL("schema_migrations")
+		if len(statements) == 0 {
+			t.Errorf("Expected DDL statements for %s", name)
+		}
+		if !strings.Contains(statements[0], "schema_migrations") {
+			t.Errorf("Expected DDL for %s to reference the table, got %s", name, statements[0])
+		}
+	}
+}
+
+func TestSnapshotOptions(t *testing.T) {
+	for _, driver := range []string{"postgres", "cockroach", "mysql"} {
+		d, _ := GetDialect(driver)
+		opts, err := d.(Snapshotter).SnapshotOptions(nil)
+		if err != nil || opts.Isolation != sql.LevelRepeatableRead || !opts.ReadOnly {
+			t.Errorf("%s: expected a read-only repeatable read transaction, got %+v (%v)", driver, opts, err)
+		}
+	}
+
+	d, _ := GetDialect("sqlite")
+	if _, ok := d.(Snapshotter); ok {
+		t.Error("Expected sqlite to read a snapshot in any transaction")
+	}
+}
+
+func TestSQLServerDialect(t *testing.T) {
+	d, _ := GetDialect("sqlserver")
+
+	script := `CREATE TABLE users (id INT IDENTITY PRIMARY KEY);
+GO
+CREATE PROCEDURE touch_users AS
+BEGIN
+	UPDATE users SET id = id;
+	SELECT 1;
+END
+go
+-- nothing here
+GO 2
+`
+	batches, err := SplitStatements(d, script)
+	if err != nil {
+		t.Fatalf("Failed to split script: %v", err)
+	}
+	if len(batches) != 2 {
+		t.Fatalf("Expected 2 batches, got %d: %q", len(batches), batches)
+	}
+	if !strings.Contains(batches[1], "SELECT 1;") {
+		t.Errorf("Expected procedure body to stay in one batch, got %q", batches[1])
+	}
+
+	batches, err = SplitStatements(d, "INSERT INTO users DEFAULT VALUES\nGO 3\nSELECT 1\n")
+	if err != nil {
+		t.Fatalf("Failed to split script: %v", err)
+	}
+	if len(batches) != 4 || batches[2] != "INSERT INTO users DEFAULT VALUES" || batches[3] != "SELECT 1" {
+		t.Errorf("Expected GO 3 to repeat the batch three times, got %q", batches)
+	}
+	if _, err := SplitStatements(d, "SELECT 1\nGO 0\n"); err == nil {
+		t.Error("Expected GO 0 to be rejected")
+	}
+
+	truncate := d.TruncateTableSQL("users")
+	if !strings.Contains(truncate, "DELETE FROM [users]") || !strings.Contains(truncate, "TRUNCATE TABLE [users]") {
+		t.Errorf("Expected truncate to fall back to DELETE, got %s", truncate)
+	}
+
+	inserter, ok := d.(IdentityInserter)
+	if !ok {
+		t.Fatal("Expected sqlserver dialect to implement IdentityInserter")
+	}
+	on := inserter.IdentityInsertSQL("users", []string{"id", "name"}, true)
+	if !strings.Contains(on, "N'id', N'name'") || !strings.HasSuffix(on, "SET IDENTITY_INSERT [users] ON") {
+		t.Errorf("Unexpected identity insert SQL: %s", on)
+	}
+}
+
+func TestSQLServerColumnType(t *testing.T) {
+	n := func(v int64) sql.NullInt64 { return sql.NullInt64{Int64: v, Valid: true} }
+	var null sql.NullInt64
+
+	tests := []struct {
+		dataType                 string
+		length, precision, scale sql.NullInt64
+		want                     string
+	}{
+		{"nvarchar", n(50), null, null, "nvarchar(50)"},
+		{"varbinary", n(-1), null, null, "varbinary(max)"},
+		{"char", n(2), null, null, "char(2)"},
+		{"decimal", null, n(10), n(2), "decimal(10,2)"},
+		{"text", n(2147483647), null, null, "text"},
+		{"ntext", n(1073741823), null, null, "ntext"},
+		{"image", n(2147483647), null, null, "image"},
+		{"int", null, n(10), n(0), "int"},
+	}
+	for _, tt := range tests {
+		if got := sqlserverColumnType(tt.dataType, tt.length, tt.precision, tt.scale); got != tt.want {
+			t.Errorf("Expected %s column type %s, got %s", tt.dataType, tt.want, got)
+		}
+	}
+}
+
+func TestCockroachDialect(t *testing.T) {
+	d, _ := GetDialect("cockroach")
+
+	if d.DriverName() != "postgres" {
+		t.Errorf("Expected cockroach to use the postgres driver, got %s", d.DriverName())
+	}
+
+	ddl := strings.Join(d.MigrationsTableDDL("schema_migrations"), "\n")
+	if strings.Contains(ddl, "SERIAL") || !strings.Contains(ddl, "nextval('schema_migrations_id_seq')") {
+		t.Errorf("Expected sequence-backed history table, got %s", ddl)
+	}
+
+	if got := d.TruncateTableSQL("users"); got != `DELETE FROM "users"` {
+		t.Errorf("Expected DELETE based truncation, got %s", got)
+	}
+
+	if got := d.Placeholder(2); got != "$2" {
+		t.Errorf("Expected postgres placeholders, got %s", got)
+	}
+}
+
+func TestDumpCommandScope(t *testing.T) {
+	cfg := config.DatabaseConfig{Host: "localhost", Port: 5432, Username: "app", Database: "app"}
+
+	tests := []struct {
+		driver   string
+		scope    DumpScope
+		contains []string
+		excludes []string
+	}{
+		{"postgres", DumpScope{Tables: []string{"users"}, SchemaOnly: true}, []string{"--table users", "--schema-only", "--clean --if-exists"}, nil},
+		{"postgres", DumpScope{ExcludeTables: []string{"audit"}, DataOnly: true}, []string{"--exclude-table audit", "--data-only"}, []string{"--clean", "--if-exists"}},
+		{"mysql", DumpScope{Tables: []string{"users", "roles"}, DataOnly: true}, []string{"--no-create-info", "app users roles"}, []string{"--routines"}},
+		{"mysql", DumpScope{Tables: []string{"orders"}, Where: map[string]string{"orders": "id > 10"}}, []string{"--where=id > 10", "app orders"}, nil},
+		{"mysql", DumpScope{ExcludeTables: []string{"audit"}}, []string{"--ignore-table=app.audit", "--routines"}, nil},
+		{"sqlite3", DumpScope{Tables: []string{"users", "roles"}, DataOnly: true}, []string{`.dump --data-only "users" "roles"`}, nil},
+		{"sqlite3", DumpScope{Tables: []string{"orders"}, Where: map[string]string{"orders": "id > 10"}}, []string{`.schema "orders"`, `.mode insert "orders"`, `SELECT * FROM "orders" WHERE id > 10;`}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.driver, func(t *testing.T) {
+			d, _ := GetDialect(tt.driver)
+			cmd, err := d.DumpCommand(cfg, tt.scope)
+			if err != nil {
+				t.Fatalf("Failed to build dump command: %v", err)
+			}
+			line := strings.Join(cmd.Args, " ")
+			for _, want := range tt.contains {
+				if !strings.Contains(line, want) {
+					t.Errorf("Expected %q in %s", want, line)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(line, unwanted) {
+					t.Errorf("Did not expect %q in %s", unwanted, line)
+				}
+			}
+		})
+	}
+
+	unsupported := []struct {
+		driver string
+		scope  DumpScope
+	}{
+		{"postgres", DumpScope{Tables: []string{"orders"}, Where: map[string]string{"orders": "id > 10"}}},
+		{"mysql", DumpScope{Tables: []string{"a", "b"}, Where: map[string]string{"a": "id > 10"}}},
+		{"cockroach", DumpScope{}},
+		{"cockroach", DumpScope{Tables: []string{"users"}, SchemaOnly: true}},
+		{"sqlite3", DumpScope{ExcludeTables: []string{"audit"}}},
+	}
+	for _, tt := range unsupported {
+		d, _ := GetDialect(tt.driver)
+		if _, err := d.DumpCommand(cfg, tt.scope); err == nil {
+			t.Errorf("Expected %s to reject scope %+v", tt.driver, tt.scope)
+		}
+	}
+}
+
+func TestCockroachRestoreCommandHidesPassword(t *testing.T) {
+	cfg := config.DatabaseConfig{Host: "localhost", Port: 26257, Username: "app", Password: "s3cret", Database: "app"}
+
+	d, _ := GetDialect("cockroach")
+	cmd, err := d.RestoreCommand(cfg)
+	if err != nil {
+		t.Fatalf("Failed to build restore command: %v", err)
+	}
+	if line := strings.Join(cmd.Args, " "); strings.Contains(line, "s3cret") {
+		t.Errorf("Expected the password to stay out of the arguments, got %s", line)
+	}
+
+	var found bool
+	for _, env := range cmd.Env {
+		if strings.HasPrefix(env, "COCKROACH_URL=") {
+			found = strings.Contains(env, "app:s3cret@localhost:26257/app")
+		}
+	}
+	if !found {
+		t.Errorf("Expected COCKROACH_URL with the credentials in %v", cmd.Env)
+	}
+}
@@ -0,0 +1,214 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Queryer is satisfied by *sql.DB, *sql.Tx and *DB.
+type Queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+type Column struct {
+	Name       string
+	Type       string
+	Nullable   bool
+	Default    sql.NullString
+	PrimaryKey bool
+}
+
+type ForeignKey struct {
+	Name             string
+	Table            string
+	Column           string
+	ReferencedTable  string
+	ReferencedColumn string
+}
+
+// TableDefinition holds the statements needed to recreate a table. Drop and
+// Create run before the data is loaded; After holds constraints, indexes and
+// sequence adjustments that are cheaper or only possible once it is.
+type TableDefinition struct {
+	Drop   string
+	Create []string
+	After  []string
+}
+
+// Introspector is implemented by dialects that can describe the schema of
+// the connected database. It powers the native backup engine and anything
+// else that needs to discover tables rather than be told about them.
+type Introspector interface {
+	Tables(q Queryer) ([]string, error)
+	Columns(q Queryer, tableName string) ([]Column, error)
+	ForeignKeys(q Queryer) ([]ForeignKey, error)
+	TableDefinition(q Queryer, tableName string) (*TableDefinition, error)
+
+	// Literal renders a value scanned from a column of the given database
+	// type as a SQL literal.
+	Literal(value interface{}, databaseType string) string
+
+	// DumpPreamble and DumpPostamble wrap a logical dump, e.g. to disable
+	// foreign key checks while it is replayed.
+	DumpPreamble() []string
+	DumpPostamble() []string
+}
+
+// BatchSeparator is implemented by dialects whose scripts separate batches
+// with a line of their own, such as SQL Server's GO.
+type BatchSeparator interface {
+	BatchSeparator() string
+}
+
+// FormatStatement terminates stmt so that the dialect's StatementScanner
+// reads it back as a single statement.
+func FormatStatement(d Dialect, stmt string) string {
+	if sep, ok := d.(BatchSeparator); ok {
+		return stmt + ";\n" + sep.BatchSeparator() + "\n"
+	}
+	return stmt + ";\n"
+}
+
+// SortTablesByDependency orders tables so that every table comes after the
+// tables it references. Tables that take part in a reference cycle cannot
+// be ordered and are returned separately, sorted by name.
+func SortTablesByDependency(tables []string, foreignKeys []ForeignKey) (ordered, cyclic []string) {
+	known := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		known[table] = true
+	}
+
+	dependsOn := make(map[string]map[string]bool)
+	for _, fk := range foreignKeys {
+		if !known[fk.Table] || !known[fk.ReferencedTable] || fk.Table == fk.ReferencedTable {
+			continue
+		}
+		if dependsOn[fk.Table] == nil {
+			dependsOn[fk.Table] = make(map[string]bool)
+		}
+		dependsOn[fk.Table][fk.ReferencedTable] = true
+	}
+
+	sorted := append([]string(nil), tables...)
+	sort.Strings(sorted)
+
+	done := make(map[string]bool, len(tables))
+	for len(done) < len(sorted) {
+		progressed := false
+		for _, table := range sorted {
+			if done[table] {
+				continue
+			}
+			ready := true
+			for dep := range dependsOn[table] {
+				if !done[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				ordered = append(ordered, table)
+				done[table] = true
+				progressed = true
+			}
+		}
+		if !progressed {
+			break
+		}
+	}
+
+	for _, table := range sorted {
+		if !done[table] {
+			cyclic = append(cyclic, table)
+		}
+	}
+	return ordered, cyclic
+}
+
+// literalStyle describes how a dialect spells the literals that differ
+// between engines.
+type literalStyle struct {
+	binary           func([]byte) string
+	boolTrue         string
+	boolFalse        string
+	timeLayout       string
+	backslashEscapes bool
+	nationalStrings  bool
+}
+
+func (ls literalStyle) format(value interface{}, databaseType string) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if v {
+			return ls.boolTrue
+		}
+		return ls.boolFalse
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		return ls.quote(v.Format(ls.timeLayout))
+	case []byte:
+		if isBinaryType(databaseType) {
+			return ls.binary(v)
+		}
+		return ls.quote(string(v))
+	case string:
+		return ls.quote(v)
+	default:
+		return ls.quote(fmt.Sprint(v))
+	}
+}
+
+func (ls literalStyle) quote(s string) string {
+	if ls.backslashEscapes {
+		s = strings.ReplaceAll(s, `\`, `\\`)
+	}
+	s = "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	if ls.nationalStrings {
+		s = "N" + s
+	}
+	return s
+}
+
+func isBinaryType(databaseType string) bool {
+	switch strings.ToUpper(databaseType) {
+	case "BYTEA", "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB", "BINARY", "VARBINARY", "IMAGE":
+		return true
+	}
+	return false
+}
+
+func hexLiteral(prefix, suffix string) func([]byte) string {
+	return func(b []byte) string {
+		return prefix + hex.EncodeToString(b) + suffix
+	}
+}
+
+// scanStrings runs query and collects the first column of every row.
+func scanStrings(q Queryer, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
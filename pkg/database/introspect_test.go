@@ -0,0 +1,58 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSortTablesByDependency(t *testing.T) {
+	tables := []string{"comments", "posts", "users", "a", "b"}
+	keys := []ForeignKey{
+		{Table: "comments", ReferencedTable: "posts"},
+		{Table: "comments", ReferencedTable: "users"},
+		{Table: "posts", ReferencedTable: "users"},
+		{Table: "users", ReferencedTable: "users"},
+		{Table: "a", ReferencedTable: "b"},
+		{Table: "b", ReferencedTable: "a"},
+	}
+
+	ordered, cyclic := SortTablesByDependency(tables, keys)
+
+	if !reflect.DeepEqual(ordered, []string{"users", "posts", "comments"}) {
+		t.Errorf("Unexpected order: %v", ordered)
+	}
+	if !reflect.DeepEqual(cyclic, []string{"a", "b"}) {
+		t.Errorf("Unexpected cyclic tables: %v", cyclic)
+	}
+}
+
+func TestLiteral(t *testing.T) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		driver   string
+		value    interface{}
+		dbType   string
+		expected string
+	}{
+		{"postgres", nil, "TEXT", "NULL"},
+		{"postgres", "it's", "TEXT", "'it''s'"},
+		{"postgres", []byte{0xde, 0xad}, "BYTEA", `'\xdead'::bytea`},
+		{"postgres", true, "BOOL", "TRUE"},
+		{"postgres", ts, "TIMESTAMP", "'2023-01-02 03:04:05+00:00'"},
+		{"mysql", []byte(`a\b'c`), "VARCHAR", `'a\\b''c'`},
+		{"mysql", []byte{0x01}, "BLOB", "X'01'"},
+		{"sqlite3", int64(42), "INTEGER", "42"},
+		{"sqlserver", "héllo", "NVARCHAR", "N'héllo'"},
+		{"sqlserver", []byte{0x01}, "VARBINARY", "0x01"},
+	}
+
+	for _, tt := range tests {
+		d, _ := GetDialect(tt.driver)
+		got := d.(Introspector).Literal(tt.value, tt.dbType)
+		if got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.driver, tt.expected, got)
+		}
+	}
+}
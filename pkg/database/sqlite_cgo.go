@@ -0,0 +1,9 @@
+//go:build cgo && !purego
+
+package database
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const cgoSQLiteDriver = "sqlite3"
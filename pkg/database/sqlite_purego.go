@@ -0,0 +1,7 @@
+//go:build !cgo || purego
+
+package database
+
+// Without cgo mattn/go-sqlite3 cannot be linked, so the sqlite3 driver name
+// is served by the pure-Go implementation instead.
+const cgoSQLiteDriver = "sqlite"
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"migr8/pkg/config"
+)
+
+// TestSQLiteDrivers runs the history table and seed SQL against every
+// SQLite driver compiled into the binary.
+func TestSQLiteDrivers(t *testing.T) {
+	for _, driver := range []string{"sqlite3", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			var cfg config.Config
+			cfg.Database.Driver = driver
+			cfg.Database.Database = filepath.Join(t.TempDir(), "test.db")
+
+			db, err := NewConnection(&cfg)
+			if err != nil {
+				t.Fatalf("Failed to create connection: %v", err)
+			}
+			defer db.Close()
+
+			err = db.WithLock("migr8:test", func() error {
+				return db.CreateMigrationsTable("schema_migrations")
+			})
+			if err != nil {
+				t.Fatalf("Failed to create migrations table: %v", err)
+			}
+
+			if err := db.RecordMigration("schema_migrations", "20230101120000_init", "abc"); err != nil {
+				t.Fatalf("Failed to record migration: %v", err)
+			}
+
+			migrations, err := db.GetAppliedMigrations("schema_migrations")
+			if err != nil {
+				t.Fatalf("Failed to get applied migrations: %v", err)
+			}
+			if len(migrations) != 1 || migrations[0] != "20230101120000_init" {
+				t.Errorf("Unexpected applied migrations: %v", migrations)
+			}
+
+			if _, err := db.Exec(`CREATE TABLE countries (code TEXT PRIMARY KEY, name TEXT)`); err != nil {
+				t.Fatalf("Failed to create table: %v", err)
+			}
+
+			columns := []string{"code", "name"}
+			if _, err := db.Exec(InsertSQL(db.Dialect, "countries", columns), "NL", "Holland"); err != nil {
+				t.Fatalf("Failed to insert row: %v", err)
+			}
+			if _, err := db.Exec(db.Dialect.UpsertSQL("countries", columns, []string{"code"}), "NL", "Netherlands"); err != nil {
+				t.Fatalf("Failed to upsert row: %v", err)
+			}
+
+			var name string
+			if err := db.QueryRow("SELECT name FROM countries WHERE code = 'NL'").Scan(&name); err != nil {
+				t.Fatalf("Failed to query row: %v", err)
+			}
+			if name != "Netherlands" {
+				t.Errorf("Expected upserted name Netherlands, got %s", name)
+			}
+
+			if _, err := db.Exec(db.Dialect.TruncateTableSQL("countries")); err != nil {
+				t.Fatalf("Failed to truncate table: %v", err)
+			}
+		})
+	}
+}
+
+func TestSQLiteDatabaseAdmin(t *testing.T) {
+	cfg := config.DatabaseConfig{
+		Driver:   "sqlite",
+		Database: filepath.Join(t.TempDir(), "new.db"),
+	}
+
+	created, err := EnsureDatabase(cfg)
+	if err != nil || !created {
+		t.Fatalf("Expected database to be created, created=%t err=%v", created, err)
+	}
+
+	created, err = EnsureDatabase(cfg)
+	if err != nil || created {
+		t.Fatalf("Expected existing database to be left alone, created=%t err=%v", created, err)
+	}
+
+	d, _ := GetDialect("sqlite")
+	renamed := cfg.Database + ".old"
+	if err := d.(DatabaseRenamer).RenameDatabase(cfg, renamed); err != nil {
+		t.Fatalf("Failed to rename database: %v", err)
+	}
+
+	admin := d.(DatabaseAdmin)
+	if exists, _ := admin.DatabaseExists(cfg); exists {
+		t.Error("Expected renamed database to be gone from its old name")
+	}
+
+	moved := cfg
+	moved.Database = renamed
+	if err := admin.DropDatabase(moved); err != nil {
+		t.Fatalf("Failed to drop database: %v", err)
+	}
+	if exists, _ := admin.DatabaseExists(moved); exists {
+		t.Error("Expected dropped database to be gone")
+	}
+}
@@ -0,0 +1,281 @@
+package database
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// StatementScanner reads SQL statements from a stream one at a time, so
+// large scripts such as backups never have to be held in memory.
+//
+// In the default mode statements end at a semicolon outside of quotes,
+// comments and dollar-quoted bodies. In batch mode, used by SQL Server,
+// statements end at GO separator lines and semicolons are left alone, and
+// a batch ending in GO n is returned n times, as sqlcmd executes it.
+type StatementScanner struct {
+	r                *bufio.Reader
+	backslashEscapes bool
+	batches          bool
+
+	stmt    string
+	repeats int
+	err     error
+}
+
+func NewStatementScanner(r io.Reader) *StatementScanner {
+	return &StatementScanner{r: bufio.NewReader(r)}
+}
+
+// WithBackslashEscapes treats a backslash inside a quoted string as an
+// escape character, as MySQL does.
+func (s *StatementScanner) WithBackslashEscapes() *StatementScanner {
+	s.backslashEscapes = true
+	return s
+}
+
+// WithBatches switches the scanner to GO-separated batch mode.
+func (s *StatementScanner) WithBatches() *StatementScanner {
+	s.batches = true
+	return s
+}
+
+// Scan advances to the next statement. Chunks that contain only whitespace
+// and comments are skipped.
+func (s *StatementScanner) Scan() bool {
+	if s.repeats > 0 {
+		s.repeats--
+		return true
+	}
+	if s.err != nil {
+		return false
+	}
+
+	for {
+		var stmt string
+		var hasCode bool
+		var err error
+		count := 1
+		if s.batches {
+			stmt, hasCode, count, err = s.nextBatch()
+		} else {
+			stmt, hasCode, err = s.nextStatement()
+		}
+
+		if err != nil && err != io.EOF {
+			s.err = err
+			return false
+		}
+
+		if hasCode {
+			s.stmt = strings.TrimSpace(stmt)
+			s.repeats = count - 1
+			if err == io.EOF {
+				s.err = io.EOF
+			}
+			return true
+		}
+
+		if err == io.EOF {
+			s.err = io.EOF
+			return false
+		}
+	}
+}
+
+func (s *StatementScanner) Statement() string {
+	return s.stmt
+}
+
+func (s *StatementScanner) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
+
+// AbbreviateStatement shortens a statement for an error message.
+func AbbreviateStatement(stmt string) string {
+	const limit = 200
+	stmt = strings.TrimSpace(stmt)
+	if len(stmt) > limit {
+		return stmt[:limit] + "..."
+	}
+	return stmt
+}
+
+var batchSeparator = regexp.MustCompile(`(?i)^[ \t]*GO(?:[ \t]+(\d+))?[ \t]*;?[ \t]*\r?$`)
+
+// nextBatch reads up to the next GO line and returns the batch along with
+// the number of times it is to be executed.
+func (s *StatementScanner) nextBatch() (string, bool, int, error) {
+	var b strings.Builder
+	for {
+		line, err := s.r.ReadString('\n')
+		if m := batchSeparator.FindStringSubmatch(strings.TrimRight(line, "\n")); m != nil {
+			count := 1
+			if m[1] != "" {
+				n, convErr := strconv.Atoi(m[1])
+				if convErr != nil || n < 1 {
+					return "", false, 0, fmt.Errorf("invalid batch count in %q", strings.TrimSpace(line))
+				}
+				count = n
+			}
+			batch := b.String()
+			return batch, !isCommentOnly(batch), count, err
+		}
+		b.WriteString(line)
+		if err != nil {
+			batch := b.String()
+			return batch, !isCommentOnly(batch), 1, err
+		}
+	}
+}
+
+func (s *StatementScanner) nextStatement() (string, bool, error) {
+	var b strings.Builder
+	hasCode := false
+
+	for {
+		c, _, err := s.r.ReadRune()
+		if err != nil {
+			return b.String(), hasCode, err
+		}
+
+		switch {
+		case c == ';':
+			return b.String(), hasCode, nil
+
+		case c == '-' && s.peek() == '-':
+			b.WriteRune(c)
+			if err := s.copyUntil(&b, "\n"); err != nil {
+				return b.String(), hasCode, err
+			}
+
+		case c == '/' && s.peek() == '*':
+			next, _, _ := s.r.ReadRune()
+			b.WriteRune(c)
+			b.WriteRune(next)
+			if err := s.copyUntil(&b, "*/"); err != nil {
+				return b.String(), hasCode, err
+			}
+
+		case c == '\'' || c == '"' || c == '`':
+			hasCode = true
+			b.WriteRune(c)
+			if err := s.copyQuoted(&b, c); err != nil {
+				return b.String(), hasCode, err
+			}
+
+		case c == '$':
+			hasCode = true
+			b.WriteRune(c)
+			tag, ok := s.dollarTag()
+			b.WriteString(tag)
+			if ok {
+				if err := s.copyUntil(&b, "$"+tag); err != nil {
+					return b.String(), hasCode, err
+				}
+			}
+
+		default:
+			if !isSpace(c) {
+				hasCode = true
+			}
+			b.WriteRune(c)
+		}
+	}
+}
+
+func (s *StatementScanner) peek() rune {
+	c, _, err := s.r.ReadRune()
+	if err != nil {
+		return 0
+	}
+	s.r.UnreadRune()
+	return c
+}
+
+// copyUntil copies runes up to and including terminator.
+func (s *StatementScanner) copyUntil(b *strings.Builder, terminator string) error {
+	for {
+		c, _, err := s.r.ReadRune()
+		if err != nil {
+			return err
+		}
+		b.WriteRune(c)
+		if strings.HasSuffix(b.String(), terminator) {
+			return nil
+		}
+	}
+}
+
+func (s *StatementScanner) copyQuoted(b *strings.Builder, quote rune) error {
+	for {
+		c, _, err := s.r.ReadRune()
+		if err != nil {
+			return fmt.Errorf("unterminated quoted string: %w", io.ErrUnexpectedEOF)
+		}
+		b.WriteRune(c)
+
+		if c == '\\' && s.backslashEscapes && quote != '`' {
+			next, _, err := s.r.ReadRune()
+			if err != nil {
+				return fmt.Errorf("unterminated quoted string: %w", io.ErrUnexpectedEOF)
+			}
+			b.WriteRune(next)
+			continue
+		}
+
+		// A doubled quote is an escaped quote and keeps the string open.
+		if c == quote {
+			if s.peek() == quote {
+				next, _, _ := s.r.ReadRune()
+				b.WriteRune(next)
+				continue
+			}
+			return nil
+		}
+	}
+}
+
+// dollarTag reads the remainder of a PostgreSQL dollar-quote opener such as
+// $$ or $body$. Positional parameters like $1 are not tags.
+func (s *StatementScanner) dollarTag() (string, bool) {
+	var tag strings.Builder
+	for i := 0; ; i++ {
+		c, _, err := s.r.ReadRune()
+		if err != nil {
+			return tag.String(), false
+		}
+		if c == '$' {
+			tag.WriteRune(c)
+			return tag.String(), true
+		}
+		isLetter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		isDigit := c >= '0' && c <= '9'
+		if !isLetter && !(isDigit && i > 0) {
+			s.r.UnreadRune()
+			return tag.String(), false
+		}
+		tag.WriteRune(c)
+	}
+}
+
+func isSpace(c rune) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// SplitStatements splits an in-memory script using the dialect's rules.
+func SplitStatements(d Dialect, script string) ([]string, error) {
+	scanner := d.Statements(strings.NewReader(script))
+
+	var statements []string
+	for scanner.Scan() {
+		statements = append(statements, scanner.Statement())
+	}
+	return statements, scanner.Err()
+}
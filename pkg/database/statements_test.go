@@ -0,0 +1,67 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	d, _ := GetDialect("postgres")
+
+	script := `-- Migration: create users
+-- Created: 2023-01-01
+CREATE TABLE users (id INTEGER PRIMARY KEY, note TEXT DEFAULT 'a;b');
+
+-- trailing comment only; with a semicolon
+;
+/* block; comment */
+CREATE FUNCTION touch() RETURNS trigger AS $body$
+BEGIN
+	NEW.note := 'x';
+	RETURN NEW;
+END
+$body$ LANGUAGE plpgsql;
+INSERT INTO users (id, note) VALUES ($1, 'it''s; fine')
+`
+	statements, err := SplitStatements(d, script)
+	if err != nil {
+		t.Fatalf("Failed to split script: %v", err)
+	}
+
+	if len(statements) != 3 {
+		t.Fatalf("Expected 3 statements, got %d: %q", len(statements), statements)
+	}
+
+	if !strings.HasSuffix(statements[0], "note TEXT DEFAULT 'a;b')") {
+		t.Errorf("Expected leading comments to be kept with the statement, got %q", statements[0])
+	}
+
+	if !strings.Contains(statements[1], "RETURN NEW;") {
+		t.Errorf("Expected dollar-quoted body to stay intact, got %q", statements[1])
+	}
+
+	if !strings.HasSuffix(statements[2], "'it''s; fine')") {
+		t.Errorf("Expected escaped quote to be preserved, got %q", statements[2])
+	}
+}
+
+func TestSplitStatementsBackslashEscapes(t *testing.T) {
+	d, _ := GetDialect("mysql")
+
+	statements, err := SplitStatements(d, `INSERT INTO t VALUES ('a\';b'); SELECT 1;`)
+	if err != nil {
+		t.Fatalf("Failed to split script: %v", err)
+	}
+
+	if len(statements) != 2 {
+		t.Fatalf("Expected 2 statements, got %d: %q", len(statements), statements)
+	}
+}
+
+func TestSplitStatementsUnterminatedString(t *testing.T) {
+	d, _ := GetDialect("postgres")
+
+	if _, err := SplitStatements(d, "INSERT INTO t VALUES ('oops);"); err == nil {
+		t.Error("Expected error for unterminated string")
+	}
+}
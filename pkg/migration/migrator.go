@@ -3,7 +3,6 @@ package migration
 import (
 	"database/sql"
 	"fmt"
-	"strings"
 
 	"migr8/internal/models"
 	"migr8/pkg/config"
@@ -32,6 +31,10 @@ func (m *Migrator) Close() error {
 }
 
 func (m *Migrator) Up() error {
+	return m.db.WithLock(m.lockName(), m.up)
+}
+
+func (m *Migrator) up() error {
 	if err := m.db.CreateMigrationsTable(m.config.Migration.Table); err != nil {
 		return fmt.Errorf("failed to create migrations table: %w", err)
 	}
@@ -52,10 +55,24 @@ func (m *Migrator) Up() error {
 		return nil
 	}
 
+	restorePoint := ""
+	if m.config.Migration.BackupBeforeUp {
+		fmt.Println("Taking pre-migration backup...")
+		info, err := m.backupBeforeUp(appliedMigrations, pendingMigrations)
+		if err != nil {
+			return fmt.Errorf("pre-migration backup failed, no migrations applied: %w", err)
+		}
+		restorePoint = info.Filename
+		fmt.Printf("Pre-migration backup: %s\n", restorePoint)
+	}
+
 	fmt.Printf("Applying %d pending migrations...\n", len(pendingMigrations))
 
 	for _, migration := range pendingMigrations {
 		if err := m.applyMigration(migration); err != nil {
+			if restorePoint != "" {
+				fmt.Printf("To return to the state before this run: migr8 migrate restore-point %s\n", restorePoint)
+			}
 			return fmt.Errorf("failed to apply migration %s: %w", migration.Filename, err)
 		}
 		fmt.Printf("Applied migration: %s\n", migration.Filename)
@@ -66,6 +83,12 @@ func (m *Migrator) Up() error {
 }
 
 func (m *Migrator) Down(steps int) error {
+	return m.db.WithLock(m.lockName(), func() error {
+		return m.down(steps)
+	})
+}
+
+func (m *Migrator) down(steps int) error {
 	appliedMigrations, err := m.db.GetAppliedMigrations(m.config.Migration.Table)
 	if err != nil {
 		return fmt.Errorf("failed to get applied migrations: %w", err)
@@ -160,13 +183,12 @@ func (m *Migrator) applyMigration(migration models.Migration) error {
 	}
 	defer tx.Rollback()
 
-	statements := strings.Split(migration.Up, ";")
-	for _, stmt := range statements {
-		stmt = strings.TrimSpace(stmt)
-		if stmt == "" || strings.HasPrefix(stmt, "--") {
-			continue
-		}
+	statements, err := database.SplitStatements(m.db.Dialect, migration.Up)
+	if err != nil {
+		return fmt.Errorf("failed to parse migration: %w", err)
+	}
 
+	for _, stmt := range statements {
 		if _, err := tx.Exec(stmt); err != nil {
 			return fmt.Errorf("failed to execute statement '%s': %w", stmt, err)
 		}
@@ -186,13 +208,12 @@ func (m *Migrator) rollbackMigration(migration models.Migration) error {
 	}
 	defer tx.Rollback()
 
-	statements := strings.Split(migration.Down, ";")
-	for _, stmt := range statements {
-		stmt = strings.TrimSpace(stmt)
-		if stmt == "" || strings.HasPrefix(stmt, "--") {
-			continue
-		}
+	statements, err := database.SplitStatements(m.db.Dialect, migration.Down)
+	if err != nil {
+		return fmt.Errorf("failed to parse migration: %w", err)
+	}
 
+	for _, stmt := range statements {
 		if _, err := tx.Exec(stmt); err != nil {
 			return fmt.Errorf("failed to execute statement '%s': %w", stmt, err)
 		}
@@ -206,23 +227,13 @@ func (m *Migrator) rollbackMigration(migration models.Migration) error {
 }
 
 func (m *Migrator) recordMigrationInTx(tx *sql.Tx, migration models.Migration) error {
-	query := fmt.Sprintf("INSERT INTO %s (filename, checksum) VALUES (?, ?)", m.config.Migration.Table)
-	
-	if m.db.Driver == "postgres" {
-		query = fmt.Sprintf("INSERT INTO %s (filename, checksum) VALUES ($1, $2)", m.config.Migration.Table)
-	}
-	
-	_, err := tx.Exec(query, migration.Filename, migration.Checksum)
-	return err
+	return m.db.RecordMigrationWith(tx, m.config.Migration.Table, migration.Filename, migration.Checksum)
 }
 
 func (m *Migrator) removeMigrationInTx(tx *sql.Tx, filename string) error {
-	query := fmt.Sprintf("DELETE FROM %s WHERE filename = ?", m.config.Migration.Table)
-	
-	if m.db.Driver == "postgres" {
-		query = fmt.Sprintf("DELETE FROM %s WHERE filename = $1", m.config.Migration.Table)
-	}
-	
-	_, err := tx.Exec(query, filename)
-	return err
-}
\ No newline at end of file
+	return m.db.RemoveMigrationWith(tx, m.config.Migration.Table, filename)
+}
+
+func (m *Migrator) lockName() string {
+	return "migr8:" + m.config.Migration.Table
+}
@@ -0,0 +1,134 @@
+package migration
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"migr8/internal/models"
+	"migr8/pkg/backup"
+)
+
+// restorePointLabel names and tags the backups `migrate up` takes with
+// migration.backup_before_up.
+const restorePointLabel = "pre-migrate"
+
+const (
+	tagReason      = "reason"
+	tagFromVersion = "from_version"
+	tagToVersion   = "to_version"
+)
+
+// RestorePoint is a backup taken by `migrate up` before it applied the
+// migrations after FromVersion up to and including ToVersion. FromVersion is
+// empty when no migrations had been applied yet.
+type RestorePoint struct {
+	Backup      backup.BackupInfo
+	FromVersion string
+	ToVersion   string
+}
+
+// backupBeforeUp takes the restore point for applying pending on top of
+// applied.
+func (m *Migrator) backupBeforeUp(applied []string, pending []models.Migration) (*backup.BackupInfo, error) {
+	bm, err := backup.NewBackupManager(m.config)
+	if err != nil {
+		return nil, err
+	}
+	defer bm.Close()
+
+	from := ""
+	if len(applied) > 0 {
+		from = applied[len(applied)-1]
+	}
+
+	return bm.Create(backup.BackupOptions{
+		Label: restorePointLabel,
+		Tags: map[string]string{
+			tagReason:      restorePointLabel,
+			tagFromVersion: from,
+			tagToVersion:   pending[len(pending)-1].Filename,
+		},
+	})
+}
+
+// RestorePoints lists the restore points of the configured database, oldest
+// first.
+func (m *Migrator) RestorePoints() ([]RestorePoint, error) {
+	bm, err := backup.NewBackupManager(m.config)
+	if err != nil {
+		return nil, err
+	}
+	defer bm.Close()
+
+	backups, err := bm.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var points []RestorePoint
+	for _, info := range backups {
+		if info.Tags[tagReason] != restorePointLabel {
+			continue
+		}
+		// Storage may be shared between environments.
+		if filepath.Base(info.DatabaseName) != filepath.Base(m.config.Database.Database) {
+			continue
+		}
+		points = append(points, RestorePoint{
+			Backup:      info,
+			FromVersion: info.Tags[tagFromVersion],
+			ToVersion:   info.Tags[tagToVersion],
+		})
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Backup.CreatedAt.Before(points[j].Backup.CreatedAt)
+	})
+	return points, nil
+}
+
+// FindRestorePoint picks the restore point ref refers to: the name (or a
+// unique prefix of the name) of its backup, or a migration, by file name or
+// timestamp, in which case it is the latest restore point taken before
+// that migration was applied. An empty ref selects the latest restore point.
+func FindRestorePoint(points []RestorePoint, ref string) (*RestorePoint, error) {
+	if len(points) == 0 {
+		return nil, fmt.Errorf("no restore points found; set migration.backup_before_up to take one on every migrate up")
+	}
+	if ref == "" {
+		return &points[len(points)-1], nil
+	}
+
+	var byName []int
+	for i, point := range points {
+		if point.Backup.Filename == ref {
+			return &points[i], nil
+		}
+		if strings.HasPrefix(point.Backup.Filename, ref) {
+			byName = append(byName, i)
+		}
+	}
+	switch len(byName) {
+	case 0:
+	case 1:
+		return &points[byName[0]], nil
+	default:
+		return nil, fmt.Errorf("restore point %q is ambiguous, matches %d backups", ref, len(byName))
+	}
+
+	version := migrationVersion(ref)
+	for i := len(points) - 1; i >= 0; i-- {
+		point := points[i]
+		if version > migrationVersion(point.FromVersion) && version <= migrationVersion(point.ToVersion) {
+			return &points[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no restore point found for %q", ref)
+}
+
+// migrationVersion returns the timestamp prefix of a migration file name.
+// Timestamps have a fixed width, so they compare as strings.
+func migrationVersion(name string) string {
+	return strings.SplitN(name, "_", 2)[0]
+}
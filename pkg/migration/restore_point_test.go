@@ -0,0 +1,133 @@
+package migration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"migr8/pkg/backup"
+	"migr8/pkg/config"
+)
+
+func writeMigration(t *testing.T, dir, name, up string) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(dir, name+".up.sql"), []byte(up), 0644); err != nil {
+		t.Fatalf("Failed to write migration: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".down.sql"), []byte("SELECT 1;"), 0644); err != nil {
+		t.Fatalf("Failed to write migration: %v", err)
+	}
+}
+
+func TestBackupBeforeUp(t *testing.T) {
+	dir := t.TempDir()
+	migrations := filepath.Join(dir, "migrations")
+	if err := os.Mkdir(migrations, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg config.Config
+	cfg.Database.Driver = "sqlite3"
+	cfg.Database.Database = filepath.Join(dir, "app.db")
+	cfg.Migration.Directory = migrations
+	cfg.Migration.Table = "schema_migrations"
+	cfg.Migration.BackupBeforeUp = true
+	cfg.Backup.Directory = filepath.Join(dir, "backups")
+	cfg.Backup.Engine = "native"
+
+	m, err := NewMigrator(&cfg)
+	if err != nil {
+		t.Fatalf("Failed to create migrator: %v", err)
+	}
+	defer m.Close()
+
+	writeMigration(t, migrations, "20240101000000_users", "CREATE TABLE users (id INTEGER PRIMARY KEY);")
+	if err := m.Up(); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+
+	writeMigration(t, migrations, "20240201000000_posts", "CREATE TABLE posts (id INTEGER PRIMARY KEY);")
+	writeMigration(t, migrations, "20240301000000_broken", "CREATE TABLE nope (;")
+	if err := m.Up(); err == nil {
+		t.Fatal("Expected the broken migration to fail")
+	}
+
+	points, err := m.RestorePoints()
+	if err != nil {
+		t.Fatalf("Failed to list restore points: %v", err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("Expected 2 restore points, got %+v", points)
+	}
+	if points[0].FromVersion != "" || points[0].ToVersion != "20240101000000_users" {
+		t.Errorf("Unexpected first restore point %+v", points[0])
+	}
+	if points[1].FromVersion != "20240101000000_users" || points[1].ToVersion != "20240301000000_broken" {
+		t.Errorf("Unexpected second restore point %+v", points[1])
+	}
+
+	point, err := FindRestorePoint(points, "20240201000000")
+	if err != nil || point.Backup.Filename != points[1].Backup.Filename {
+		t.Fatalf("Expected the second restore point for the posts migration, got %+v (%v)", point, err)
+	}
+
+	bm, err := backup.NewBackupManager(&cfg)
+	if err != nil {
+		t.Fatalf("Failed to create backup manager: %v", err)
+	}
+	defer bm.Close()
+	if _, err := bm.Restore(point.Backup.Filename, backup.RestoreOptions{}); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+
+	applied, err := m.db.GetAppliedMigrations(cfg.Migration.Table)
+	if err != nil {
+		t.Fatalf("Failed to read applied migrations: %v", err)
+	}
+	if len(applied) != 1 || applied[0] != "20240101000000_users" {
+		t.Errorf("Expected only the users migration after restoring, got %v", applied)
+	}
+}
+
+func TestFindRestorePoint(t *testing.T) {
+	point := func(name, from, to string) RestorePoint {
+		return RestorePoint{Backup: backup.BackupInfo{Filename: name}, FromVersion: from, ToVersion: to}
+	}
+	points := []RestorePoint{
+		point("app_20240101_000000_pre-migrate.sql", "", "20240101000000_a"),
+		point("app_20240201_000000_pre-migrate.sql", "20240101000000_a", "20240301000000_c"),
+		point("app_20240401_000000_pre-migrate.sql", "20240301000000_c", "20240401000000_d"),
+	}
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{"", "app_20240401_000000_pre-migrate.sql", false},
+		{"app_20240201", "app_20240201_000000_pre-migrate.sql", false},
+		{"app_2024", "", true},
+		{"20240101000000_a", "app_20240101_000000_pre-migrate.sql", false},
+		{"20240201000000", "app_20240201_000000_pre-migrate.sql", false},
+		{"20240301000000_c", "app_20240201_000000_pre-migrate.sql", false},
+		{"20240501000000", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := FindRestorePoint(points, tt.ref)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("FindRestorePoint(%q) = %s, want error", tt.ref, got.Backup.Filename)
+			}
+			continue
+		}
+		if err != nil || got.Backup.Filename != tt.want {
+			t.Errorf("FindRestorePoint(%q) = %+v, %v; want %s", tt.ref, got, err, tt.want)
+		}
+	}
+
+	if _, err := FindRestorePoint(nil, ""); err == nil {
+		t.Error("Expected an error without restore points")
+	}
+}
@@ -0,0 +1,142 @@
+package seed
+
+import (
+	"database/sql"
+	"fmt"
+
+	"migr8/pkg/database"
+)
+
+// rowWriter returns the fastest way to write rows of columns in mode.
+// Inserts use the dialect's bulk load path when the server allows it, and
+// multi-row INSERTs of the configured batch size otherwise. Upserts and
+// insert_ignore go row by row, since a key may repeat within a batch.
+func (s *Seeder) rowWriter(tx *sql.Tx, tableName, mode string, key, columns []string) (database.RowWriter, error) {
+	switch mode {
+	case ModeUpsert, ModeInsertIgnore:
+		for _, column := range key {
+			if !containsColumn(columns, column) {
+				return nil, fmt.Errorf("rows have no value for key column %s", column)
+			}
+		}
+
+		query := s.db.Dialect.InsertIgnoreSQL(tableName, columns, key)
+		if mode == ModeUpsert {
+			query = s.db.Dialect.UpsertSQL(tableName, columns, key)
+		}
+		stmt, err := tx.Prepare(query)
+		if err != nil {
+			return nil, err
+		}
+		return stmtWriter{stmt: stmt}, nil
+	}
+
+	if loader, ok := s.db.Dialect.(database.BulkLoader); ok {
+		w, err := loader.BulkLoad(tx, tableName, columns)
+		if err != database.ErrBulkLoadUnavailable {
+			return w, err
+		}
+	}
+
+	return &batchWriter{
+		tx:      tx,
+		dialect: s.db.Dialect,
+		table:   tableName,
+		columns: columns,
+		rows:    database.BatchRows(s.db.Dialect, len(columns), s.config.Seed.BatchSize),
+	}, nil
+}
+
+// writeRows sends every row next returns to w, until next returns no row.
+// It closes w, preferring its error, which usually explains why a write
+// failed, and returns the number of rows written.
+func writeRows(w database.RowWriter, next func() ([]interface{}, error)) (int, error) {
+	n := 0
+	for {
+		values, err := next()
+		if err == nil && values == nil {
+			break
+		}
+		if err == nil {
+			err = w.WriteRow(values)
+		}
+		if err != nil {
+			if closeErr := w.Close(); closeErr != nil {
+				return n, closeErr
+			}
+			return n, err
+		}
+		n++
+	}
+	return n, w.Close()
+}
+
+func containsColumn(columns []string, column string) bool {
+	for _, c := range columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// batchWriter buffers rows into multi-row INSERTs. Full batches reuse one
+// prepared statement.
+type batchWriter struct {
+	tx      *sql.Tx
+	dialect database.Dialect
+	table   string
+	columns []string
+	rows    int
+
+	stmt   *sql.Stmt
+	values []interface{}
+	n      int
+}
+
+func (w *batchWriter) WriteRow(values []interface{}) error {
+	w.values = append(w.values, values...)
+	w.n++
+	if w.n < w.rows {
+		return nil
+	}
+
+	if w.stmt == nil {
+		stmt, err := w.tx.Prepare(database.InsertRowsSQL(w.dialect, w.table, w.columns, w.rows))
+		if err != nil {
+			return err
+		}
+		w.stmt = stmt
+	}
+	_, err := w.stmt.Exec(w.values...)
+	w.values, w.n = w.values[:0], 0
+	return err
+}
+
+func (w *batchWriter) Close() error {
+	var err error
+	if w.n > 0 {
+		_, err = w.tx.Exec(database.InsertRowsSQL(w.dialect, w.table, w.columns, w.n), w.values...)
+		w.values, w.n = w.values[:0], 0
+	}
+	if w.stmt != nil {
+		if closeErr := w.stmt.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// stmtWriter executes a prepared single-row statement for every row.
+type stmtWriter struct {
+	stmt *sql.Stmt
+}
+
+func (w stmtWriter) WriteRow(values []interface{}) error {
+	_, err := w.stmt.Exec(values...)
+	return err
+}
+
+func (w stmtWriter) Close() error {
+	return w.stmt.Close()
+}
@@ -0,0 +1,265 @@
+package seed
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Column types a CSV seed can declare with types:. Untyped columns are
+// written as strings. date and datetime take an optional Go time layout
+// after a colon, such as date:02/01/2006.
+const (
+	csvString   = "string"
+	csvInt      = "int"
+	csvFloat    = "float"
+	csvBool     = "bool"
+	csvDate     = "date"
+	csvDatetime = "datetime"
+	csvJSON     = "json"
+)
+
+// datetimeLayouts are tried in turn for datetime columns without a layout.
+var datetimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999"}
+
+// csvCast converts the text of one cell.
+type csvCast func(value string) (interface{}, error)
+
+func csvTypeCast(typ string) (csvCast, error) {
+	name, layout, hasLayout := strings.Cut(typ, ":")
+	if hasLayout && name != csvDate && name != csvDatetime {
+		return nil, fmt.Errorf("type %s takes no layout", name)
+	}
+
+	switch name {
+	case csvString:
+		return func(value string) (interface{}, error) { return value, nil }, nil
+	case csvInt:
+		return func(value string) (interface{}, error) {
+			n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid int %q", value)
+			}
+			return n, nil
+		}, nil
+	case csvFloat:
+		return func(value string) (interface{}, error) {
+			f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid float %q", value)
+			}
+			return f, nil
+		}, nil
+	case csvBool:
+		return func(value string) (interface{}, error) {
+			switch strings.ToLower(strings.TrimSpace(value)) {
+			case "1", "t", "true", "y", "yes":
+				return true, nil
+			case "0", "f", "false", "n", "no":
+				return false, nil
+			}
+			return nil, fmt.Errorf("invalid bool %q", value)
+		}, nil
+	case csvDate, csvDatetime:
+		layouts := datetimeLayouts
+		if name == csvDate {
+			layouts = []string{"2006-01-02"}
+		}
+		if hasLayout {
+			layouts = []string{layout}
+		}
+		return func(value string) (interface{}, error) {
+			var err error
+			for _, layout := range layouts {
+				var t time.Time
+				if t, err = time.Parse(layout, strings.TrimSpace(value)); err == nil {
+					return t, nil
+				}
+			}
+			return nil, fmt.Errorf("invalid %s %q", name, value)
+		}, nil
+	case csvJSON:
+		return func(value string) (interface{}, error) {
+			if !json.Valid([]byte(value)) {
+				return nil, fmt.Errorf("invalid JSON")
+			}
+			return value, nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown type %q: expected %s, %s, %s, %s, %s, %s or %s",
+			typ, csvString, csvInt, csvFloat, csvBool, csvDate, csvDatetime, csvJSON)
+	}
+}
+
+// validate checks the options that do not depend on the CSV header.
+func (o CSVOptions) validate() error {
+	if err := o.SeedOptions.validate(); err != nil {
+		return err
+	}
+	if o.Delimiter != "" {
+		if r, size := utf8.DecodeRuneInString(o.Delimiter); size != len(o.Delimiter) || r == '"' || r == '\n' || r == '\r' {
+			return fmt.Errorf("invalid delimiter %q: expected a single character other than a quote or line break", o.Delimiter)
+		}
+	}
+	for column, typ := range o.Types {
+		if _, err := csvTypeCast(typ); err != nil {
+			return fmt.Errorf("column %s: %w", column, err)
+		}
+	}
+	return nil
+}
+
+// readCSVHeaderOptions reads the options a CSV file may declare in the
+// comment lines it starts with, which hold a YAML document:
+//
+//	# table: users
+//	# null: ""
+//	id,name,email
+//
+// It returns the YAML and the number of comment lines.
+func readCSVHeaderOptions(r *bufio.Reader) (string, int, error) {
+	var yamlLines []string
+	for {
+		peek, err := r.Peek(1)
+		if err == io.EOF || (err == nil && peek[0] != '#') {
+			break
+		}
+		if err != nil {
+			return "", 0, err
+		}
+
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", 0, err
+		}
+		line = strings.TrimRight(strings.TrimPrefix(line, "#"), "\r\n")
+		yamlLines = append(yamlLines, strings.TrimPrefix(line, " "))
+	}
+	if len(yamlLines) == 0 {
+		return "", 0, nil
+	}
+	return strings.Join(yamlLines, "\n") + "\n", len(yamlLines), nil
+}
+
+// csvRows reads the data rows of a CSV seed as typed values for its
+// table's columns, numbering rows and file lines for error messages.
+type csvRows struct {
+	reader   *csv.Reader
+	filename string
+	// offset is the number of option comment lines before the header.
+	offset  int
+	columns []string
+	casts   []csvCast
+	null    *string
+	row     int
+}
+
+// newCSVRows reads the header of a CSV seed and maps it onto the table's
+// columns, applying renames.
+func newCSVRows(r io.Reader, csvFile CSVSeedFile) (*csvRows, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	if csvFile.Delimiter != "" {
+		reader.Comma, _ = utf8.DecodeRuneInString(csvFile.Delimiter)
+	}
+
+	rows := &csvRows{
+		reader:   reader,
+		filename: csvFile.Filename,
+		offset:   csvFile.headerLines,
+		null:     csvFile.NullMarker,
+	}
+
+	headers, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("CSV file is empty")
+	}
+	if err != nil {
+		return nil, rows.parseError(err)
+	}
+
+	seen := make(map[string]bool, len(headers))
+	for _, header := range headers {
+		column := header
+		if renamed, ok := csvFile.Rename[header]; ok {
+			column = renamed
+		}
+		if seen[column] {
+			return nil, fmt.Errorf("%s: column %s appears more than once in the header", csvFile.Filename, column)
+		}
+		seen[column] = true
+
+		cast, err := csvTypeCast(csvString)
+		if typ, ok := csvFile.Types[column]; ok {
+			cast, err = csvTypeCast(typ)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: column %s: %w", csvFile.Filename, column, err)
+		}
+		rows.columns = append(rows.columns, column)
+		rows.casts = append(rows.casts, cast)
+	}
+
+	for header := range csvFile.Rename {
+		if !containsColumn(headers, header) {
+			return nil, fmt.Errorf("%s: renamed column %s is not in the header", csvFile.Filename, header)
+		}
+	}
+	for column := range csvFile.Types {
+		if !seen[column] {
+			return nil, fmt.Errorf("%s: typed column %s is not in the header", csvFile.Filename, column)
+		}
+	}
+
+	return rows, nil
+}
+
+// next returns the values of the next row, or nil after the last one.
+func (r *csvRows) next() ([]interface{}, error) {
+	record, err := r.reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	r.row++
+	if err != nil {
+		return nil, r.parseError(err)
+	}
+
+	line, _ := r.reader.FieldPos(0)
+	if len(record) != len(r.columns) {
+		return nil, fmt.Errorf("%s line %d (row %d): expected %d fields, got %d",
+			r.filename, r.offset+line, r.row, len(r.columns), len(record))
+	}
+
+	values := make([]interface{}, len(record))
+	for i, value := range record {
+		if r.null != nil && value == *r.null {
+			continue
+		}
+		if values[i], err = r.casts[i](value); err != nil {
+			line, _ := r.reader.FieldPos(i)
+			return nil, fmt.Errorf("%s line %d (row %d), column %s: %w",
+				r.filename, r.offset+line, r.row, r.columns[i], err)
+		}
+	}
+	return values, nil
+}
+
+func (r *csvRows) parseError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) && r.row == 0 {
+		return fmt.Errorf("%s line %d (header): %w", r.filename, r.offset+parseErr.Line, parseErr.Err)
+	}
+	if errors.As(err, &parseErr) {
+		return fmt.Errorf("%s line %d (row %d): %w", r.filename, r.offset+parseErr.Line, r.row, parseErr.Err)
+	}
+	return fmt.Errorf("failed to read CSV file: %w", err)
+}
@@ -0,0 +1,570 @@
+package seed
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+	"migr8/pkg/anonymize"
+	"migr8/pkg/database"
+)
+
+// Formats Export can write.
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// exportNullMarker stands for NULL in exported CSV files.
+const exportNullMarker = `\N`
+
+// ExportOptions controls Export.
+type ExportOptions struct {
+	// Format is yaml (the default), json or csv.
+	Format string
+	// Where filters the rows with a SQL boolean expression.
+	Where string
+	// Limit caps the number of rows; zero exports them all.
+	Limit int
+	// Mask lists columns whose values are replaced by asterisks, one per
+	// character, so the file shows their shape but not their content.
+	Mask []string
+	// Output is the file to write, by default the table name with the
+	// format's extension in the seed directory.
+	Output string
+	// Force overwrites an existing file.
+	Force bool
+	// Anonymize applies the rules in the anonymize section of the
+	// configuration to the rows before they are written.
+	Anonymize bool
+}
+
+// Export writes the rows of a table as a seed file that loads them back.
+// Rows are streamed from the database to the file, which only replaces
+// Output once every row has been written; with Anonymize, they are
+// anonymized before they reach it.
+func (s *Seeder) Export(tableName string, opts ExportOptions) error {
+	format := strings.ToLower(opts.Format)
+	if format == "" {
+		format = FormatYAML
+	}
+	extension := map[string]string{FormatYAML: ".yml", FormatJSON: ".json", FormatCSV: ".csv"}[format]
+	if extension == "" {
+		return fmt.Errorf("unknown export format %q: expected %s, %s or %s", opts.Format, FormatYAML, FormatJSON, FormatCSV)
+	}
+	if opts.Limit < 0 {
+		return fmt.Errorf("limit must not be negative")
+	}
+
+	var anonymizer *anonymize.Anonymizer
+	if opts.Anonymize {
+		var err error
+		if anonymizer, err = anonymize.New(s.config.Anonymize); err != nil {
+			return err
+		}
+	}
+
+	output := opts.Output
+	if output == "" {
+		output = filepath.Join(s.config.Seed.Directory, tableName+extension)
+	}
+	if _, err := os.Stat(output); err == nil && !opts.Force {
+		return fmt.Errorf("%s already exists; use --force to overwrite it", output)
+	}
+
+	rows, err := s.db.Query(database.SelectRowsSQL(s.db.Dialect, tableName, opts.Where, opts.Limit))
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+
+	masked := make([]bool, len(columns))
+	for _, column := range opts.Mask {
+		i := indexOf(columns, column)
+		if i < 0 {
+			return fmt.Errorf("masked column %s is not a column of %s", column, tableName)
+		}
+		masked[i] = true
+	}
+	anonymized, err := anonymizer.Table(tableName, columns)
+	if err != nil {
+		return err
+	}
+	// Masked and anonymized values are written as text, which the
+	// database converts back when they are loaded.
+	types := make([]string, len(columns))
+	for i, columnType := range columnTypes {
+		types[i] = exportType(columnType.DatabaseTypeName())
+		if masked[i] || anonymized.Anonymizes(i) {
+			types[i] = csvString
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(output), ".export-*")
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if format == FormatYAML {
+		fmt.Fprintf(w, "# Exported from the %s table on %s\n", tableName, time.Now().Format("2006-01-02 15:04:05"))
+		if opts.Where != "" {
+			fmt.Fprintf(w, "# Where: %s\n", opts.Where)
+		}
+		if opts.Limit > 0 {
+			fmt.Fprintf(w, "# Limit: %d\n", opts.Limit)
+		}
+		if len(opts.Mask) > 0 {
+			fmt.Fprintf(w, "# Masked: %s\n", strings.Join(opts.Mask, ", "))
+		}
+		if anonymized != nil {
+			fmt.Fprintf(w, "# Anonymized with the rules for %s in the anonymize configuration\n", tableName)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+
+	namedTable := seedTableName(strings.TrimSuffix(filepath.Base(output), extension)) == tableName
+	encoder, err := newSeedEncoder(w, format, tableName, namedTable, columns, types)
+	if err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	n := 0
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return fmt.Errorf("failed to read %s: %w", tableName, err)
+		}
+		for i, value := range values {
+			values[i] = exportValue(value, types[i])
+		}
+		if err := anonymized.Row(values); err != nil {
+			return fmt.Errorf("failed to anonymize row %d: %w", n+1, err)
+		}
+		for i := range values {
+			if masked[i] {
+				values[i] = maskValue(values[i])
+			}
+		}
+		if err := encoder.writeRow(values); err != nil {
+			return fmt.Errorf("failed to write row %d: %w", n+1, err)
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", tableName, err)
+	}
+
+	if err := encoder.close(); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+	if err := f.Chmod(0644); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+	if err := os.Rename(f.Name(), output); err != nil {
+		return fmt.Errorf("failed to write %s: %w", output, err)
+	}
+
+	fmt.Printf("Exported %d %s from %s to %s\n", n, plural(n, "row", "rows"), tableName, output)
+	return nil
+}
+
+func indexOf(columns []string, column string) int {
+	for i, c := range columns {
+		if c == column {
+			return i
+		}
+	}
+	return -1
+}
+
+// exportType maps a database type name onto the CSV seed type that reads
+// its values back. Exact numeric types stay strings, which the database
+// parses without losing precision.
+func exportType(databaseType string) string {
+	t := strings.ToLower(databaseType)
+	switch {
+	case strings.Contains(t, "bool") || t == "bit":
+		return csvBool
+	case strings.Contains(t, "int") && !strings.Contains(t, "interval") && !strings.Contains(t, "point"):
+		return csvInt
+	case strings.Contains(t, "float") || strings.Contains(t, "double") || t == "real":
+		return csvFloat
+	case t == "date":
+		return csvDate
+	case strings.Contains(t, "timestamp") || strings.Contains(t, "datetime"):
+		return csvDatetime
+	case strings.Contains(t, "json"):
+		return csvJSON
+	}
+	return csvString
+}
+
+// tableColumns describes the columns of a table, or returns none when it
+// does not exist. Dialects without an Introspector are asked through a
+// query that selects no rows.
+func (s *Seeder) tableColumns(tableName string) ([]database.Column, error) {
+	if introspector, ok := s.db.Dialect.(database.Introspector); ok {
+		tables, err := introspector.Tables(s.db)
+		if err != nil {
+			return nil, err
+		}
+		if indexOf(tables, tableName) < 0 {
+			return nil, nil
+		}
+		return introspector.Columns(s.db, tableName)
+	}
+
+	rows, err := s.db.Query(database.SelectRowsSQL(s.db.Dialect, tableName, "1 = 0", 0))
+	if err != nil {
+		return nil, nil
+	}
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]database.Column, len(columnTypes))
+	for i, columnType := range columnTypes {
+		nullable, _ := columnType.Nullable()
+		columns[i] = database.Column{Name: columnType.Name(), Type: columnType.DatabaseTypeName(), Nullable: nullable}
+	}
+	return columns, nil
+}
+
+// sampleValue returns a value of the column's type for row row of a seed
+// template.
+func sampleValue(column database.Column, row int) interface{} {
+	switch exportType(column.Type) {
+	case csvInt:
+		return row
+	case csvFloat:
+		return float64(row) + 0.5
+	case csvBool:
+		return row%2 == 1
+	case csvDate:
+		return fmt.Sprintf("2023-01-%02d", row)
+	case csvDatetime:
+		return fmt.Sprintf("2023-01-%02dT00:00:00Z", row)
+	case csvJSON:
+		return "{}"
+	}
+
+	t := strings.ToLower(column.Type)
+	switch {
+	case strings.Contains(t, "numeric") || strings.Contains(t, "decimal") || strings.Contains(t, "money"):
+		return float64(row) + 0.5
+	case strings.Contains(t, "uuid") || strings.Contains(t, "uniqueidentifier"):
+		return fmt.Sprintf("00000000-0000-0000-0000-%012d", row)
+	case strings.Contains(strings.ToLower(column.Name), "email"):
+		return fmt.Sprintf("sample%d@example.com", row)
+	}
+	return fmt.Sprintf("Sample %s %d", column.Name, row)
+}
+
+// describeColumn renders a column for the header of a seed template, such
+// as "email TEXT, not null".
+func describeColumn(column database.Column) string {
+	description := column.Name + " " + column.Type
+	if column.PrimaryKey {
+		description += ", primary key"
+	} else if !column.Nullable {
+		description += ", not null"
+	}
+	if column.Default.Valid {
+		description += ", default " + column.Default.String
+	}
+	return description
+}
+
+// exportValue turns a scanned value into one the seed formats can hold.
+// Drivers that return text for every type, as MySQL's does, have numbers
+// and booleans converted back according to the column type.
+func exportValue(value interface{}, typ string) interface{} {
+	if b, ok := value.([]byte); ok {
+		if !utf8.Valid(b) {
+			return append([]byte(nil), b...)
+		}
+		value = string(b)
+	}
+
+	switch v := value.(type) {
+	case string:
+		switch typ {
+		case csvInt:
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return n
+			}
+		case csvFloat:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f
+			}
+		case csvBool:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b
+			}
+		}
+	case int64:
+		if typ == csvBool {
+			return v != 0
+		}
+	case time.Time:
+		if typ == csvDate {
+			return v.Format("2006-01-02")
+		}
+	}
+	return value
+}
+
+// maskValue hides a value behind as many asterisks as its text has
+// characters. NULL stays NULL.
+func maskValue(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	return strings.Repeat("*", utf8.RuneCountInString(exportText(value)))
+}
+
+// exportText renders a value as CSV cell text.
+func exportText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return exportNullMarker
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// seedEncoder writes the rows of a seed file, one at a time.
+type seedEncoder interface {
+	writeRow(values []interface{}) error
+	// close finishes the file after the last row.
+	close() error
+}
+
+// newSeedEncoder writes the start of a seed file for the columns of a
+// table and returns the encoder for its rows. types are the CSV types of
+// the columns. CSV files only name the table when their file name does
+// not already.
+func newSeedEncoder(w io.Writer, format, tableName string, namedTable bool, columns, types []string) (seedEncoder, error) {
+	switch format {
+	case FormatYAML:
+		header := &yaml.Node{Kind: yaml.MappingNode}
+		addYAMLField(header, "name", tableName)
+		addYAMLField(header, "table", tableName)
+		addYAMLField(header, "truncate", true)
+		if err := encodeYAML(w, header, ""); err != nil {
+			return nil, err
+		}
+		return &yamlEncoder{w: w, columns: columns}, nil
+
+	case FormatJSON:
+		fmt.Fprintf(w, "{\n  \"name\": %s,\n  \"table\": %s,\n  \"truncate\": true,\n  \"data\": [", jsonString(tableName), jsonString(tableName))
+		return &jsonEncoder{w: w, columns: columns}, nil
+
+	case FormatCSV:
+		options := &yaml.Node{Kind: yaml.MappingNode}
+		if !namedTable {
+			addYAMLField(options, "table", tableName)
+		}
+		addYAMLField(options, "null_marker", exportNullMarker)
+		typed := &yaml.Node{Kind: yaml.MappingNode, Style: yaml.FlowStyle}
+		for i, column := range columns {
+			if types[i] != csvString {
+				addYAMLField(typed, column, types[i])
+			}
+		}
+		if len(typed.Content) > 0 {
+			options.Content = append(options.Content, yamlScalar("types"), typed)
+		}
+		if err := encodeYAML(w, options, "# "); err != nil {
+			return nil, err
+		}
+
+		writer := csv.NewWriter(w)
+		if err := writer.Write(columns); err != nil {
+			return nil, err
+		}
+		return &csvEncoder{w: writer, record: make([]string, len(columns))}, nil
+	}
+	return nil, fmt.Errorf("unknown seed format %q", format)
+}
+
+func yamlScalar(value interface{}) *yaml.Node {
+	var node yaml.Node
+	if err := node.Encode(value); err != nil {
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprint(value)}
+	}
+	return &node
+}
+
+func addYAMLField(mapping *yaml.Node, key string, value interface{}) {
+	mapping.Content = append(mapping.Content, yamlScalar(key), yamlScalar(value))
+}
+
+// encodeYAML writes node with two space indentation and every line
+// prefixed by indent.
+func encodeYAML(w io.Writer, node *yaml.Node, indent string) error {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(node); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+
+	for _, line := range strings.SplitAfter(buf.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		if _, err := io.WriteString(w, indent+line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// yamlEncoder writes the data list of a YAML seed, keeping the columns in
+// table order.
+type yamlEncoder struct {
+	w       io.Writer
+	columns []string
+	n       int
+}
+
+func (e *yamlEncoder) writeRow(values []interface{}) error {
+	if e.n == 0 {
+		if _, err := io.WriteString(e.w, "data:\n"); err != nil {
+			return err
+		}
+	}
+	e.n++
+
+	row := &yaml.Node{Kind: yaml.MappingNode}
+	for i, column := range e.columns {
+		addYAMLField(row, column, values[i])
+	}
+	return encodeYAML(e.w, &yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{row}}, "  ")
+}
+
+func (e *yamlEncoder) close() error {
+	if e.n == 0 {
+		_, err := io.WriteString(e.w, "data: []\n")
+		return err
+	}
+	return nil
+}
+
+// jsonEncoder writes the data array of a JSON seed, one row per line.
+type jsonEncoder struct {
+	w       io.Writer
+	columns []string
+	n       int
+}
+
+func (e *jsonEncoder) writeRow(values []interface{}) error {
+	separator := ","
+	if e.n == 0 {
+		separator = ""
+	}
+	e.n++
+
+	fields := make([]string, len(e.columns))
+	for i, column := range e.columns {
+		value, err := jsonValue(values[i])
+		if err != nil {
+			return fmt.Errorf("column %s: %w", column, err)
+		}
+		fields[i] = jsonString(column) + ": " + value
+	}
+	_, err := fmt.Fprintf(e.w, "%s\n    {%s}", separator, strings.Join(fields, ", "))
+	return err
+}
+
+func (e *jsonEncoder) close() error {
+	closing := "\n  ]\n}\n"
+	if e.n == 0 {
+		closing = "]\n}\n"
+	}
+	_, err := io.WriteString(e.w, closing)
+	return err
+}
+
+func jsonValue(value interface{}) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+func jsonString(s string) string {
+	value, _ := jsonValue(s)
+	return value
+}
+
+// csvEncoder writes the rows of a CSV seed.
+type csvEncoder struct {
+	w      *csv.Writer
+	record []string
+}
+
+func (e *csvEncoder) writeRow(values []interface{}) error {
+	for i, value := range values {
+		e.record[i] = exportText(value)
+	}
+	return e.w.Write(e.record)
+}
+
+func (e *csvEncoder) close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
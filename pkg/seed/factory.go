@@ -0,0 +1,380 @@
+package seed
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"migr8/pkg/anonymize"
+	"migr8/pkg/database"
+)
+
+// Factory generates Count rows for a YAML seed, on top of its literal Data.
+// Generated values come from a random source seeded with Seed, or with a
+// hash of the seed's file name when Seed is not set, so every run on every
+// machine produces the same rows.
+type Factory struct {
+	Count   int                  `yaml:"count"`
+	Seed    *int64               `yaml:"seed,omitempty"`
+	Columns map[string]Generator `yaml:"columns"`
+}
+
+// Generator describes how a factory fills one column. A plain scalar in
+// the seed file is a constant.
+type Generator struct {
+	Type string `yaml:"type"`
+
+	// constant
+	Value interface{} `yaml:"value,omitempty"`
+	// sequence
+	Start *int64 `yaml:"start,omitempty"`
+	Step  int64  `yaml:"step,omitempty"`
+	// number
+	Min      float64 `yaml:"min,omitempty"`
+	Max      float64 `yaml:"max,omitempty"`
+	Decimals int     `yaml:"decimals,omitempty"`
+	// date; Format defaults to the layout From is written in
+	From   string `yaml:"from,omitempty"`
+	To     string `yaml:"to,omitempty"`
+	Format string `yaml:"format,omitempty"`
+	// name: first, last or full (the default)
+	Part string `yaml:"part,omitempty"`
+	// email
+	Domain string `yaml:"domain,omitempty"`
+	// lorem
+	Words int `yaml:"words,omitempty"`
+	// enum
+	Values []interface{} `yaml:"values,omitempty"`
+	// weighted
+	Weights map[string]float64 `yaml:"weights,omitempty"`
+	// reference; Column defaults to id
+	Table  string `yaml:"table,omitempty"`
+	Column string `yaml:"column,omitempty"`
+}
+
+func (g *Generator) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		g.Type = "constant"
+		return node.Decode(&g.Value)
+	}
+
+	type plain Generator
+	return node.Decode((*plain)(g))
+}
+
+// UnmarshalYAML decodes each column itself, since yaml.v3 does not call
+// Generator.UnmarshalYAML for a null, which is a constant NULL.
+func (f *Factory) UnmarshalYAML(node *yaml.Node) error {
+	var raw struct {
+		Count   int                  `yaml:"count"`
+		Seed    *int64               `yaml:"seed"`
+		Columns map[string]yaml.Node `yaml:"columns"`
+	}
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+
+	f.Count, f.Seed = raw.Count, raw.Seed
+	f.Columns = make(map[string]Generator, len(raw.Columns))
+	for name, column := range raw.Columns {
+		var g Generator
+		if err := g.UnmarshalYAML(&column); err != nil {
+			return fmt.Errorf("factory column %s: %w", name, err)
+		}
+		f.Columns[name] = g
+	}
+	return nil
+}
+
+// generate produces the value of a column for row i, which counts from 0.
+type generate func(i int, rng *rand.Rand) interface{}
+
+// references loads the values a reference generator picks from.
+type references func(table, column string) ([]interface{}, error)
+
+// dependencies returns the tables the factory references, which must be
+// seeded first.
+func (f *Factory) dependencies() []string {
+	var tables []string
+	for _, g := range f.Columns {
+		if g.Type == "reference" {
+			tables = append(tables, g.Table)
+		}
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+func (f *Factory) columns() []string {
+	columns := make([]string, 0, len(f.Columns))
+	for column := range f.Columns {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// validate checks the factory without touching the database.
+func (f *Factory) validate() error {
+	if f.Count < 0 {
+		return fmt.Errorf("factory count must not be negative")
+	}
+	if len(f.Columns) == 0 {
+		return fmt.Errorf("factory has no columns")
+	}
+	noReferences := func(table, column string) ([]interface{}, error) {
+		return []interface{}{nil}, nil
+	}
+	for _, column := range f.columns() {
+		if _, err := f.Columns[column].compile(noReferences); err != nil {
+			return fmt.Errorf("factory column %s: %w", column, err)
+		}
+	}
+	return nil
+}
+
+// rows calls fn with every generated row, in order.
+func (f *Factory) rows(filename string, refs references, fn func(row map[string]interface{}) error) error {
+	columns := f.columns()
+	generators := make([]generate, len(columns))
+	for i, column := range columns {
+		gen, err := f.Columns[column].compile(refs)
+		if err != nil {
+			return fmt.Errorf("factory column %s: %w", column, err)
+		}
+		generators[i] = gen
+	}
+
+	seed := int64(0)
+	if f.Seed != nil {
+		seed = *f.Seed
+	} else {
+		hash := fnv.New64a()
+		hash.Write([]byte(filename))
+		seed = int64(hash.Sum64())
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	for i := 0; i < f.Count; i++ {
+		// Columns draw from the random source in name order, so adding a
+		// column only changes the values of columns after it.
+		row := make(map[string]interface{}, len(columns))
+		for j, column := range columns {
+			row[column] = generators[j](i, rng)
+		}
+		if err := fn(row); err != nil {
+			return fmt.Errorf("factory row %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (g Generator) compile(refs references) (generate, error) {
+	switch g.Type {
+	case "constant":
+		return func(int, *rand.Rand) interface{} { return g.Value }, nil
+
+	case "sequence":
+		start, step := int64(1), g.Step
+		if g.Start != nil {
+			start = *g.Start
+		}
+		if step == 0 {
+			step = 1
+		}
+		return func(i int, _ *rand.Rand) interface{} { return start + int64(i)*step }, nil
+
+	case "number":
+		if g.Max < g.Min {
+			return nil, fmt.Errorf("max %v is less than min %v", g.Max, g.Min)
+		}
+		if g.Decimals == 0 {
+			min, span := int64(g.Min), int64(g.Max)-int64(g.Min)+1
+			return func(_ int, rng *rand.Rand) interface{} { return min + rng.Int63n(span) }, nil
+		}
+		scale := 1.0
+		for i := 0; i < g.Decimals; i++ {
+			scale *= 10
+		}
+		return func(_ int, rng *rand.Rand) interface{} {
+			v := g.Min + rng.Float64()*(g.Max-g.Min)
+			return float64(int64(v*scale+0.5)) / scale
+		}, nil
+
+	case "uuid":
+		return func(_ int, rng *rand.Rand) interface{} {
+			var b [16]byte
+			rng.Read(b[:])
+			b[6] = b[6]&0x0f | 0x40
+			b[8] = b[8]&0x3f | 0x80
+			return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+		}, nil
+
+	case "name":
+		switch g.Part {
+		case "first":
+			return func(_ int, rng *rand.Rand) interface{} { return pick(rng, anonymize.FirstNames) }, nil
+		case "last":
+			return func(_ int, rng *rand.Rand) interface{} { return pick(rng, anonymize.LastNames) }, nil
+		case "", "full":
+			return func(_ int, rng *rand.Rand) interface{} {
+				return pick(rng, anonymize.FirstNames) + " " + pick(rng, anonymize.LastNames)
+			}, nil
+		default:
+			return nil, fmt.Errorf("invalid name part %q: expected first, last or full", g.Part)
+		}
+
+	case "email":
+		domain := g.Domain
+		if domain == "" {
+			domain = "example.com"
+		}
+		// The row number keeps addresses unique.
+		return func(i int, rng *rand.Rand) interface{} {
+			return fmt.Sprintf("%s.%s%d@%s", strings.ToLower(pick(rng, anonymize.FirstNames)),
+				strings.ToLower(pick(rng, anonymize.LastNames)), i+1, domain)
+		}, nil
+
+	case "lorem":
+		words := g.Words
+		if words <= 0 {
+			words = 8
+		}
+		return func(_ int, rng *rand.Rand) interface{} {
+			text := make([]string, words)
+			for i := range text {
+				text[i] = pick(rng, loremWords)
+			}
+			text[0] = strings.ToUpper(text[0][:1]) + text[0][1:]
+			return strings.Join(text, " ") + "."
+		}, nil
+
+	case "date":
+		from, layout, err := parseDate(g.From)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+		to, _, err := parseDate(g.To)
+		if err != nil {
+			return nil, fmt.Errorf("to: %w", err)
+		}
+		if to.Before(from) {
+			return nil, fmt.Errorf("to %s is before from %s", g.To, g.From)
+		}
+		if g.Format != "" {
+			layout = g.Format
+		}
+		span := int64(to.Sub(from)/time.Second) + 1
+		return func(_ int, rng *rand.Rand) interface{} {
+			return from.Add(time.Duration(rng.Int63n(span)) * time.Second).Format(layout)
+		}, nil
+
+	case "enum":
+		if len(g.Values) == 0 {
+			return nil, fmt.Errorf("enum needs values")
+		}
+		return func(_ int, rng *rand.Rand) interface{} { return g.Values[rng.Intn(len(g.Values))] }, nil
+
+	case "weighted":
+		values := make([]string, 0, len(g.Weights))
+		total := 0.0
+		for value, weight := range g.Weights {
+			if weight < 0 {
+				return nil, fmt.Errorf("weight of %q must not be negative", value)
+			}
+			values = append(values, value)
+			total += weight
+		}
+		if total == 0 {
+			return nil, fmt.Errorf("weighted needs weights")
+		}
+		sort.Strings(values)
+		return func(_ int, rng *rand.Rand) interface{} {
+			r := rng.Float64() * total
+			for _, value := range values {
+				if r -= g.Weights[value]; r < 0 {
+					return value
+				}
+			}
+			return values[len(values)-1]
+		}, nil
+
+	case "reference":
+		if g.Table == "" {
+			return nil, fmt.Errorf("reference needs a table")
+		}
+		column := g.Column
+		if column == "" {
+			column = "id"
+		}
+		values, err := refs(g.Table, column)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("reference to %s.%s: the table is empty", g.Table, column)
+		}
+		return func(_ int, rng *rand.Rand) interface{} { return values[rng.Intn(len(values))] }, nil
+
+	case "":
+		return nil, fmt.Errorf("generator type is required")
+	default:
+		return nil, fmt.Errorf("unknown generator type %q", g.Type)
+	}
+}
+
+// referenceValues reads the values of a column, sorted so the generated
+// rows do not depend on the order the database returns them in.
+func referenceValues(dialect database.Dialect, tx *sql.Tx) references {
+	return func(table, column string) ([]interface{}, error) {
+		query := fmt.Sprintf("SELECT %s FROM %s ORDER BY 1",
+			dialect.QuoteIdentifier(column), dialect.QuoteIdentifier(table))
+		rows, err := tx.Query(query)
+		if err != nil {
+			return nil, fmt.Errorf("reference to %s.%s: %w", table, column, err)
+		}
+		defer rows.Close()
+
+		var values []interface{}
+		for rows.Next() {
+			var value interface{}
+			if err := rows.Scan(&value); err != nil {
+				return nil, err
+			}
+			// Drivers return text as []byte, which would be reused.
+			if b, ok := value.([]byte); ok {
+				value = string(b)
+			}
+			values = append(values, value)
+		}
+		return values, rows.Err()
+	}
+}
+
+func parseDate(value string) (time.Time, string, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04:05", time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, layout, nil
+		}
+	}
+	return time.Time{}, "", fmt.Errorf("invalid date %q: use YYYY-MM-DD, YYYY-MM-DD HH:MM:SS or RFC 3339", value)
+}
+
+func pick(rng *rand.Rand, words []string) string {
+	return words[rng.Intn(len(words))]
+}
+
+var loremWords = []string{
+	"lorem", "ipsum", "dolor", "sit", "amet", "consectetur", "adipiscing", "elit", "sed", "do",
+	"eiusmod", "tempor", "incididunt", "ut", "labore", "et", "dolore", "magna", "aliqua", "enim",
+	"ad", "minim", "veniam", "quis", "nostrud", "exercitation", "ullamco", "laboris", "nisi", "aliquip",
+	"ex", "ea", "commodo", "consequat", "duis", "aute", "irure", "in", "reprehenderit", "voluptate",
+	"velit", "esse", "cillum", "fugiat", "nulla", "pariatur", "excepteur", "sint", "occaecat", "cupidatat",
+	"non", "proident", "sunt", "culpa", "qui", "officia", "deserunt", "mollit", "anim", "id", "est", "laborum",
+}
@@ -0,0 +1,130 @@
+package seed
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const factoryYAML = `
+count: 200
+seed: 7
+columns:
+  id: {type: sequence, start: 100, step: 2}
+  uuid: {type: uuid}
+  name: {type: name}
+  email: {type: email, domain: test.local}
+  bio: {type: lorem, words: 3}
+  score: {type: number, min: 1, max: 5}
+  price: {type: number, min: 1, max: 2, decimals: 2}
+  joined: {type: date, from: 2023-01-01, to: 2023-12-31}
+  status: {type: enum, values: [active, inactive]}
+  plan: {type: weighted, weights: {free: 9, pro: 1}}
+  country: NL
+  deleted_at: ~
+`
+
+func generateRows(t *testing.T, content, filename string) []map[string]interface{} {
+	t.Helper()
+
+	var factory Factory
+	if err := yaml.Unmarshal([]byte(content), &factory); err != nil {
+		t.Fatalf("Failed to parse factory: %v", err)
+	}
+	if err := factory.validate(); err != nil {
+		t.Fatalf("Invalid factory: %v", err)
+	}
+
+	var rows []map[string]interface{}
+	err := factory.rows(filename, nil, func(row map[string]interface{}) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to generate rows: %v", err)
+	}
+	return rows
+}
+
+func TestFactoryGenerators(t *testing.T) {
+	rows := generateRows(t, factoryYAML, "users.yml")
+	if len(rows) != 200 {
+		t.Fatalf("Expected 200 rows, got %d", len(rows))
+	}
+
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	emails := make(map[interface{}]bool)
+	plans := make(map[interface{}]int)
+	for i, row := range rows {
+		if row["id"] != int64(100+2*i) {
+			t.Errorf("Row %d: expected sequence value %d, got %v", i, 100+2*i, row["id"])
+		}
+		if !uuid.MatchString(row["uuid"].(string)) {
+			t.Errorf("Row %d: invalid UUID %v", i, row["uuid"])
+		}
+		if !strings.HasSuffix(row["email"].(string), "@test.local") || emails[row["email"]] {
+			t.Errorf("Row %d: expected a unique test.local address, got %v", i, row["email"])
+		}
+		emails[row["email"]] = true
+		if score := row["score"].(int64); score < 1 || score > 5 {
+			t.Errorf("Row %d: score %d out of range", i, score)
+		}
+		if price := row["price"].(float64); price < 1 || price > 2 {
+			t.Errorf("Row %d: price %v out of range", i, price)
+		}
+		if joined := row["joined"].(string); joined < "2023-01-01" || joined > "2023-12-31" || len(joined) != 10 {
+			t.Errorf("Row %d: date %s out of range or not in the input's layout", i, joined)
+		}
+		if status := row["status"]; status != "active" && status != "inactive" {
+			t.Errorf("Row %d: unexpected status %v", i, status)
+		}
+		if row["country"] != "NL" || row["deleted_at"] != nil {
+			t.Errorf("Row %d: expected constants, got %v and %v", i, row["country"], row["deleted_at"])
+		}
+		plans[row["plan"]]++
+	}
+	if plans["free"] <= plans["pro"] || plans["pro"] == 0 {
+		t.Errorf("Expected mostly free plans and some pro plans, got %v", plans)
+	}
+}
+
+func TestFactoryIsDeterministic(t *testing.T) {
+	first := generateRows(t, factoryYAML, "users.yml")
+	second := generateRows(t, factoryYAML, "other.yml")
+	if !reflect.DeepEqual(first, second) {
+		t.Error("Expected the same rows for the same seed")
+	}
+
+	unseeded := strings.Replace(factoryYAML, "seed: 7\n", "", 1)
+	a := generateRows(t, unseeded, "users.yml")
+	b := generateRows(t, unseeded, "users.yml")
+	c := generateRows(t, unseeded, "people.yml")
+	if !reflect.DeepEqual(a, b) {
+		t.Error("Expected the file name to seed the random source reproducibly")
+	}
+	if reflect.DeepEqual(a, c) {
+		t.Error("Expected different files to generate different rows")
+	}
+}
+
+func TestFactoryValidation(t *testing.T) {
+	for _, content := range []string{
+		"count: 1\n",
+		"count: 1\ncolumns:\n  x: {type: dice}\n",
+		"count: 1\ncolumns:\n  x: {type: date, from: 2023-02-01, to: 2023-01-01}\n",
+		"count: 1\ncolumns:\n  x: {type: enum}\n",
+		"count: 1\ncolumns:\n  x: {type: reference}\n",
+		"count: 1\ncolumns:\n  x: {type: name, part: middle}\n",
+	} {
+		var factory Factory
+		if err := yaml.Unmarshal([]byte(content), &factory); err != nil {
+			t.Fatalf("Failed to parse factory: %v", err)
+		}
+		if err := factory.validate(); err == nil {
+			t.Errorf("Expected factory to be rejected:\n%s", content)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package seed
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"migr8/pkg/database"
+)
+
+// maxNDJSONLine bounds the length of one NDJSON row.
+const maxNDJSONLine = 16 * 1024 * 1024
+
+// loadNDJSONSeeds finds the NDJSON seeds, which hold one JSON object per
+// row and take their options from a sidecar, as CSV seeds do.
+func (s *Seeder) loadNDJSONSeeds() ([]source, error) {
+	files, err := s.findSeedFiles("*.ndjson")
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []source
+	for _, file := range files {
+		file := file
+		var options SeedOptions
+		paths, err := loadSidecar(file, &options)
+		if err != nil {
+			return nil, err
+		}
+		if err := options.validate(); err != nil {
+			return nil, fmt.Errorf("seed options of %s: %w", file, err)
+		}
+
+		checksum, err := fileChecksum(paths...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read seed file %s: %w", file, err)
+		}
+
+		basename := filepath.Base(file)
+		table := seedTableName(basename)
+		if options.Table != "" {
+			table = options.Table
+		}
+		truncate := options.Mode == "" || options.Mode == ModeInsert
+		if options.Truncate != nil {
+			truncate = *options.Truncate
+		}
+
+		sources = append(sources, source{
+			filename:  s.seedFilename(file),
+			format:    "NDJSON",
+			name:      basename,
+			table:     table,
+			run:       options.Run,
+			truncate:  truncate,
+			dependsOn: options.DependsOn,
+			tags:      options.Tags,
+			checksum:  checksum,
+			load: func(tx *sql.Tx) (int, error) {
+				return s.processNDJSONSeed(tx, file, table, options)
+			},
+		})
+	}
+	return sources, nil
+}
+
+// processNDJSONSeed streams the rows of an NDJSON seed into table. Runs of
+// rows with the same columns are loaded in bulk, like CSV rows.
+func (s *Seeder) processNDJSONSeed(tx *sql.Tx, file, table string, options SeedOptions) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open NDJSON file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxNDJSONLine)
+
+	var (
+		w       database.RowWriter
+		columns []string
+		n, line int
+	)
+	closeWriter := func() error {
+		if w == nil {
+			return nil
+		}
+		err := w.Close()
+		w = nil
+		if err == nil {
+			err = s.setIdentityInsert(tx, table, columns, false)
+		}
+		return err
+	}
+	fail := func(err error) (int, error) {
+		if closeErr := closeWriter(); closeErr != nil {
+			err = closeErr
+		}
+		return n, fmt.Errorf("%s line %d: %w", filepath.Base(file), line, err)
+	}
+
+	for scanner.Scan() {
+		line++
+		content := bytes.TrimSpace(scanner.Bytes())
+		if len(content) == 0 {
+			continue
+		}
+
+		row, err := decodeNDJSONRow(content)
+		if err != nil {
+			return fail(err)
+		}
+		if len(row) == 0 {
+			continue
+		}
+
+		rowColumns := seedColumns([]map[string]interface{}{row})
+		if w == nil || !sameColumns(columns, rowColumns) {
+			if err := closeWriter(); err != nil {
+				return fail(err)
+			}
+			columns = rowColumns
+			if err := s.setIdentityInsert(tx, table, columns, true); err != nil {
+				return fail(fmt.Errorf("failed to enable identity insert on %s: %w", table, err))
+			}
+			if w, err = s.rowWriter(tx, table, options.Mode, options.Key, columns); err != nil {
+				return fail(err)
+			}
+		}
+
+		values := make([]interface{}, len(columns))
+		for i, column := range columns {
+			values[i] = row[column]
+		}
+		if err := w.WriteRow(values); err != nil {
+			return fail(err)
+		}
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return fail(fmt.Errorf("failed to read NDJSON file: %w", err))
+	}
+
+	if err := closeWriter(); err != nil {
+		return n, fmt.Errorf("failed to insert NDJSON rows into %s: %w", table, err)
+	}
+	return n, nil
+}
+
+// decodeNDJSONRow decodes one row. Numbers keep their exact text, and
+// nested objects and arrays are written as JSON, for JSON columns.
+func decodeNDJSONRow(content []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	var row map[string]interface{}
+	if err := decoder.Decode(&row); err != nil {
+		return nil, fmt.Errorf("invalid JSON row: %w", err)
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("invalid JSON row: more than one value on the line")
+	}
+
+	for column, value := range row {
+		switch v := value.(type) {
+		case json.Number:
+			row[column] = v.String()
+		case map[string]interface{}, []interface{}:
+			encoded, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			row[column] = string(encoded)
+		}
+	}
+	return row, nil
+}
+
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
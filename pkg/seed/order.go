@@ -0,0 +1,122 @@
+package seed
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"migr8/pkg/database"
+)
+
+// orderSources sorts seeds so that every seed runs after the seeds of the
+// tables its table references, through a foreign key or depends_on.
+// Otherwise seeds keep file name order, across seed sets, so numeric
+// prefixes still order seeds with no dependency between them. Dependencies
+// on tables without seeds are satisfied by whatever the table already
+// holds and are ignored.
+func orderSources(sources []source, foreignKeys []database.ForeignKey) ([]source, error) {
+	sources = append([]source(nil), sources...)
+	sort.SliceStable(sources, func(i, j int) bool {
+		a, b := path.Base(sources[i].filename), path.Base(sources[j].filename)
+		if a != b {
+			return a < b
+		}
+		return sources[i].filename < sources[j].filename
+	})
+
+	// Table names are compared case-insensitively, since catalogs and seed
+	// files do not always agree on case.
+	pending := make(map[string]int)
+	for _, src := range sources {
+		pending[strings.ToLower(src.table)]++
+	}
+
+	dependsOn := make(map[string]map[string]bool)
+	addDependency := func(table, referenced string) {
+		table, referenced = strings.ToLower(table), strings.ToLower(referenced)
+		if table == referenced || pending[table] == 0 || pending[referenced] == 0 {
+			return
+		}
+		if dependsOn[table] == nil {
+			dependsOn[table] = make(map[string]bool)
+		}
+		dependsOn[table][referenced] = true
+	}
+	for _, fk := range foreignKeys {
+		addDependency(fk.Table, fk.ReferencedTable)
+	}
+	for _, src := range sources {
+		for _, dep := range src.dependsOn {
+			addDependency(src.table, dep)
+		}
+	}
+
+	ordered := make([]source, 0, len(sources))
+	done := make([]bool, len(sources))
+	for len(ordered) < len(sources) {
+		progressed := false
+		for i, src := range sources {
+			if done[i] || !dependenciesDone(dependsOn[strings.ToLower(src.table)], pending) {
+				continue
+			}
+			ordered = append(ordered, src)
+			done[i] = true
+			pending[strings.ToLower(src.table)]--
+			progressed = true
+			// Start over so an earlier file that was waiting on this one
+			// runs before later independent files.
+			break
+		}
+		if !progressed {
+			return nil, fmt.Errorf("seed dependency cycle between tables %s; break it with depends_on or by seeding one side separately",
+				strings.Join(cycle(dependsOn, pending), " -> "))
+		}
+	}
+	return ordered, nil
+}
+
+func dependenciesDone(dependencies map[string]bool, pending map[string]int) bool {
+	for table := range dependencies {
+		if pending[table] > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// cycle finds a dependency cycle among the tables that still have seeds to
+// run, for the error message. Such a cycle exists whenever ordering gets
+// stuck.
+func cycle(dependsOn map[string]map[string]bool, pending map[string]int) []string {
+	var tables []string
+	for table, n := range pending {
+		if n > 0 {
+			tables = append(tables, table)
+		}
+	}
+	sort.Strings(tables)
+
+	// Every stuck table waits on another stuck table, so following the
+	// first such dependency from any of them must revisit a table.
+	next := func(table string) string {
+		var deps []string
+		for dep := range dependsOn[table] {
+			if pending[dep] > 0 {
+				deps = append(deps, dep)
+			}
+		}
+		sort.Strings(deps)
+		return deps[0]
+	}
+
+	seen := make(map[string]int)
+	var path []string
+	for table := tables[0]; ; table = next(table) {
+		if i, ok := seen[table]; ok {
+			return append(path[i:], table)
+		}
+		seen[table] = len(path)
+		path = append(path, table)
+	}
+}
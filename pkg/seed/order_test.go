@@ -0,0 +1,56 @@
+package seed
+
+import (
+	"strings"
+	"testing"
+
+	"migr8/pkg/database"
+)
+
+func TestOrderSources(t *testing.T) {
+	sources := []source{
+		{filename: "001_orders.yml", table: "orders"},
+		{filename: "002_settings.yml", table: "settings"},
+		{filename: "customers.csv", table: "customers"},
+		{filename: "003_order_lines.yml", table: "order_lines", dependsOn: []string{"products"}},
+		{filename: "000_products.csv", table: "Products"},
+	}
+	foreignKeys := []database.ForeignKey{
+		{Table: "orders", ReferencedTable: "customers"},
+		{Table: "order_lines", ReferencedTable: "orders"},
+		{Table: "orders", ReferencedTable: "orders"},
+		{Table: "invoices", ReferencedTable: "customers"},
+	}
+
+	ordered, err := orderSources(sources, foreignKeys)
+	if err != nil {
+		t.Fatalf("Failed to order seeds: %v", err)
+	}
+
+	var names []string
+	for _, src := range ordered {
+		names = append(names, src.filename)
+	}
+	want := "000_products.csv,002_settings.yml,customers.csv,001_orders.yml,003_order_lines.yml"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("Expected order %s, got %s", want, got)
+	}
+}
+
+func TestOrderSourcesCycle(t *testing.T) {
+	sources := []source{
+		{filename: "a.yml", table: "a"},
+		{filename: "b.yml", table: "b", dependsOn: []string{"c"}},
+		{filename: "c.yml", table: "c"},
+		{filename: "d.yml", table: "d", dependsOn: []string{"a"}},
+	}
+	foreignKeys := []database.ForeignKey{
+		{Table: "a", ReferencedTable: "b"},
+		{Table: "c", ReferencedTable: "b"},
+	}
+
+	_, err := orderSources(sources, foreignKeys)
+	if err == nil || !strings.Contains(err.Error(), "b -> c -> b") {
+		t.Errorf("Expected the b/c cycle to be reported, got %v", err)
+	}
+}
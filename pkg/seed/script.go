@@ -0,0 +1,90 @@
+package seed
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"migr8/pkg/database"
+)
+
+// loadSQLSeeds finds the SQL seeds: scripts run statement by statement,
+// split the way migrations are. Scripts can write to any table, so they
+// only take part in foreign key ordering when their sidecar names one;
+// otherwise they run in file name order among the other seeds.
+func (s *Seeder) loadSQLSeeds() ([]source, error) {
+	files, err := s.findSeedFiles("*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []source
+	for _, file := range files {
+		file := file
+		var options SeedOptions
+		paths, err := loadSidecar(file, &options)
+		if err != nil {
+			return nil, err
+		}
+		if err := validateRunMode(options.Run); err != nil {
+			return nil, fmt.Errorf("seed options of %s: %w", file, err)
+		}
+		if options.Mode != "" || len(options.Key) > 0 {
+			return nil, fmt.Errorf("seed options of %s: SQL seeds write their own statements and take no mode or key", file)
+		}
+		truncate := options.Truncate != nil && *options.Truncate
+		if truncate && options.Table == "" {
+			return nil, fmt.Errorf("seed options of %s: truncate requires a table", file)
+		}
+
+		checksum, err := fileChecksum(paths...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read seed file %s: %w", file, err)
+		}
+
+		basename := filepath.Base(file)
+		sources = append(sources, source{
+			filename:  s.seedFilename(file),
+			format:    "SQL",
+			name:      basename,
+			table:     options.Table,
+			run:       options.Run,
+			truncate:  truncate,
+			dependsOn: options.DependsOn,
+			tags:      options.Tags,
+			checksum:  checksum,
+			load: func(tx *sql.Tx) (int, error) {
+				return s.processSQLSeed(tx, file)
+			},
+		})
+	}
+	return sources, nil
+}
+
+// processSQLSeed runs a script's statements in the seed transaction and
+// returns the number of rows they affected.
+func (s *Seeder) processSQLSeed(tx *sql.Tx, file string) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open SQL file: %w", err)
+	}
+	defer f.Close()
+
+	n := 0
+	scanner := s.db.Dialect.Statements(f)
+	for i := 1; scanner.Scan(); i++ {
+		statement := scanner.Statement()
+		result, err := tx.Exec(statement)
+		if err != nil {
+			return n, fmt.Errorf("statement %d failed: %w\n%s", i, err, database.AbbreviateStatement(statement))
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected > 0 {
+			n += int(affected)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return n, fmt.Errorf("failed to split SQL file: %w", err)
+	}
+	return n, nil
+}
@@ -1,9 +1,13 @@
 package seed
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
 	"database/sql"
-	"encoding/csv"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -21,17 +25,109 @@ type Seeder struct {
 	config *config.Config
 }
 
+// Run modes a seed can declare with run:. Seeds run when they are new or
+// their content changed (on-change, the default), only the first time
+// (once), or on every seed run (always).
+const (
+	RunOnChange = "on-change"
+	RunOnce     = "once"
+	RunAlways   = "always"
+)
+
+// Write modes a seed can declare with mode:. Plain inserts fail on
+// duplicate keys; upsert updates the existing row and insert_ignore keeps
+// it. Both match rows on the columns listed in key:.
+const (
+	ModeInsert       = "insert"
+	ModeUpsert       = "upsert"
+	ModeInsertIgnore = "insert_ignore"
+)
+
 type SeedFile struct {
-	Name     string            `yaml:"name"`
-	Table    string            `yaml:"table"`
-	Truncate bool              `yaml:"truncate"`
-	Data     []map[string]interface{} `yaml:"data"`
+	Name     string   `yaml:"name"`
+	Table    string   `yaml:"table"`
+	Truncate bool     `yaml:"truncate"`
+	Run      string   `yaml:"run,omitempty"`
+	Mode     string   `yaml:"mode,omitempty"`
+	Key      []string `yaml:"key,omitempty"`
+	// DependsOn names tables whose seeds must run first, in addition to
+	// those the table references through foreign keys.
+	DependsOn []string `yaml:"depends_on,omitempty"`
+	// Tags label the seed for `seed run --tag`; reference marks data that
+	// may run against protected environments.
+	Tags    []string                 `yaml:"tags,omitempty"`
+	Data    []map[string]interface{} `yaml:"data"`
+	Factory *Factory                 `yaml:"factory,omitempty"`
+
+	filename string
+	format   string
+	checksum string
 }
 
 type CSVSeedFile struct {
-	Filename string
-	Table    string
-	Truncate bool
+	Filename   string
+	Table      string
+	Truncate   bool
+	Run        string
+	Mode       string
+	Key        []string
+	DependsOn  []string
+	Tags       []string
+	Checksum   string
+	Delimiter  string
+	NullMarker *string
+	Rename     map[string]string
+	Types      map[string]string
+
+	// headerLines counts the option comment lines the file starts with.
+	headerLines int
+}
+
+// SeedOptions are the settings of seed formats that cannot hold them
+// inline, read from an optional sidecar file named after the seed with .yml
+// appended (users.csv.yml). Table defaults to the file name without its
+// numeric prefix.
+type SeedOptions struct {
+	Table     string   `yaml:"table"`
+	Truncate  *bool    `yaml:"truncate"`
+	Run       string   `yaml:"run"`
+	Mode      string   `yaml:"mode"`
+	Key       []string `yaml:"key"`
+	DependsOn []string `yaml:"depends_on"`
+	Tags      []string `yaml:"tags"`
+}
+
+// CSVOptions are read from a CSV seed's sidecar file, or from the comment
+// lines at the top of the file. NullMarker is the cell text that stands
+// for NULL; without it every cell is a value. Rename maps header names to
+// column names, and Types casts columns, by column name, to a type other
+// than string.
+type CSVOptions struct {
+	SeedOptions `yaml:",inline"`
+	Delimiter   string            `yaml:"delimiter"`
+	NullMarker  *string           `yaml:"null_marker"`
+	Rename      map[string]string `yaml:"rename"`
+	Types       map[string]string `yaml:"types"`
+}
+
+const sidecarSuffix = ".yml"
+
+// TagReference marks reference data, the only seeds allowed to run against
+// the environments listed in seed.protected_environments.
+const TagReference = "reference"
+
+// commonSeedSet is the subdirectory of the seed directory whose seeds run
+// in every environment, like the files in the seed directory itself. The
+// seeds in a subdirectory named after an environment only run for it.
+const commonSeedSet = "common"
+
+// RunOptions controls Run and Status.
+type RunOptions struct {
+	// Force runs every seed, whatever its run mode and history.
+	Force bool
+	// Tags selects the seeds with at least one of these tags; empty
+	// selects every seed.
+	Tags []string
 }
 
 func NewSeeder(cfg *config.Config) (*Seeder, error) {
@@ -50,75 +146,592 @@ func (s *Seeder) Close() error {
 	return s.db.Close()
 }
 
-func (s *Seeder) Run() error {
+// source is a seed file of any format, as Run schedules it.
+type source struct {
+	filename  string
+	format    string
+	name      string
+	table     string
+	run       string
+	truncate  bool
+	dependsOn []string
+	tags      []string
+	checksum  string
+	// load writes the seed's rows and returns how many it wrote;
+	// truncation is done by the caller.
+	load func(tx *sql.Tx) (int, error)
+}
+
+// describeTable names the seed's table for output. SQL seeds may not
+// declare one.
+func (src source) describeTable() string {
+	if src.table == "" {
+		return "-"
+	}
+	return src.table
+}
+
+// Run processes the seed files that are due according to their run mode
+// and the seed history table, parents before the tables that reference
+// them. Everything runs in one transaction, which also records the seeds
+// in the history, so a failed run changes nothing and is retried next time.
+// SQL seeds that change the schema on MySQL are the exception, since MySQL
+// commits DDL implicitly.
+func (s *Seeder) Run(opts RunOptions) error {
+	return s.db.WithLock(s.lockName(), func() error {
+		return s.run(opts)
+	})
+}
+
+func (s *Seeder) run(opts RunOptions) error {
 	if _, err := os.Stat(s.config.Seed.Directory); os.IsNotExist(err) {
 		fmt.Printf("Seed directory does not exist: %s\n", s.config.Seed.Directory)
 		return nil
 	}
 
-	yamlFiles, err := s.loadYAMLSeeds()
+	sources, err := s.loadSources(opts.Tags)
 	if err != nil {
-		return fmt.Errorf("failed to load YAML seeds: %w", err)
+		return err
 	}
 
-	csvFiles, err := s.loadCSVSeeds()
+	if len(sources) == 0 {
+		fmt.Println("No seed files found.")
+		return nil
+	}
+
+	history, err := s.history()
 	if err != nil {
-		return fmt.Errorf("failed to load CSV seeds: %w", err)
+		return err
 	}
 
-	if len(yamlFiles) == 0 && len(csvFiles) == 0 {
-		fmt.Println("No seed files found.")
+	foreignKeys, err := s.foreignKeys()
+	if err != nil {
+		return err
+	}
+
+	selected, emptied := dueSources(sources, history, opts.Force, foreignKeys)
+	var due []source
+	for i, src := range sources {
+		if selected[i] {
+			due = append(due, src)
+		}
+	}
+
+	if err := s.checkProtected(due); err != nil {
+		return err
+	}
+
+	if len(due) == 0 {
+		fmt.Printf("All %d seed files are up to date (use --force to run them anyway).\n", len(sources))
 		return nil
 	}
 
-	fmt.Printf("Processing %d YAML seed files and %d CSV seed files...\n", len(yamlFiles), len(csvFiles))
+	fmt.Printf("Processing %d of %d seed files...\n", len(due), len(sources))
 
-	for _, seedFile := range yamlFiles {
-		if err := s.processYAMLSeed(seedFile); err != nil {
-			return fmt.Errorf("failed to process YAML seed %s: %w", seedFile.Name, err)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Tables are emptied before any seed runs, referencing tables first,
+	// so neither foreign keys nor a later truncation get in the way.
+	truncated := make(map[string]bool)
+	for i := len(due) - 1; i >= 0; i-- {
+		table := due[i].table
+		if !emptied[strings.ToLower(table)] || truncated[strings.ToLower(table)] {
+			continue
+		}
+		if err := s.truncateTable(tx, table); err != nil {
+			return fmt.Errorf("failed to truncate table %s: %w", table, err)
 		}
-		fmt.Printf("Processed YAML seed: %s (%s)\n", seedFile.Name, seedFile.Table)
+		truncated[strings.ToLower(table)] = true
 	}
 
-	for _, csvFile := range csvFiles {
-		if err := s.processCSVSeed(csvFile); err != nil {
-			return fmt.Errorf("failed to process CSV seed %s: %w", csvFile.Filename, err)
+	for _, src := range due {
+		start := time.Now()
+		rows, err := src.load(tx)
+		if err != nil {
+			return fmt.Errorf("failed to process %s seed %s: %w", src.format, src.name, err)
+		}
+		if err := s.recordSeed(tx, src); err != nil {
+			return err
 		}
-		fmt.Printf("Processed CSV seed: %s (%s)\n", csvFile.Filename, csvFile.Table)
+		elapsed := time.Since(start)
+		fmt.Printf("Processed %s seed: %s (%s), %d rows in %s (%.0f rows/sec)\n",
+			src.format, src.name, src.describeTable(), rows, elapsed.Round(time.Millisecond), float64(rows)/elapsed.Seconds())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit seeds: %w", err)
 	}
 
+	if skipped := len(sources) - len(due); skipped > 0 {
+		fmt.Printf("Skipped %d seed files that are up to date (use --force to run them anyway).\n", skipped)
+	}
 	fmt.Println("All seeds processed successfully!")
 	return nil
 }
 
-func (s *Seeder) loadYAMLSeeds() ([]SeedFile, error) {
-	files, err := filepath.Glob(filepath.Join(s.config.Seed.Directory, "*.yml"))
+// Status prints every seed file selected by opts' tags, in the order Run
+// would process them, with its run mode and where it stands against the
+// history table.
+func (s *Seeder) Status(opts RunOptions) error {
+	sources, err := s.loadSources(opts.Tags)
+	if err != nil {
+		return err
+	}
+
+	history, err := s.history()
+	if err != nil {
+		return err
+	}
+
+	foreignKeys, err := s.foreignKeys()
+	if err != nil {
+		return err
+	}
+	selected, _ := dueSources(sources, history, false, foreignKeys)
+
+	fmt.Printf("Seed Status:\n")
+	fmt.Printf("============\n\n")
+
+	if env := s.config.Seed.Environment; env != "" {
+		if s.protected() {
+			fmt.Printf("Environment: %s (protected, only %s seeds run)\n\n", env, TagReference)
+		} else {
+			fmt.Printf("Environment: %s\n\n", env)
+		}
+	}
+
+	if len(sources) == 0 {
+		fmt.Println("No seed files found.")
+		return nil
+	}
+
+	pending := 0
+	for i, src := range sources {
+		state := seedState(history, src.filename, src.checksum)
+		due := selected[i]
+		if due {
+			pending++
+		}
+		reload := due && !shouldRun(src.run, state)
+
+		marker := "[✓]"
+		switch {
+		case state == seedNew:
+			marker = "[ ]"
+		case state == seedChanged && due, reload:
+			marker = "[~]"
+		}
+
+		detail := "pending"
+		if entry, ok := history[src.filename]; ok {
+			detail = "last run " + entry.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			if state == seedChanged {
+				detail += ", changed since"
+			}
+			if reload {
+				detail += ", reloads with a table it references"
+			}
+		}
+		fmt.Printf("%s %-40s %-20s %-10s %s\n", marker, src.filename, src.describeTable(), runMode(src.run), detail)
+	}
+
+	fmt.Printf("\nTotal: %d seeds, %d to run\n", len(sources), pending)
+	return nil
+}
+
+// loadSources loads the seed files of every format that apply to the
+// configured environment and carry one of tags, in the order they must run.
+func (s *Seeder) loadSources(tags []string) ([]source, error) {
+	yamlFiles, err := s.loadYAMLSeeds()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load YAML and JSON seeds: %w", err)
+	}
+
+	csvFiles, err := s.loadCSVSeeds()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CSV seeds: %w", err)
+	}
+
+	var sources []source
+	for _, seedFile := range yamlFiles {
+		seedFile := seedFile
+		dependsOn := seedFile.DependsOn
+		if seedFile.Factory != nil {
+			dependsOn = append(dependsOn, seedFile.Factory.dependencies()...)
+		}
+		sources = append(sources, source{
+			filename:  seedFile.filename,
+			format:    seedFile.format,
+			name:      seedFile.Name,
+			table:     seedFile.Table,
+			run:       seedFile.Run,
+			truncate:  seedFile.Truncate,
+			dependsOn: dependsOn,
+			tags:      seedFile.Tags,
+			checksum:  seedFile.checksum,
+			load: func(tx *sql.Tx) (int, error) {
+				return s.processYAMLSeed(tx, seedFile)
+			},
+		})
+	}
+	for _, csvFile := range csvFiles {
+		csvFile := csvFile
+		sources = append(sources, source{
+			filename:  csvFile.Filename,
+			format:    "CSV",
+			name:      csvFile.Filename,
+			table:     csvFile.Table,
+			run:       csvFile.Run,
+			truncate:  csvFile.Truncate,
+			dependsOn: csvFile.DependsOn,
+			tags:      csvFile.Tags,
+			checksum:  csvFile.Checksum,
+			load: func(tx *sql.Tx) (int, error) {
+				return s.processCSVSeed(tx, csvFile)
+			},
+		})
+	}
+
+	ndjsonSources, err := s.loadNDJSONSeeds()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load NDJSON seeds: %w", err)
+	}
+	sources = append(sources, ndjsonSources...)
+
+	sqlSources, err := s.loadSQLSeeds()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SQL seeds: %w", err)
+	}
+	sources = append(sources, sqlSources...)
+
+	if len(tags) > 0 {
+		selected := sources[:0]
+		for _, src := range sources {
+			if hasAnyTag(src.tags, tags) {
+				selected = append(selected, src)
+			}
+		}
+		sources = selected
+	}
+
+	foreignKeys, err := s.foreignKeys()
 	if err != nil {
 		return nil, err
 	}
-	
-	yamlFiles, err := filepath.Glob(filepath.Join(s.config.Seed.Directory, "*.yaml"))
+	return orderSources(sources, foreignKeys)
+}
+
+// seedDirectories returns the directories whose seeds apply to the
+// configured environment: the seed directory, its common set and the set
+// named after the environment.
+func (s *Seeder) seedDirectories() []string {
+	dirs := []string{s.config.Seed.Directory, filepath.Join(s.config.Seed.Directory, commonSeedSet)}
+	if env := s.config.Seed.Environment; env != "" && env != commonSeedSet {
+		dirs = append(dirs, filepath.Join(s.config.Seed.Directory, env))
+	}
+	return dirs
+}
+
+// findSeedFiles lists the files that match any of patterns in the seed
+// directories of the configured environment.
+func (s *Seeder) findSeedFiles(patterns ...string) ([]string, error) {
+	var files []string
+	for _, dir := range s.seedDirectories() {
+		for _, pattern := range patterns {
+			matches, err := filepath.Glob(filepath.Join(dir, pattern))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, matches...)
+		}
+	}
+	return files, nil
+}
+
+// seedFilename is the name a seed file is recorded under in the history:
+// its path relative to the seed directory, so the same file name can
+// appear in several seed sets.
+func (s *Seeder) seedFilename(file string) string {
+	rel, err := filepath.Rel(s.config.Seed.Directory, file)
+	if err != nil {
+		return filepath.Base(file)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// protected reports whether the configured environment only allows
+// reference data.
+func (s *Seeder) protected() bool {
+	env := s.config.Seed.Environment
+	for _, name := range s.config.Seed.ProtectedEnvironments {
+		if env != "" && strings.EqualFold(name, env) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkProtected refuses to run anything but reference data against a
+// protected environment, whatever the options.
+func (s *Seeder) checkProtected(sources []source) error {
+	if !s.protected() {
+		return nil
+	}
+
+	var blocked []string
+	for _, src := range sources {
+		if !hasAnyTag(src.tags, []string{TagReference}) {
+			blocked = append(blocked, src.filename)
+		}
+	}
+	if len(blocked) > 0 {
+		return fmt.Errorf("environment %s is protected and only runs seeds tagged %s, which %s not: %s (select reference data with --tag %s)",
+			s.config.Seed.Environment, TagReference, plural(len(blocked), "this seed is", "these seeds are"),
+			strings.Join(blocked, ", "), TagReference)
+	}
+	return nil
+}
+
+func hasAnyTag(tags, wanted []string) bool {
+	for _, tag := range tags {
+		for _, w := range wanted {
+			if strings.EqualFold(tag, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// foreignKeys lists the foreign keys of the database, or nothing when the
+// dialect cannot introspect them.
+func (s *Seeder) foreignKeys() ([]database.ForeignKey, error) {
+	introspector, ok := s.db.Dialect.(database.Introspector)
+	if !ok {
+		return nil, nil
+	}
+
+	foreignKeys, err := introspector.ForeignKeys(s.db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list foreign keys: %w", err)
+	}
+	return foreignKeys, nil
+}
+
+// Where a seed file stands relative to the history table.
+const (
+	seedNew     = "new"
+	seedChanged = "changed"
+	seedApplied = "applied"
+)
+
+func seedState(history map[string]database.HistoryEntry, filename, checksum string) string {
+	entry, ok := history[filename]
+	switch {
+	case !ok:
+		return seedNew
+	case entry.Checksum != checksum:
+		return seedChanged
+	default:
+		return seedApplied
+	}
+}
+
+// dueSources reports which of sources run, and which tables are emptied
+// first, lower-cased. Sources run when their run mode and the history say
+// so, or always with force. Emptying a table also empties every table that
+// references it, directly or through others: PostgreSQL's TRUNCATE ...
+// CASCADE does so anyway, and elsewhere their rows would point at rows
+// that are gone. The seeds of those tables run again whatever their run
+// mode, so their data is not lost while the history says it is loaded.
+func dueSources(sources []source, history map[string]database.HistoryEntry, force bool, foreignKeys []database.ForeignKey) ([]bool, map[string]bool) {
+	due := make([]bool, len(sources))
+	emptied := make(map[string]bool)
+	for i, src := range sources {
+		due[i] = force || shouldRun(src.run, seedState(history, src.filename, src.checksum))
+		if due[i] && src.truncate && src.table != "" {
+			emptied[strings.ToLower(src.table)] = true
+		}
+	}
+
+	for changed := len(emptied) > 0; changed; {
+		changed = false
+		for _, fk := range foreignKeys {
+			table := strings.ToLower(fk.Table)
+			if emptied[strings.ToLower(fk.ReferencedTable)] && !emptied[table] {
+				emptied[table] = true
+				changed = true
+			}
+		}
+	}
+
+	for i, src := range sources {
+		if emptied[strings.ToLower(src.table)] {
+			due[i] = true
+		}
+	}
+	return due, emptied
+}
+
+func shouldRun(mode, state string) bool {
+	switch runMode(mode) {
+	case RunAlways:
+		return true
+	case RunOnce:
+		return state == seedNew
+	default:
+		return state != seedApplied
+	}
+}
+
+func runMode(mode string) string {
+	if mode == "" {
+		return RunOnChange
+	}
+	return mode
+}
+
+func validateWriteMode(mode string, key []string) error {
+	switch mode {
+	case "", ModeInsert:
+		return nil
+	case ModeUpsert, ModeInsertIgnore:
+		if len(key) == 0 {
+			return fmt.Errorf("mode %s requires key columns", mode)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid mode %q: expected %s, %s or %s", mode, ModeInsert, ModeUpsert, ModeInsertIgnore)
+	}
+}
+
+func (o SeedOptions) validate() error {
+	if err := validateRunMode(o.Run); err != nil {
+		return err
+	}
+	return validateWriteMode(o.Mode, o.Key)
+}
+
+func validateRunMode(mode string) error {
+	switch mode {
+	case "", RunOnChange, RunOnce, RunAlways:
+		return nil
+	default:
+		return fmt.Errorf("invalid run mode %q: expected %s, %s or %s", mode, RunOnce, RunAlways, RunOnChange)
+	}
+}
+
+// history returns the latest history entry of every seed file, creating
+// the history table if needed.
+func (s *Seeder) history() (map[string]database.HistoryEntry, error) {
+	if err := s.db.CreateMigrationsTable(s.config.Seed.Table); err != nil {
+		return nil, fmt.Errorf("failed to create seed history table: %w", err)
+	}
+
+	entries, err := s.db.GetHistory(s.config.Seed.Table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read seed history: %w", err)
+	}
+
+	history := make(map[string]database.HistoryEntry, len(entries))
+	for _, entry := range entries {
+		history[entry.Filename] = entry
+	}
+	return history, nil
+}
+
+// recordSeed replaces the history entry of src.
+func (s *Seeder) recordSeed(tx *sql.Tx, src source) error {
+	if err := s.db.RemoveMigrationWith(tx, s.config.Seed.Table, src.filename); err != nil {
+		return fmt.Errorf("failed to update seed history: %w", err)
+	}
+	if err := s.db.RecordMigrationWith(tx, s.config.Seed.Table, src.filename, src.checksum); err != nil {
+		return fmt.Errorf("failed to update seed history: %w", err)
+	}
+	return nil
+}
+
+func (s *Seeder) lockName() string {
+	return "migr8:" + s.config.Seed.Table
+}
+
+// fileChecksum is the MD5 of the files' content, the same checksum
+// migrations are recorded with.
+func fileChecksum(paths ...string) (string, error) {
+	hash := md5.New()
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(hash, file)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (s *Seeder) loadYAMLSeeds() ([]SeedFile, error) {
+	// JSON seeds have the same shape, and JSON is valid YAML.
+	files, err := s.findSeedFiles("*.yml", "*.yaml", "*.json")
 	if err != nil {
 		return nil, err
 	}
-	
-	files = append(files, yamlFiles...)
 
 	var seedFiles []SeedFile
-	seedNameRegex := regexp.MustCompile(`^(\d+)_(.+)\.(yml|yaml)$`)
+	seedNameRegex := regexp.MustCompile(`^(\d+)_(.+)\.(yml|yaml|json)$`)
 
 	for _, file := range files {
+		// Sidecars are not seeds of their own.
+		if isSidecar(file) {
+			continue
+		}
+
 		content, err := os.ReadFile(file)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read seed file %s: %w", file, err)
 		}
 
 		var seedFile SeedFile
+		seedFile.format = "YAML"
+		if filepath.Ext(file) == ".json" {
+			seedFile.format = "JSON"
+		}
 		if err := yaml.Unmarshal(content, &seedFile); err != nil {
-			return nil, fmt.Errorf("failed to parse YAML seed file %s: %w", file, err)
+			return nil, fmt.Errorf("failed to parse %s seed file %s: %w", seedFile.format, file, err)
+		}
+		if seedFile.Table == "" {
+			return nil, fmt.Errorf("seed file %s: table name is required", file)
+		}
+		if seedFile.Factory != nil {
+			if err := seedFile.Factory.validate(); err != nil {
+				return nil, fmt.Errorf("seed file %s: %w", file, err)
+			}
+		}
+		if err := validateRunMode(seedFile.Run); err != nil {
+			return nil, fmt.Errorf("seed file %s: %w", file, err)
+		}
+		if err := validateWriteMode(seedFile.Mode, seedFile.Key); err != nil {
+			return nil, fmt.Errorf("seed file %s: %w", file, err)
 		}
 
 		basename := filepath.Base(file)
+		seedFile.filename = s.seedFilename(file)
+		sum := md5.Sum(content)
+		seedFile.checksum = hex.EncodeToString(sum[:])
 		matches := seedNameRegex.FindStringSubmatch(basename)
 		if len(matches) == 4 {
 			seedFile.Name = matches[2]
@@ -130,36 +743,59 @@ func (s *Seeder) loadYAMLSeeds() ([]SeedFile, error) {
 	}
 
 	sort.Slice(seedFiles, func(i, j int) bool {
-		return seedFiles[i].Name < seedFiles[j].Name
+		return seedFiles[i].filename < seedFiles[j].filename
 	})
 
 	return seedFiles, nil
 }
 
 func (s *Seeder) loadCSVSeeds() ([]CSVSeedFile, error) {
-	files, err := filepath.Glob(filepath.Join(s.config.Seed.Directory, "*.csv"))
+	files, err := s.findSeedFiles("*.csv")
 	if err != nil {
 		return nil, err
 	}
 
 	var csvFiles []CSVSeedFile
-	csvNameRegex := regexp.MustCompile(`^(\d+_)?(.+)\.csv$`)
 
 	for _, file := range files {
 		basename := filepath.Base(file)
-		matches := csvNameRegex.FindStringSubmatch(basename)
-		
-		var tableName string
-		if len(matches) >= 3 {
-			tableName = matches[2]
-		} else {
-			tableName = strings.TrimSuffix(basename, ".csv")
+		tableName := seedTableName(basename)
+
+		options, headerLines, sources, err := loadCSVOptions(file)
+		if err != nil {
+			return nil, err
+		}
+
+		checksum, err := fileChecksum(sources...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read seed file %s: %w", file, err)
+		}
+
+		if options.Table != "" {
+			tableName = options.Table
+		}
+		// By default, truncating is left to insert mode, since it would
+		// defeat reconciling existing rows.
+		truncate := options.Mode == "" || options.Mode == ModeInsert
+		if options.Truncate != nil {
+			truncate = *options.Truncate
 		}
 
 		csvFile := CSVSeedFile{
-			Filename: basename,
-			Table:    tableName,
-			Truncate: true,
+			Filename:    s.seedFilename(file),
+			Table:       tableName,
+			Truncate:    truncate,
+			Run:         options.Run,
+			Mode:        options.Mode,
+			Key:         options.Key,
+			DependsOn:   options.DependsOn,
+			Tags:        options.Tags,
+			Checksum:    checksum,
+			Delimiter:   options.Delimiter,
+			NullMarker:  options.NullMarker,
+			Rename:      options.Rename,
+			Types:       options.Types,
+			headerLines: headerLines,
 		}
 
 		csvFiles = append(csvFiles, csvFile)
@@ -172,162 +808,326 @@ func (s *Seeder) loadCSVSeeds() ([]CSVSeedFile, error) {
 	return csvFiles, nil
 }
 
-func (s *Seeder) processYAMLSeed(seedFile SeedFile) error {
-	if seedFile.Table == "" {
-		return fmt.Errorf("table name is required for seed %s", seedFile.Name)
+// loadCSVOptions reads the options of a CSV seed from its sidecar or its
+// header comment, if it has either. It also returns the number of comment
+// lines and the files the seed's checksum covers.
+func loadCSVOptions(file string) (CSVOptions, int, []string, error) {
+	var options CSVOptions
+
+	f, err := os.Open(file)
+	if err != nil {
+		return options, 0, nil, fmt.Errorf("failed to read seed file %s: %w", file, err)
 	}
+	defer f.Close()
 
-	tx, err := s.db.Begin()
+	header, headerLines, err := readCSVHeaderOptions(bufio.NewReader(f))
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return options, 0, nil, fmt.Errorf("failed to read seed file %s: %w", file, err)
 	}
-	defer tx.Rollback()
 
-	if seedFile.Truncate {
-		if err := s.truncateTable(tx, seedFile.Table); err != nil {
-			return fmt.Errorf("failed to truncate table %s: %w", seedFile.Table, err)
+	sources, err := loadSidecar(file, &options)
+	if err != nil {
+		return options, 0, nil, err
+	}
+	switch {
+	case len(sources) > 1 && headerLines > 0:
+		return options, 0, nil, fmt.Errorf("CSV seed %s has options both in %s and in its header", file, sources[1])
+	case headerLines > 0:
+		if err := yaml.Unmarshal([]byte(header), &options); err != nil {
+			return options, 0, nil, fmt.Errorf("failed to parse CSV options in %s: %w", file, err)
 		}
 	}
 
+	if err := options.validate(); err != nil {
+		return options, 0, nil, fmt.Errorf("CSV options of %s: %w", file, err)
+	}
+	return options, headerLines, sources, nil
+}
+
+// loadSidecar reads the sidecar of file into options, if it has one. It
+// returns the files the seed's checksum covers.
+func loadSidecar(file string, options interface{}) ([]string, error) {
+	sidecar := file + sidecarSuffix
+
+	content, err := os.ReadFile(sidecar)
+	if os.IsNotExist(err) {
+		return []string{file}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read seed options %s: %w", sidecar, err)
+	}
+
+	if err := yaml.Unmarshal(content, options); err != nil {
+		return nil, fmt.Errorf("failed to parse seed options %s: %w", sidecar, err)
+	}
+	return []string{file, sidecar}, nil
+}
+
+// isSidecar reports whether a YAML file holds the options of a seed in
+// another format rather than being a seed itself.
+func isSidecar(file string) bool {
+	seed := strings.TrimSuffix(file, sidecarSuffix)
+	switch filepath.Ext(seed) {
+	case ".csv", ".ndjson", ".sql":
+		return seed != file
+	}
+	return false
+}
+
+// seedTableName derives a table name from a seed's file name, dropping a
+// numeric prefix: 002_users.csv seeds users.
+func seedTableName(basename string) string {
+	name := strings.TrimSuffix(basename, filepath.Ext(basename))
+	if matches := tableNameRegex.FindStringSubmatch(name); matches != nil {
+		return matches[1]
+	}
+	return name
+}
+
+var tableNameRegex = regexp.MustCompile(`^\d+_(.+)$`)
+
+func (s *Seeder) processYAMLSeed(tx *sql.Tx, seedFile SeedFile) (int, error) {
+	columns := seedColumns(seedFile.Data)
+	if seedFile.Factory != nil {
+		columns = mergeColumns(columns, seedFile.Factory.columns())
+	}
+	if err := s.setIdentityInsert(tx, seedFile.Table, columns, true); err != nil {
+		return 0, fmt.Errorf("failed to enable identity insert on %s: %w", seedFile.Table, err)
+	}
+
 	for _, row := range seedFile.Data {
-		if err := s.insertRow(tx, seedFile.Table, row); err != nil {
-			return fmt.Errorf("failed to insert row into %s: %w", seedFile.Table, err)
+		if err := s.writeRow(tx, seedFile.Table, seedFile.Mode, seedFile.Key, row); err != nil {
+			return 0, fmt.Errorf("failed to insert row into %s: %w", seedFile.Table, err)
 		}
 	}
+	n := len(seedFile.Data)
 
-	return tx.Commit()
+	if seedFile.Factory != nil {
+		generated, err := s.writeFactoryRows(tx, seedFile)
+		if err != nil {
+			return 0, fmt.Errorf("failed to insert generated rows into %s: %w", seedFile.Table, err)
+		}
+		n += generated
+	}
+
+	if err := s.setIdentityInsert(tx, seedFile.Table, columns, false); err != nil {
+		return 0, fmt.Errorf("failed to disable identity insert on %s: %w", seedFile.Table, err)
+	}
+
+	return n, nil
 }
 
-func (s *Seeder) processCSVSeed(csvFile CSVSeedFile) error {
-	filePath := filepath.Join(s.config.Seed.Directory, csvFile.Filename)
+// writeFactoryRows writes the rows generated by a seed's factory. The
+// writer is only opened for the first row, once reference values have been
+// read, since a COPY in progress keeps the connection busy.
+func (s *Seeder) writeFactoryRows(tx *sql.Tx, seedFile SeedFile) (int, error) {
+	columns := seedFile.Factory.columns()
+
+	var w database.RowWriter
+	n := 0
+	err := seedFile.Factory.rows(seedFile.filename, referenceValues(s.db.Dialect, tx), func(row map[string]interface{}) error {
+		if w == nil {
+			var err error
+			if w, err = s.rowWriter(tx, seedFile.Table, seedFile.Mode, seedFile.Key, columns); err != nil {
+				return err
+			}
+		}
+
+		values := make([]interface{}, len(columns))
+		for i, column := range columns {
+			values[i] = row[column]
+		}
+		n++
+		return w.WriteRow(values)
+	})
+	if w != nil {
+		if closeErr := w.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return n, err
+}
+
+// processCSVSeed streams the rows of a CSV seed into its table, so files
+// of any size load in constant memory.
+func (s *Seeder) processCSVSeed(tx *sql.Tx, csvFile CSVSeedFile) (int, error) {
+	filePath := filepath.Join(s.config.Seed.Directory, filepath.FromSlash(csvFile.Filename))
 	
 	file, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to open CSV file: %w", err)
+		return 0, fmt.Errorf("failed to open CSV file: %w", err)
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		return fmt.Errorf("failed to read CSV file: %w", err)
+	// The option comment lines were parsed when the seed was loaded.
+	buffered := bufio.NewReader(file)
+	if _, _, err := readCSVHeaderOptions(buffered); err != nil {
+		return 0, fmt.Errorf("failed to read CSV file: %w", err)
 	}
 
-	if len(records) == 0 {
-		return fmt.Errorf("CSV file is empty")
+	rows, err := newCSVRows(buffered, csvFile)
+	if err != nil {
+		return 0, err
 	}
 
-	headers := records[0]
-	dataRows := records[1:]
+	if err := s.setIdentityInsert(tx, csvFile.Table, rows.columns, true); err != nil {
+		return 0, fmt.Errorf("failed to enable identity insert on %s: %w", csvFile.Table, err)
+	}
 
-	tx, err := s.db.Begin()
+	w, err := s.rowWriter(tx, csvFile.Table, csvFile.Mode, csvFile.Key, rows.columns)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return 0, fmt.Errorf("failed to insert CSV rows into %s: %w", csvFile.Table, err)
+	}
+	n, err := writeRows(w, rows.next)
+	if err != nil {
+		return n, fmt.Errorf("failed to insert CSV rows into %s: %w", csvFile.Table, err)
 	}
-	defer tx.Rollback()
 
-	if csvFile.Truncate {
-		if err := s.truncateTable(tx, csvFile.Table); err != nil {
-			return fmt.Errorf("failed to truncate table %s: %w", csvFile.Table, err)
-		}
+	if err := s.setIdentityInsert(tx, csvFile.Table, rows.columns, false); err != nil {
+		return n, fmt.Errorf("failed to disable identity insert on %s: %w", csvFile.Table, err)
 	}
 
-	for _, row := range dataRows {
-		if len(row) != len(headers) {
-			continue
-		}
+	return n, nil
+}
 
-		rowData := make(map[string]interface{})
-		for i, value := range row {
-			rowData[headers[i]] = value
-		}
+func (s *Seeder) truncateTable(tx *sql.Tx, tableName string) error {
+	_, err := tx.Exec(s.db.Dialect.TruncateTableSQL(tableName))
+	return err
+}
 
-		if err := s.insertRow(tx, csvFile.Table, rowData); err != nil {
-			return fmt.Errorf("failed to insert CSV row into %s: %w", csvFile.Table, err)
-		}
+// setIdentityInsert lets explicit values be written to identity columns on
+// dialects that otherwise reject them.
+func (s *Seeder) setIdentityInsert(tx *sql.Tx, tableName string, columns []string, enable bool) error {
+	inserter, ok := s.db.Dialect.(database.IdentityInserter)
+	if !ok || len(columns) == 0 {
+		return nil
 	}
 
-	return tx.Commit()
+	_, err := tx.Exec(inserter.IdentityInsertSQL(tableName, columns, enable))
+	return err
 }
 
-func (s *Seeder) truncateTable(tx *sql.Tx, tableName string) error {
-	var query string
-	
-	switch s.db.Driver {
-	case "postgres":
-		query = fmt.Sprintf("TRUNCATE TABLE %s RESTART IDENTITY CASCADE", tableName)
-	case "mysql":
-		query = fmt.Sprintf("TRUNCATE TABLE %s", tableName)
-	case "sqlite3":
-		query = fmt.Sprintf("DELETE FROM %s", tableName)
-	default:
-		return fmt.Errorf("truncate not supported for driver: %s", s.db.Driver)
+func seedColumns(rows []map[string]interface{}) []string {
+	seen := make(map[string]bool)
+	var columns []string
+	for _, row := range rows {
+		for column := range row {
+			if !seen[column] {
+				seen[column] = true
+				columns = append(columns, column)
+			}
+		}
 	}
+	sort.Strings(columns)
+	return columns
+}
 
-	_, err := tx.Exec(query)
-	return err
+// mergeColumns returns the sorted union of two sorted column lists.
+func mergeColumns(a, b []string) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	var columns []string
+	for _, column := range append(append([]string(nil), a...), b...) {
+		if !seen[column] {
+			seen[column] = true
+			columns = append(columns, column)
+		}
+	}
+	sort.Strings(columns)
+	return columns
 }
 
-func (s *Seeder) insertRow(tx *sql.Tx, tableName string, data map[string]interface{}) error {
+// writeRow inserts data, or reconciles it with the row that has the same
+// key for the upsert and insert_ignore modes.
+func (s *Seeder) writeRow(tx *sql.Tx, tableName, mode string, key []string, data map[string]interface{}) error {
 	if len(data) == 0 {
 		return nil
 	}
 
-	var columns []string
-	var placeholders []string
-	var values []interface{}
+	for _, column := range key {
+		if _, ok := data[column]; !ok {
+			return fmt.Errorf("row has no value for key column %s", column)
+		}
+	}
 
-	i := 1
-	for column, value := range data {
+	columns := make([]string, 0, len(data))
+	for column := range data {
 		columns = append(columns, column)
-		values = append(values, value)
-		
-		if s.db.Driver == "postgres" {
-			placeholders = append(placeholders, fmt.Sprintf("$%d", i))
-		} else {
-			placeholders = append(placeholders, "?")
-		}
-		i++
 	}
+	sort.Strings(columns)
 
-	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
-		tableName,
-		strings.Join(columns, ", "),
-		strings.Join(placeholders, ", "))
+	values := make([]interface{}, len(columns))
+	for i, column := range columns {
+		values[i] = data[column]
+	}
+
+	var query string
+	switch mode {
+	case ModeUpsert:
+		query = s.db.Dialect.UpsertSQL(tableName, columns, key)
+	case ModeInsertIgnore:
+		query = s.db.Dialect.InsertIgnoreSQL(tableName, columns, key)
+	default:
+		query = database.InsertSQL(s.db.Dialect, tableName, columns)
+	}
 
 	_, err := tx.Exec(query, values...)
 	return err
 }
 
+// templateColumns stand in for the columns of a table that does not exist
+// yet, so a template can be written before the migration that creates it
+// has run.
+var templateColumns = []database.Column{
+	{Name: "id", Type: "INTEGER", PrimaryKey: true},
+	{Name: "name", Type: "TEXT"},
+	{Name: "email", Type: "TEXT"},
+	{Name: "created_at", Type: "TIMESTAMP"},
+}
+
+// GenerateTemplate writes a YAML seed for tableName with two sample rows
+// that fill in each of the table's columns with a value of its type.
 func (s *Seeder) GenerateTemplate(tableName string) error {
 	if err := os.MkdirAll(s.config.Seed.Directory, 0755); err != nil {
 		return fmt.Errorf("failed to create seed directory: %w", err)
 	}
 
-	template := SeedFile{
-		Name:     tableName,
-		Table:    tableName,
-		Truncate: true,
-		Data: []map[string]interface{}{
-			{
-				"id":         1,
-				"name":       "Sample Name",
-				"email":      "sample@example.com",
-				"created_at": "2023-01-01T00:00:00Z",
-			},
-			{
-				"id":         2,
-				"name":       "Another Sample",
-				"email":      "another@example.com", 
-				"created_at": "2023-01-02T00:00:00Z",
-			},
-		},
+	columns, err := s.tableColumns(tableName)
+	if err != nil {
+		return fmt.Errorf("failed to read the columns of %s: %w", tableName, err)
+	}
+	found := len(columns) > 0
+	if !found {
+		fmt.Printf("Table %s was not found; the template uses sample columns\n", tableName)
+		columns = templateColumns
+	}
+
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.Name
 	}
 
-	yamlData, err := yaml.Marshal(template)
+	var data bytes.Buffer
+	encoder, err := newSeedEncoder(&data, FormatYAML, tableName, true, names, nil)
 	if err != nil {
 		return fmt.Errorf("failed to marshal template: %w", err)
 	}
+	for row := 1; row <= 2; row++ {
+		values := make([]interface{}, len(columns))
+		for i, column := range columns {
+			values[i] = sampleValue(column, row)
+		}
+		if err := encoder.writeRow(values); err != nil {
+			return fmt.Errorf("failed to marshal template: %w", err)
+		}
+	}
+
+	description := "# Modify the data below to match your table structure.\n"
+	if found {
+		description = "# The sample rows below fill in every column; replace them with your data.\n#\n# Columns:\n"
+		for _, column := range columns {
+			description += "#   " + describeColumn(column) + "\n"
+		}
+	}
 
 	filename := filepath.Join(s.config.Seed.Directory, fmt.Sprintf("%s.yml", tableName))
 	
@@ -335,18 +1135,29 @@ func (s *Seeder) GenerateTemplate(tableName string) error {
 # Generated on: %s
 # 
 # This file contains sample data for seeding the %s table.
-# Modify the data below to match your table structure.
-#
+%s#
 # Format:
 # - name: Human readable name for this seed
 # - table: Target table name  
 # - truncate: Whether to truncate table before seeding (true/false)
+# - run: once, always or on-change (the default: rerun when this file changes)
+# - mode: insert (the default), upsert or insert_ignore
+# - depends_on: Tables whose seeds must run first (foreign keys are found automatically)
+# - tags: Labels for seed run --tag; reference data is tagged reference
+# - key: Columns that identify a row for upsert and insert_ignore
 # - data: Array of records to insert
+# - factory: Generate rows instead, e.g.
+#     factory:
+#       count: 1000
+#       seed: 42
+#       columns:
+#         id: {type: sequence}
+#         email: {type: email}
 #
 
-`, tableName, time.Now().Format("2006-01-02 15:04:05"), tableName)
+`, tableName, time.Now().Format("2006-01-02 15:04:05"), tableName, description)
 
-	content := header + string(yamlData)
+	content := header + data.String()
 
 	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
 		return fmt.Errorf("failed to write template file: %w", err)
@@ -0,0 +1,707 @@
+package seed
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"migr8/pkg/config"
+)
+
+// newTestSeeder uses the sqlite3 driver, which is mattn/go-sqlite3 in cgo
+// builds and modernc.org/sqlite otherwise, so CI covers both.
+func newTestSeeder(t *testing.T, schema ...string) *Seeder {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	var cfg config.Config
+	cfg.Database.Driver = "sqlite3"
+	cfg.Database.Database = filepath.Join(dir, "test.db")
+	cfg.Seed.Directory = filepath.Join(dir, "seeds")
+	cfg.Seed.Table = "seed_history"
+
+	if err := os.Mkdir(cfg.Seed.Directory, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSeeder(&cfg)
+	if err != nil {
+		t.Fatalf("Failed to create seeder: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	for _, stmt := range schema {
+		if _, err := s.db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to prepare schema: %v", err)
+		}
+	}
+	return s
+}
+
+func writeSeed(t *testing.T, s *Seeder, name, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(s.config.Seed.Directory, name), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write seed file: %v", err)
+	}
+}
+
+func countRows(t *testing.T, s *Seeder, table string) int {
+	t.Helper()
+
+	var n int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+		t.Fatalf("Failed to count rows in %s: %v", table, err)
+	}
+	return n
+}
+
+func TestRunTracksHistory(t *testing.T) {
+	s := newTestSeeder(t,
+		`CREATE TABLE events (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT)`,
+		`CREATE TABLE countries (code TEXT PRIMARY KEY, name TEXT)`,
+		`CREATE TABLE hits (id INTEGER PRIMARY KEY AUTOINCREMENT, path TEXT)`,
+	)
+
+	writeSeed(t, s, "001_events.yml", "table: events\nrun: once\ndata:\n  - name: launch\n")
+	writeSeed(t, s, "002_hits.yml", "table: hits\nrun: always\ndata:\n  - path: /\n")
+	writeSeed(t, s, "countries.csv", "code,name\nNL,Netherlands\n")
+
+	if err := s.Run(RunOptions{}); err != nil {
+		t.Fatalf("Failed to run seeds: %v", err)
+	}
+	if err := s.Run(RunOptions{}); err != nil {
+		t.Fatalf("Failed to rerun seeds: %v", err)
+	}
+
+	if n := countRows(t, s, "events"); n != 1 {
+		t.Errorf("Expected the once seed to run once, got %d rows", n)
+	}
+	if n := countRows(t, s, "hits"); n != 2 {
+		t.Errorf("Expected the always seed to run twice, got %d rows", n)
+	}
+
+	// Changed files rerun unless they are run-once.
+	writeSeed(t, s, "001_events.yml", "table: events\nrun: once\ndata:\n  - name: launch\n  - name: party\n")
+	writeSeed(t, s, "countries.csv", "code,name\nNL,Netherlands\nBE,Belgium\n")
+	if err := s.Run(RunOptions{}); err != nil {
+		t.Fatalf("Failed to run changed seeds: %v", err)
+	}
+	if n := countRows(t, s, "events"); n != 1 {
+		t.Errorf("Expected the once seed not to rerun after a change, got %d rows", n)
+	}
+	if n := countRows(t, s, "countries"); n != 2 {
+		t.Errorf("Expected the changed CSV seed to rerun, got %d rows", n)
+	}
+
+	if err := s.Run(RunOptions{Force: true}); err != nil {
+		t.Fatalf("Failed to force seeds: %v", err)
+	}
+	if n := countRows(t, s, "events"); n != 3 {
+		t.Errorf("Expected --force to run the once seed again, got %d rows", n)
+	}
+
+	history, err := s.history()
+	if err != nil {
+		t.Fatalf("Failed to read history: %v", err)
+	}
+	if len(history) != 3 {
+		t.Errorf("Expected one history entry per seed file, got %+v", history)
+	}
+	if entry := history["countries.csv"]; entry.Checksum == "" || entry.AppliedAt.IsZero() {
+		t.Errorf("Expected checksum and run time to be recorded, got %+v", entry)
+	}
+}
+
+func TestFailedSeedIsNotRecorded(t *testing.T) {
+	s := newTestSeeder(t, `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL)`)
+
+	writeSeed(t, s, "users.yml", "table: users\ndata:\n  - id: 1\n    email: a@example.com\n  - id: 2\n")
+	if err := s.Run(RunOptions{}); err == nil {
+		t.Fatal("Expected the seed to fail")
+	}
+
+	history, err := s.history()
+	if err != nil {
+		t.Fatalf("Failed to read history: %v", err)
+	}
+	if _, ok := history["users.yml"]; ok {
+		t.Error("Expected the failed seed not to be recorded")
+	}
+	if n := countRows(t, s, "users"); n != 0 {
+		t.Errorf("Expected the failed seed to be rolled back, got %d rows", n)
+	}
+}
+
+func TestInvalidRunMode(t *testing.T) {
+	s := newTestSeeder(t)
+
+	writeSeed(t, s, "users.yml", "table: users\nrun: sometimes\n")
+	if err := s.Run(RunOptions{}); err == nil {
+		t.Error("Expected an invalid run mode to be rejected")
+	}
+}
+
+func TestWriteModes(t *testing.T) {
+	s := newTestSeeder(t,
+		`CREATE TABLE countries (id INTEGER PRIMARY KEY AUTOINCREMENT, code TEXT NOT NULL UNIQUE, name TEXT)`,
+		`CREATE TABLE currencies (code TEXT PRIMARY KEY, name TEXT)`,
+		`INSERT INTO countries (code, name) VALUES ('NL', 'Holland'), ('XX', 'Kept')`,
+		`INSERT INTO currencies (code, name) VALUES ('EUR', 'Original')`,
+	)
+
+	writeSeed(t, s, "countries.yml", `table: countries
+mode: upsert
+key: [code]
+data:
+  - code: NL
+    name: Netherlands
+  - code: BE
+    name: Belgium
+`)
+	writeSeed(t, s, "currencies.csv", "code,name\nEUR,Euro\nUSD,Dollar\n")
+	writeSeed(t, s, "currencies.csv.yml", "mode: insert_ignore\nkey: [code]\n")
+
+	for i := 0; i < 2; i++ {
+		if err := s.Run(RunOptions{Force: true}); err != nil {
+			t.Fatalf("Failed to run seeds (pass %d): %v", i+1, err)
+		}
+	}
+
+	var name string
+	if err := s.db.QueryRow(`SELECT name FROM countries WHERE code = 'NL'`).Scan(&name); err != nil || name != "Netherlands" {
+		t.Errorf("Expected upsert to update NL, got %q (%v)", name, err)
+	}
+	if n := countRows(t, s, "countries"); n != 3 {
+		t.Errorf("Expected upsert to keep unrelated rows and add new ones, got %d rows", n)
+	}
+
+	if err := s.db.QueryRow(`SELECT name FROM currencies WHERE code = 'EUR'`).Scan(&name); err != nil || name != "Original" {
+		t.Errorf("Expected insert_ignore to keep the existing EUR row, got %q (%v)", name, err)
+	}
+	if n := countRows(t, s, "currencies"); n != 2 {
+		t.Errorf("Expected insert_ignore to add USD without truncating, got %d rows", n)
+	}
+}
+
+func TestWriteModeValidation(t *testing.T) {
+	for _, content := range []string{
+		"table: countries\nmode: upsert\n",
+		"table: countries\nmode: replace\nkey: [code]\n",
+	} {
+		s := newTestSeeder(t, `CREATE TABLE countries (code TEXT PRIMARY KEY, name TEXT)`)
+		writeSeed(t, s, "countries.yml", content)
+		if err := s.Run(RunOptions{}); err == nil {
+			t.Errorf("Expected seed to be rejected:\n%s", content)
+		}
+	}
+
+	s := newTestSeeder(t, `CREATE TABLE countries (code TEXT PRIMARY KEY, name TEXT)`)
+	writeSeed(t, s, "countries.yml", "table: countries\nmode: upsert\nkey: [code]\ndata:\n  - name: Nowhere\n")
+	if err := s.Run(RunOptions{}); err == nil {
+		t.Error("Expected a row without its key column to be rejected")
+	}
+}
+
+func TestRunOrdersByForeignKeys(t *testing.T) {
+	s := newTestSeeder(t)
+	// Every pooled connection needs foreign keys enforced.
+	s.config.Database.Database += "?_pragma=foreign_keys(1)&_foreign_keys=1"
+	s.Close()
+	s, err := NewSeeder(s.config)
+	if err != nil {
+		t.Fatalf("Failed to reconnect: %v", err)
+	}
+	defer s.Close()
+
+	for _, stmt := range []string{
+		`CREATE TABLE customers (id INTEGER PRIMARY KEY, name TEXT)`,
+		`CREATE TABLE orders (id INTEGER PRIMARY KEY, customer_id INTEGER NOT NULL REFERENCES customers(id))`,
+	} {
+		if _, err := s.db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to prepare schema: %v", err)
+		}
+	}
+
+	// File name order would seed and truncate orders' parent first.
+	writeSeed(t, s, "1_orders.yml", "table: orders\ntruncate: true\ndata:\n  - id: 1\n    customer_id: 1\n")
+	writeSeed(t, s, "customers.csv", "id,name\n1,Ann\n")
+
+	for i := 0; i < 2; i++ {
+		if err := s.Run(RunOptions{Force: true}); err != nil {
+			t.Fatalf("Failed to run seeds (pass %d): %v", i+1, err)
+		}
+	}
+	if n := countRows(t, s, "orders"); n != 1 {
+		t.Errorf("Expected 1 order, got %d", n)
+	}
+}
+
+func TestTruncationReloadsReferencingSeeds(t *testing.T) {
+	s := newTestSeeder(t)
+	s.config.Database.Database += "?_pragma=foreign_keys(1)&_foreign_keys=1"
+	s.Close()
+	s, err := NewSeeder(s.config)
+	if err != nil {
+		t.Fatalf("Failed to reconnect: %v", err)
+	}
+	defer s.Close()
+
+	for _, stmt := range []string{
+		`CREATE TABLE customers (id INTEGER PRIMARY KEY, name TEXT)`,
+		`CREATE TABLE orders (id INTEGER PRIMARY KEY, customer_id INTEGER NOT NULL REFERENCES customers(id))`,
+	} {
+		if _, err := s.db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to prepare schema: %v", err)
+		}
+	}
+
+	writeSeed(t, s, "customers.csv", "id,name\n1,Ann\n")
+	writeSeed(t, s, "orders.yml", "table: orders\nrun: once\ndata:\n  - id: 1\n    customer_id: 1\n")
+	if err := s.Run(RunOptions{}); err != nil {
+		t.Fatalf("Failed to run seeds: %v", err)
+	}
+
+	// Emptying customers takes the orders with it, so the unchanged
+	// run-once orders seed has to load again.
+	writeSeed(t, s, "customers.csv", "id,name\n1,Ann\n2,Bob\n")
+	if err := s.Run(RunOptions{}); err != nil {
+		t.Fatalf("Failed to run changed seeds: %v", err)
+	}
+	if n := countRows(t, s, "customers"); n != 2 {
+		t.Errorf("Expected 2 customers, got %d", n)
+	}
+	if n := countRows(t, s, "orders"); n != 1 {
+		t.Errorf("Expected the order to be reloaded, got %d rows", n)
+	}
+}
+
+func TestRunFactorySeeds(t *testing.T) {
+	s := newTestSeeder(t,
+		`CREATE TABLE orgs (id INTEGER PRIMARY KEY, name TEXT)`,
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, org_id INTEGER, email TEXT UNIQUE)`,
+	)
+
+	// users sorts first, but its references make it wait for orgs.
+	writeSeed(t, s, "a_users.yml", `table: users
+truncate: true
+factory:
+  count: 500
+  columns:
+    id: {type: sequence}
+    org_id: {type: reference, table: orgs}
+    email: {type: email}
+`)
+	writeSeed(t, s, "b_orgs.yml", "table: orgs\ntruncate: true\ndata:\n  - id: 10\n    name: Acme\n  - id: 20\n    name: Globex\n")
+
+	if err := s.Run(RunOptions{}); err != nil {
+		t.Fatalf("Failed to run seeds: %v", err)
+	}
+	if n := countRows(t, s, "users"); n != 500 {
+		t.Errorf("Expected 500 generated users, got %d", n)
+	}
+
+	var orphans int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM users WHERE org_id NOT IN (10, 20)`).Scan(&orphans); err != nil || orphans != 0 {
+		t.Errorf("Expected every user to reference a seeded org, got %d orphans (%v)", orphans, err)
+	}
+}
+
+func TestRunBatchesCSVRows(t *testing.T) {
+	s := newTestSeeder(t, `CREATE TABLE events (id INTEGER PRIMARY KEY, name TEXT)`)
+	s.config.Seed.BatchSize = 100
+
+	var csv strings.Builder
+	csv.WriteString("id,name\n")
+	for i := 1; i <= 250; i++ {
+		fmt.Fprintf(&csv, "%d,event %d\n", i, i)
+	}
+	writeSeed(t, s, "events.csv", csv.String())
+
+	if err := s.Run(RunOptions{}); err != nil {
+		t.Fatalf("Failed to run seeds: %v", err)
+	}
+	if n := countRows(t, s, "events"); n != 250 {
+		t.Errorf("Expected 250 rows across full and partial batches, got %d", n)
+	}
+
+	var name string
+	if err := s.db.QueryRow(`SELECT name FROM events WHERE id = 250`).Scan(&name); err != nil || name != "event 250" {
+		t.Errorf("Expected the last row of the partial batch, got %q (%v)", name, err)
+	}
+
+	// A failing row fails its batch and the whole run.
+	writeSeed(t, s, "events.csv", csv.String()+"250,duplicate\n")
+	if err := s.Run(RunOptions{}); err == nil {
+		t.Fatal("Expected a duplicate key to fail the seed")
+	}
+	if n := countRows(t, s, "events"); n != 250 {
+		t.Errorf("Expected the failed run to be rolled back, got %d rows", n)
+	}
+}
+
+func TestTypedCSVSeeds(t *testing.T) {
+	s := newTestSeeder(t,
+		`CREATE TABLE people (id INTEGER PRIMARY KEY, full_name TEXT, age INTEGER, active BOOLEAN, born TEXT)`,
+		`CREATE TABLE tags (id INTEGER PRIMARY KEY, label TEXT)`,
+		`INSERT INTO people (id, full_name) VALUES (99, 'Kept')`,
+	)
+
+	writeSeed(t, s, "001_staff.csv", "id;Name;age;active;born\n1;Ann;42;yes;1982-03-04\n2;Bob;;no;\n")
+	writeSeed(t, s, "001_staff.csv.yml", `table: people
+truncate: false
+delimiter: ";"
+null_marker: ""
+rename: {Name: full_name}
+types: {id: int, age: int, active: bool, born: date}
+`)
+	writeSeed(t, s, "labels.csv", "# table: tags\n# null_marker: \"NULL\"\nid,label\n1,NULL\n2,two\n")
+
+	if err := s.Run(RunOptions{}); err != nil {
+		t.Fatalf("Failed to run seeds: %v", err)
+	}
+
+	if n := countRows(t, s, "people"); n != 3 {
+		t.Errorf("Expected the seed not to truncate people, got %d rows", n)
+	}
+	var age, born interface{}
+	var active bool
+	if err := s.db.QueryRow(`SELECT age, active, born FROM people WHERE full_name = 'Bob'`).Scan(&age, &active, &born); err != nil {
+		t.Fatalf("Failed to read the renamed column: %v", err)
+	}
+	if age != nil || active || born != nil {
+		t.Errorf("Expected NULL age and birth date and a false flag, got %v, %v, %v", age, active, born)
+	}
+	if err := s.db.QueryRow(`SELECT age, active FROM people WHERE id = 1`).Scan(&age, &active); err != nil || age != int64(42) || !active {
+		t.Errorf("Expected typed values for Ann, got %v, %v (%v)", age, active, err)
+	}
+
+	var nulls int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM tags WHERE label IS NULL`).Scan(&nulls); err != nil || nulls != 1 {
+		t.Errorf("Expected the header comment's null marker to apply, got %d NULL labels (%v)", nulls, err)
+	}
+}
+
+func TestMalformedCSVRows(t *testing.T) {
+	tests := []struct {
+		csv      string
+		options  string
+		expected string
+	}{
+		{"id,name\n1,Ann\n2,Bob,extra\n", "", "tags.csv line 3 (row 2): expected 2 fields, got 3"},
+		{"# types: {id: int}\nid,name\n1,Ann\nx,Bob\n", "", "tags.csv line 4 (row 2), column id: invalid int \"x\""},
+		{"id,name\n1,\"Ann\n", "", "tags.csv line 2 (row 1)"},
+		{"id,name\n1,Ann\n", "types: {nope: int}\n", "typed column nope is not in the header"},
+		{"id,name\n1,Ann\n", "types: {id: integer}\n", `unknown type "integer"`},
+		{"# table: tags\nid,name\n", "table: tags\n", "both in"},
+	}
+
+	for _, tt := range tests {
+		s := newTestSeeder(t, `CREATE TABLE tags (id INTEGER PRIMARY KEY, name TEXT)`)
+		writeSeed(t, s, "tags.csv", tt.csv)
+		if tt.options != "" {
+			writeSeed(t, s, "tags.csv.yml", tt.options)
+		}
+
+		err := s.Run(RunOptions{})
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("Expected an error containing %q, got %v", tt.expected, err)
+		}
+	}
+}
+
+func TestJSONAndSQLSeeds(t *testing.T) {
+	s := newTestSeeder(t,
+		`CREATE TABLE orgs (id INTEGER PRIMARY KEY, name TEXT)`,
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, org_id INTEGER, email TEXT, settings TEXT)`,
+		`CREATE TABLE audit (id INTEGER PRIMARY KEY AUTOINCREMENT, note TEXT)`,
+	)
+
+	writeSeed(t, s, "002_orgs.json", `{"table": "orgs", "truncate": true, "data": [{"id": 1, "name": "Acme"}]}`)
+	writeSeed(t, s, "001_users.ndjson", `{"id": 1, "org_id": 1, "email": "ann@example.com"}
+{"id": 2, "org_id": 1, "email": "bob@example.com"}
+
+{"id": 3, "org_id": 1, "email": "cy@example.com", "settings": {"theme": "dark"}}
+`)
+	writeSeed(t, s, "001_users.ndjson.yml", "depends_on: [orgs]\n")
+	writeSeed(t, s, "003_audit.sql", "INSERT INTO audit (note) VALUES ('seeded; twice');\nINSERT INTO audit (note) VALUES ('done');\n")
+
+	if err := s.Run(RunOptions{}); err != nil {
+		t.Fatalf("Failed to run seeds: %v", err)
+	}
+
+	sources, err := s.loadSources(nil)
+	if err != nil {
+		t.Fatalf("Failed to load seeds: %v", err)
+	}
+	var order []string
+	for _, src := range sources {
+		order = append(order, src.filename)
+	}
+	if strings.Join(order, " ") != "002_orgs.json 001_users.ndjson 003_audit.sql" {
+		t.Errorf("Expected users to wait for orgs, got %v", order)
+	}
+
+	if n := countRows(t, s, "users"); n != 3 {
+		t.Errorf("Expected 3 users, got %d", n)
+	}
+	var settings string
+	if err := s.db.QueryRow(`SELECT settings FROM users WHERE id = 3`).Scan(&settings); err != nil || settings != `{"theme":"dark"}` {
+		t.Errorf("Expected nested objects to be stored as JSON, got %q (%v)", settings, err)
+	}
+	if n := countRows(t, s, "audit"); n != 2 {
+		t.Errorf("Expected both SQL statements to run, got %d rows", n)
+	}
+
+	writeSeed(t, s, "001_users.ndjson", "{\"id\": 4, \"org_id\": 1}\n{\"id\": 5,\n")
+	err = s.Run(RunOptions{})
+	if err == nil || !strings.Contains(err.Error(), "001_users.ndjson line 2") {
+		t.Errorf("Expected the broken row's line number, got %v", err)
+	}
+}
+
+func TestSeedSetsAndProtectedEnvironments(t *testing.T) {
+	s := newTestSeeder(t,
+		`CREATE TABLE countries (code TEXT PRIMARY KEY)`,
+		`CREATE TABLE users (id INTEGER PRIMARY KEY)`,
+	)
+	for _, dir := range []string{"common", "dev"} {
+		if err := os.Mkdir(filepath.Join(s.config.Seed.Directory, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeSeed(t, s, "common/countries.csv", "code\nNL\n")
+	writeSeed(t, s, "common/countries.csv.yml", "tags: [reference]\n")
+	writeSeed(t, s, "dev/users.yml", "table: users\ntags: [fake]\ndata:\n  - id: 1\n")
+
+	filenames := func(opts RunOptions) string {
+		t.Helper()
+		sources, err := s.loadSources(opts.Tags)
+		if err != nil {
+			t.Fatalf("Failed to load seeds: %v", err)
+		}
+		var names []string
+		for _, src := range sources {
+			names = append(names, src.filename)
+		}
+		return strings.Join(names, " ")
+	}
+
+	if got := filenames(RunOptions{}); got != "common/countries.csv" {
+		t.Errorf("Expected only the common set without an environment, got %q", got)
+	}
+	s.config.Seed.Environment = "dev"
+	if got := filenames(RunOptions{}); got != "common/countries.csv dev/users.yml" {
+		t.Errorf("Expected the common and dev sets, got %q", got)
+	}
+	if got := filenames(RunOptions{Tags: []string{"reference"}}); got != "common/countries.csv" {
+		t.Errorf("Expected --tag to select reference data, got %q", got)
+	}
+
+	s.config.Seed.ProtectedEnvironments = []string{"Dev"}
+	if err := s.Run(RunOptions{Force: true}); err == nil || !strings.Contains(err.Error(), "dev/users.yml") {
+		t.Fatalf("Expected the protected environment to refuse fake data, got %v", err)
+	}
+	if n := countRows(t, s, "countries"); n != 0 {
+		t.Errorf("Expected nothing to run when a seed is refused, got %d countries", n)
+	}
+
+	if err := s.Run(RunOptions{Tags: []string{TagReference}}); err != nil {
+		t.Fatalf("Expected reference data to run against a protected environment: %v", err)
+	}
+	if n := countRows(t, s, "countries"); n != 1 {
+		t.Errorf("Expected the reference seed to run, got %d countries", n)
+	}
+}
+
+// dumpUsers renders the users table for comparing exports with the rows
+// they were taken from.
+func dumpUsers(t *testing.T, s *Seeder) string {
+	t.Helper()
+
+	rows, err := s.db.Query(`SELECT id, name, email, active, created_at FROM users ORDER BY id`)
+	if err != nil {
+		t.Fatalf("Failed to query users: %v", err)
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var (
+			id        int
+			active    bool
+			name      string
+			email     *string
+			createdAt time.Time
+		)
+		if err := rows.Scan(&id, &name, &email, &active, &createdAt); err != nil {
+			t.Fatalf("Failed to scan user: %v", err)
+		}
+		e := "NULL"
+		if email != nil {
+			e = *email
+		}
+		out = append(out, fmt.Sprintf("%d %s %s %t %s", id, name, e, active, createdAt.UTC().Format(time.RFC3339)))
+	}
+	return strings.Join(out, "\n")
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	s := newTestSeeder(t,
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL, email TEXT, active BOOLEAN, created_at DATETIME)`,
+		`INSERT INTO users VALUES (1, 'Ann', 'ann@example.com', 1, '2024-03-01 10:00:00'),
+			(2, 'Bob "B", Jr.', NULL, 0, '2024-03-02 11:30:00'),
+			(3, 'null', '', 1, '2024-03-03 12:00:00')`,
+	)
+	want := dumpUsers(t, s)
+
+	for _, format := range []string{FormatYAML, FormatJSON, FormatCSV} {
+		output := filepath.Join(s.config.Seed.Directory, "users_"+format+"."+format)
+		if err := s.Export("users", ExportOptions{Format: format, Output: output}); err != nil {
+			t.Fatalf("Failed to export %s: %v", format, err)
+		}
+		if _, err := s.db.Exec(`DELETE FROM users`); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Run(RunOptions{}); err != nil {
+			t.Fatalf("Failed to load the %s export: %v", format, err)
+		}
+		if got := dumpUsers(t, s); got != want {
+			t.Errorf("%s export did not round trip:\n%s\nwant:\n%s", format, got, want)
+		}
+		if err := os.Remove(output); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output := filepath.Join(s.config.Seed.Directory, "users.yml")
+	opts := ExportOptions{Where: "id > 1", Limit: 1, Mask: []string{"name"}, Output: output}
+	if err := s.Export("users", opts); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	content, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "id: 2") || strings.Contains(string(content), "id: 3") {
+		t.Errorf("Expected only the first row after id 1, got:\n%s", content)
+	}
+	if !strings.Contains(string(content), "name: '************'") || strings.Contains(string(content), "Bob") {
+		t.Errorf("Expected the name to be masked, got:\n%s", content)
+	}
+
+	if err := s.Export("users", opts); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Expected an existing file to be kept, got %v", err)
+	}
+	opts.Mask = []string{"phone"}
+	opts.Force = true
+	if err := s.Export("users", opts); err == nil {
+		t.Error("Expected an error for masking an unknown column")
+	}
+}
+
+func TestGenerateTemplateFromColumns(t *testing.T) {
+	s := newTestSeeder(t,
+		`CREATE TABLE accounts (id INTEGER PRIMARY KEY, owner_email VARCHAR(100) NOT NULL, balance DECIMAL(10,2), verified BOOLEAN, opened_on DATE)`,
+	)
+
+	if err := s.GenerateTemplate("accounts"); err != nil {
+		t.Fatalf("Failed to generate template: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(s.config.Seed.Directory, "accounts.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"#   owner_email VARCHAR(100), not null",
+		"owner_email: sample1@example.com",
+		"balance: 1.5",
+		"verified: true",
+		"opened_on: \"2023-01-01\"",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("Expected the template to contain %q, got:\n%s", expected, content)
+		}
+	}
+
+	if err := s.Run(RunOptions{}); err != nil {
+		t.Fatalf("Failed to load the template: %v", err)
+	}
+	if n := countRows(t, s, "accounts"); n != 2 {
+		t.Errorf("Expected 2 sample rows, got %d", n)
+	}
+
+	if err := s.GenerateTemplate("widgets"); err != nil {
+		t.Fatalf("Failed to generate template: %v", err)
+	}
+	content, err = os.ReadFile(filepath.Join(s.config.Seed.Directory, "widgets.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "email: sample2@example.com") {
+		t.Errorf("Expected sample columns for a missing table, got:\n%s", content)
+	}
+}
+
+func TestExportAnonymized(t *testing.T) {
+	s := newTestSeeder(t,
+		`CREATE TABLE customers (id INTEGER PRIMARY KEY, email TEXT NOT NULL, phone TEXT, notes TEXT)`,
+		`CREATE TABLE orders (id INTEGER PRIMARY KEY, customer_email TEXT)`,
+		`INSERT INTO customers VALUES (1, 'ann@corp.example', '555-0100', 'likes tea'), (2, 'bob@corp.example', NULL, NULL)`,
+		`INSERT INTO orders VALUES (10, 'ann@corp.example'), (11, 'bob@corp.example')`,
+	)
+	t.Setenv("MIGR8_ANONYMIZE_SALT", "pepper")
+	s.config.Anonymize.SaltEnv = "MIGR8_ANONYMIZE_SALT"
+	s.config.Anonymize.Tables = map[string]map[string]string{
+		"customers": {"email": "fake_email", "phone": "preserve_format", "notes": "nullify"},
+		"orders":    {"customer_email": "fake_email"},
+	}
+
+	for _, table := range []string{"customers", "orders"} {
+		if err := s.Export(table, ExportOptions{Format: FormatCSV, Anonymize: true}); err != nil {
+			t.Fatalf("Failed to export %s: %v", table, err)
+		}
+	}
+	customers, err := os.ReadFile(filepath.Join(s.config.Seed.Directory, "customers.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders, err := os.ReadFile(filepath.Join(s.config.Seed.Directory, "orders.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"ann@corp", "bob@corp", "555-0100", "likes tea"} {
+		if strings.Contains(string(customers)+string(orders), secret) {
+			t.Errorf("Expected %q to be anonymized, got:\n%s\n%s", secret, customers, orders)
+		}
+	}
+
+	if _, err := s.db.Exec(`DELETE FROM customers; DELETE FROM orders`); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(RunOptions{}); err != nil {
+		t.Fatalf("Failed to load the anonymized export: %v", err)
+	}
+	var joined int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM orders o JOIN customers c ON c.email = o.customer_email`).Scan(&joined); err != nil {
+		t.Fatal(err)
+	}
+	if joined != 2 {
+		t.Errorf("Expected anonymized emails to still join, got %d matches", joined)
+	}
+	var notes *string
+	if err := s.db.QueryRow(`SELECT notes FROM customers WHERE id = 1`).Scan(&notes); err != nil || notes != nil {
+		t.Errorf("Expected notes to be nullified, got %v (%v)", notes, err)
+	}
+
+	s.config.Anonymize.Tables["orders"] = map[string]string{"email": "hash"}
+	err = s.Export("orders", ExportOptions{Anonymize: true, Force: true})
+	if err == nil || !strings.Contains(err.Error(), "email") {
+		t.Errorf("Expected an error for a rule naming a missing column, got %v", err)
+	}
+}